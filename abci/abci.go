@@ -1,17 +1,27 @@
 package abci
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	analytics2 "github.com/chainpoint/chainpoint-core/analytics"
 	"github.com/chainpoint/chainpoint-core/anchor"
 	"github.com/chainpoint/chainpoint-core/anchor/bitcoin"
+	"github.com/chainpoint/chainpoint-core/anchor/ethereum"
+	"github.com/chainpoint/chainpoint-core/beacon"
 	"github.com/chainpoint/chainpoint-core/database"
 	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/database/postgres"
+	"github.com/chainpoint/chainpoint-core/events"
+	"github.com/chainpoint/chainpoint-core/fee"
+	"github.com/chainpoint/chainpoint-core/ledger"
+	"github.com/chainpoint/chainpoint-core/pricing"
 	"github.com/chainpoint/chainpoint-core/tendermintrpc"
 	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
+	"github.com/chainpoint/chainpoint-core/webhook"
 	"github.com/tendermint/tendermint/abci/example/code"
 	"net"
 	"path"
@@ -42,6 +52,15 @@ var (
 
 const SUCCESSFUL_ANCHOR_CRITERIA = 100
 
+// DRAND_STALE_BLOCKS : blocks without a DRAND tx after which Cores fall back to Calendar block hash entropy
+const DRAND_STALE_BLOCKS = 10
+
+// ANNOUNCEMENT_BLOCKS : blocks an anchor tx announcement is kept for, after which the tx can no longer be replaced or confirmed
+const ANNOUNCEMENT_BLOCKS = 7 * 24 * 60
+
+// FEE_STALE_INTERVALS : fee intervals without a FEE tx after which Cores anchor at their own fee estimates
+const FEE_STALE_INTERVALS = 3
+
 // loadState loads the AnchorState struct from a database instance
 func loadState(db dbm.DB) types.AnchorState {
 	stateBytes, err := db.Get(stateKey)
@@ -54,10 +73,49 @@ func loadState(db dbm.DB) types.AnchorState {
 		if err != nil {
 			panic(err)
 		}
+		if state.Anchors == nil {
+			migrateBtcCursor(stateBytes, &state)
+		}
+	}
+	// the Calendar carries the anchors of every chain, including those this Core doesn't anchor to
+	for _, name := range []string{bitcoin.AnchorName, ethereum.AnchorName} {
+		state.AddCursor(name)
 	}
 	return state
 }
 
+// legacyBtcState : the bitcoin anchoring fields kept at the top level of AnchorState before engines had their own cursors
+type legacyBtcState struct {
+	BeginCalTxInt    int64  `json:"begin_cal_int"`
+	EndCalTxInt      int64  `json:"end_cal_int"`
+	LatestBtcaTxInt  int64  `json:"latest_btca_int"`
+	LatestBtcaHeight int64  `json:"latest_btca_height"`
+	LatestBtcTx      string `json:"latest_btc"`
+	LatestBtcAggRoot string `json:"latest_btc_root"`
+	LatestBtccTx     []byte `json:"latest_btcc"`
+	LatestBtccTxInt  int64  `json:"latest_btcc_int"`
+	LatestBtccHeight int64  `json:"latest_btcc_height"`
+}
+
+// migrateBtcCursor moves the bitcoin anchoring fields of state saved by earlier versions into the btc engine's cursor
+func migrateBtcCursor(stateBytes []byte, state *types.AnchorState) {
+	var legacy legacyBtcState
+	if util.LogError(json.Unmarshal(stateBytes, &legacy)) != nil {
+		return
+	}
+	*state.AddCursor(bitcoin.AnchorName) = types.AnchorCursor{
+		BeginCalTxInt:       legacy.BeginCalTxInt,
+		EndCalTxInt:         legacy.EndCalTxInt,
+		LatestAnchorHeight:  legacy.LatestBtcaHeight,
+		LatestAnchorTxInt:   legacy.LatestBtcaTxInt,
+		LatestAnchorTx:      legacy.LatestBtcTx,
+		LatestAnchorRoot:    legacy.LatestBtcAggRoot,
+		LatestConfirmHeight: legacy.LatestBtccHeight,
+		LatestConfirmTxInt:  legacy.LatestBtccTxInt,
+		LatestConfirmed:     string(legacy.LatestBtccTx),
+	}
+}
+
 //loadState saves the AnchorState struct to disk
 func saveState(Db dbm.DB, state types.AnchorState) {
 	stateBytes, err := json.Marshal(state)
@@ -82,7 +140,7 @@ type AnchorApplication struct {
 	NodeRewardSignatures []string
 	CoreRewardSignatures []string
 	Db                   dbm.DB
-	Anchor               anchor.AnchorEngine
+	Anchors              *anchor.Registry
 	state                *types.AnchorState
 	config               types.AnchorConfig
 	logger               log.Logger
@@ -90,11 +148,21 @@ type AnchorApplication struct {
 	ChainpointDb         database.ChainpointDatabase
 	Cache                *level.KVStore
 	LnClient             *lightning.LightningClient
+	BtcChain             bitcoin.ChainBackend
+	FeeEstimators        []fee.Estimator
+	SpendPolicy          *bitcoin.SpendPolicy
+	Ledger               *ledger.Ledger
+	Pricing              *pricing.Engine
 	rpc                  *tendermintrpc.RPC
 	ID                   string
 	JWK                  types.Jwk
 	Analytics            *analytics2.UniversalAnalytics
 	ULIDGenerator        *ulidthreadsafe.ThreadSafeUlid
+	Webhooks             *webhook.Notifier
+	Events               *events.Broker
+	localFeeHeight       int64 // height this Core last fell back to its own fee estimate
+	ready                chan struct{}
+	cancel               context.CancelFunc
 }
 
 //NewAnchorApplication is ABCI app constructor
@@ -139,31 +207,86 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 
 	cache := level.NewKVStore(&db, *config.Logger)
 
-	var database database.ChainpointDatabase = level.NewDB(cache)
+	var database database.ChainpointDatabase
+	switch config.DBType {
+	case "postgres":
+		var pg *postgres.Postgres
+		if config.PostgresURI != "" {
+			pg, err = postgres.NewPostgres(config.PostgresURI, config.Retention, *config.Logger)
+		} else {
+			pg, err = postgres.NewEmbeddedPostgres(config.HomePath, uint32(config.PostgresPort), config.Retention, *config.Logger)
+		}
+		if err != nil {
+			fmt.Println("Could not connect to postgres")
+			panic(err)
+		}
+		database = pg
+	default:
+		database = level.NewDB(cache, config.Retention)
+	}
+
+	finalStage := webhook.StageBtc
+	if config.DoEthAnchor {
+		finalStage = webhook.StageEth
+	}
+	webhooks := webhook.NewNotifier(cache, config.ECPrivateKey, jwkType.Kid, finalStage, *config.Logger)
+
+	broker := events.NewBroker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// every Core follows bitcoin, since staking and rewards depend on it, but only anchors to it if anchoring is enabled
+	anchors := anchor.NewRegistry()
+	var btcChain bitcoin.ChainBackend = bitcoin.NewLndBackend(&config.LightningConfig)
+	if config.BtcBackend == "bitcoind" {
+		btcChain = bitcoin.NewBitcoindBackend(config.BitcoindConfig)
+	}
+	feeEstimators, err := fee.NewEstimators(config.FeeSources, config.BtcBackend, btcChain)
+	if err != nil {
+		fmt.Println("Could not create fee estimators")
+		panic(err)
+	}
+	spendPolicy := bitcoin.NewSpendPolicy(config.SpendPolicy, btcChain, cache)
+	txLedger := ledger.NewLedger(db)
+	btcEngine := bitcoin.NewBTCAnchorEngine(state, config, rpcClient, &database, cache, &config.LightningConfig, btcChain, spendPolicy, txLedger, *config.Logger, &analytics, webhooks, broker)
+	anchors.Register(bitcoin.AnchorName, int64(config.AnchorInterval), config.DoAnchor, btcEngine)
 
-	var anchorEngine anchor.AnchorEngine = bitcoin.NewBTCAnchorEngine(state, config, rpcClient, &database, cache, &config.LightningConfig, *config.Logger, &analytics)
+	if config.DoEthAnchor {
+		ethEngine, err := ethereum.NewETHAnchorEngine(state, config, rpcClient, &database, cache, *config.Logger, webhooks, broker)
+		if err != nil {
+			fmt.Println("Could not create eth anchor engine")
+			panic(err)
+		}
+		anchors.Register(ethereum.AnchorName, int64(config.EthConfig.AnchorInterval), true, ethEngine)
+	}
 
 	//Construct application
 	app := AnchorApplication{
 		valAddrToPubKeyMap:   map[string]types2.PubKey{},
 		Db:                   db,
-		Anchor:               anchorEngine,
+		Anchors:              anchors,
 		state:                state,
 		config:               config,
 		logger:               *config.Logger,
 		NodeRewardSignatures: make([]string, 0),
 		CoreRewardSignatures: make([]string, 0),
-		aggregator: &aggregator.Aggregator{
-			Logger:  *config.Logger,
-			UlidGen: ulidGenerator,
-		},
+		aggregator:           aggregator.NewAggregator(*config.Logger, ulidGenerator, aggregator.NewWAL(db)),
 		ChainpointDb:  database,
 		Cache:         cache,
 		LnClient:      &config.LightningConfig,
+		BtcChain:      btcChain,
+		FeeEstimators: feeEstimators,
+		SpendPolicy:   spendPolicy,
+		Ledger:        txLedger,
+		Pricing:       pricing.NewEngine(config.HashPricing, cache),
 		rpc:           rpcClient,
 		JWK:           jwkType,
 		Analytics:     &analytics,
 		ULIDGenerator: ulidGenerator,
+		Webhooks:      webhooks,
+		Events:        broker,
+		ready:         make(chan struct{}),
+		cancel:        cancel,
 	}
 
 	app.logger.Info("Tendermint Block Height", "block_height", app.state.Height)
@@ -174,9 +297,20 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 		app.logger.Info("Tendermint Proposed Validator", "proposed_validator", config.ProposedVal)
 	}
 
+	// Resume aggregating with the entropy in use before the last shutdown until the beacon is polled again
+	if state.LatestEntropy.Source != "" {
+		app.aggregator.SetEntropy(state.LatestEntropy)
+	}
+
+	// Recover hashes accepted but not committed to a CAL tx before the last shutdown
+	recovered, err := app.aggregator.Replay()
+	if app.LogError(err) == nil && recovered > 0 {
+		app.logger.Info("Recovered hashes from aggregator WAL", "count", recovered)
+	}
+
 	//Initialize calendar writing if enabled
 	if config.DoCal {
-		go app.aggregator.StartAggregation()
+		go app.aggregator.StartAggregation(ctx)
 	}
 
 	go app.SyncMonitor() //make sure we're synced
@@ -192,11 +326,11 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 
 	//Migrations
 	/*	if _, exists := app.state.Migrations[1]; !exists && config.ChainId == "mainnet-chain-32" {
-			app.state.BeginCalTxInt = 3096
+			app.state.Cursor(bitcoin.AnchorName).BeginCalTxInt = 3096
 			app.state.Migrations[1] = "BeginCalTxInt=3096"
 		}
 		if _, exists := app.state.Migrations[2]; !exists && config.ChainId == "mainnet-chain-32" {
-			app.state.LatestBtcaHeight = 17399
+			app.state.Cursor(bitcoin.AnchorName).LatestAnchorHeight = 17399
 			app.state.Migrations[2] = "LatestBtcaHeight=17399"
 		}*/
 
@@ -214,6 +348,21 @@ func (app *AnchorApplication) SetOption(req types2.RequestSetOption) (res types2
 
 // InitChain : Save the validators in the merkle tree
 func (app *AnchorApplication) InitChain(req types2.RequestInitChain) types2.ResponseInitChain {
+	// a Calendar can name the drand chain it accepts rounds from in the genesis app_state, e.g. {"drand": {"public_key": ...}}
+	if len(req.AppStateBytes) > 0 {
+		var appState struct {
+			Drand beacon.Network `json:"drand"`
+		}
+		if err := json.Unmarshal(req.AppStateBytes, &appState); err != nil {
+			panic(err)
+		}
+		if appState.Drand.PublicKey != "" {
+			if _, err := beacon.ParsePublicKey(appState.Drand.PublicKey); err != nil {
+				panic(err)
+			}
+			app.state.DrandNetwork = appState.Drand
+		}
+	}
 	for _, v := range req.Validators {
 		r := app.updateValidator(v)
 		if r.IsErr() {
@@ -252,6 +401,8 @@ func (app *AnchorApplication) CheckTx(rawTx types2.RequestCheckTx) types2.Respon
 // BeginBlock : Handler that runs at the beginning of every block
 func (app *AnchorApplication) BeginBlock(req types2.RequestBeginBlock) types2.ResponseBeginBlock {
 	app.ValUpdates = make([]types2.ValidatorUpdate, 0)
+	app.state.LatestBlockHash = hex.EncodeToString(req.Hash)
+	app.state.LatestBlockHeight = req.Header.Height
 	return types2.ResponseBeginBlock{}
 }
 
@@ -267,15 +418,17 @@ func (app *AnchorApplication) EndBlock(req types2.RequestEndBlock) types2.Respon
 
 	// monitor confirmed tx. Run on a separate thread but in order
 	if app.state.ChainSynced {
-		go func() {
-			app.Anchor.BlockSyncMonitor()
-			if app.config.DoAnchor {
-				app.Anchor.MonitorConfirmedTx()
-				app.Anchor.MonitorFailedAnchor() //must be roughly synchronous with chain operation in order to recover from failed anchors
-			}
-		}()
+		for _, engine := range app.Anchors.Engines() {
+			go func(engine anchor.Registration) {
+				engine.BlockSyncMonitor()
+				if engine.Active {
+					engine.MonitorConfirmedTx()
+					engine.MonitorFailedAnchor() //must be roughly synchronous with chain operation in order to recover from failed anchors
+				}
+			}(engine)
+		}
 		if app.config.DoAnchor {
-			go app.ChainpointDb.PruneOldState()
+			go app.PruneState()
 		}
 	}
 	// check if we need to vote on a pending validator proposal
@@ -362,6 +515,11 @@ func (app *AnchorApplication) Query(reqQuery types2.RequestQuery) (resQuery type
 	return
 }
 
+// Stop : stops background aggregation. Hashes not yet anchored remain in the aggregator WAL
+func (app *AnchorApplication) Stop() {
+	app.cancel()
+}
+
 func (app *AnchorApplication) LogError(err error) error {
 	if err != nil {
 		app.logger.Error(fmt.Sprintf("Error in %s: %s", util.GetCurrentFuncName(2), err.Error()))
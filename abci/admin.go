@@ -0,0 +1,87 @@
+package abci
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/archive"
+	"github.com/chainpoint/chainpoint-core/ledger"
+	"github.com/chainpoint/chainpoint-core/util"
+)
+
+// ArchiveExportHandler : streams btc-anchored proofs for the requested btctx_id(s) or from/to time range as a tar.gz archive
+func (app *AnchorApplication) ArchiveExportHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Archive Export Client IP: %s", ip))
+	query := r.URL.Query()
+	btcTxIds := query["btctx_id"]
+	if len(btcTxIds) == 0 {
+		from, errFrom := time.Parse(time.RFC3339, query.Get("from"))
+		to, errTo := time.Parse(time.RFC3339, query.Get("to"))
+		if errFrom != nil || errTo != nil || to.Before(from) {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "btctx_id or an RFC3339 from/to range is required"})
+			return
+		}
+		ids, err := app.rpc.GetBtcTxIdsInRange(from.Unix(), to.Unix())
+		if app.LogError(err) != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not search for btc anchors in range"})
+			return
+		}
+		btcTxIds = ids
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"proofs-%d.tar.gz\"", time.Now().Unix()))
+	manifest, err := archive.Export(app.ChainpointDb, btcTxIds, w)
+	if app.LogError(err) != nil {
+		// headers are already sent, so the truncated archive is the only signal to the client
+		return
+	}
+	app.logger.Info("Proof archive exported", "btctx_count", len(manifest.BtcTxIds), "proof_count", manifest.ProofCount)
+}
+
+// ArchiveImportHandler : restores proofs from a tar.gz archive produced by ArchiveExportHandler
+func (app *AnchorApplication) ArchiveImportHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Archive Import Client IP: %s", ip))
+	count, err := archive.Import(app.ChainpointDb, r.Body)
+	if app.LogError(err) != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error(), "imported": count})
+		return
+	}
+	app.logger.Info("Proof archive imported", "proof_count", count)
+	respondJSON(w, http.StatusOK, map[string]interface{}{"imported": count})
+}
+
+// LedgerHandler : lists the ledger entries in an optional RFC3339 from/to range, as JSON with totals per kind or as CSV if format=csv
+func (app *AnchorApplication) LedgerHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Ledger Client IP: %s", ip))
+	query := r.URL.Query()
+	from, to := time.Unix(0, 0), time.Now().Add(time.Second)
+	var err error
+	if query.Get("from") != "" {
+		if from, err = time.Parse(time.RFC3339, query.Get("from")); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "from must be an RFC3339 time"})
+			return
+		}
+	}
+	if query.Get("to") != "" {
+		if to, err = time.Parse(time.RFC3339, query.Get("to")); err != nil || to.Before(from) {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "to must be an RFC3339 time after from"})
+			return
+		}
+	}
+	entries, err := app.Ledger.Entries(from, to)
+	if app.LogError(err) != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not read the ledger"})
+		return
+	}
+	if query.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ledger-%d.csv\"", time.Now().Unix()))
+		app.LogError(ledger.WriteCSV(w, entries))
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"entries": entries, "totals": ledger.Totals(entries)})
+}
@@ -6,27 +6,37 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chainpoint/chainpoint-core/calendar"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/chainpoint/chainpoint-core/events"
 	"github.com/chainpoint/chainpoint-core/proof"
 	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/chainpoint/chainpoint-core/util"
+	"github.com/chainpoint/chainpoint-core/webhook"
+	core_types "github.com/tendermint/tendermint/rpc/core/types"
 )
 
-// StartAnchoring: StartAnchoring calendar and btc blockchains
+// StartAnchoring: StartAnchoring calendar and every active anchor engine whose interval has passed
 func (app *AnchorApplication) StartAnchoring() {
 	// Run AnchorCalendar and AnchorToChain one after another
 	if app.state.ChainSynced && app.config.DoCal {
 		go app.AnchorCalendar(app.state.Height)
 	}
-	if app.config.DoAnchor && (app.state.Height-app.state.LatestBtcaHeight) > int64(app.config.AnchorInterval) {
+	for _, engine := range app.Anchors.Engines() {
+		cursor := app.state.Cursor(engine.Name)
+		if !engine.Active || (app.state.Height-cursor.LatestAnchorHeight) <= engine.Interval {
+			continue
+		}
 		if app.state.ChainSynced {
 			// prevent current height, non-indexed cal roots from being anchored
-			if app.state.LatestCalTxInt-app.state.BeginCalTxInt > app.state.CurrentCalInts {
-				go app.Anchor.AnchorToChain(app.state.BeginCalTxInt, app.state.LatestCalTxInt-app.state.CurrentCalInts)
+			if app.state.LatestCalTxInt-cursor.BeginCalTxInt > app.state.CurrentCalInts {
+				go engine.AnchorToChain(cursor.BeginCalTxInt, app.state.LatestCalTxInt-app.state.CurrentCalInts)
 			}
 		} else {
-			app.state.EndCalTxInt = app.state.LatestCalTxInt
+			cursor.EndCalTxInt = app.state.LatestCalTxInt
 		}
 	}
 	app.state.CurrentCalInts = 0
@@ -41,7 +51,7 @@ func (app *AnchorApplication) AnchorCalendar(height int64) (int, error) {
 	aggStates := make([]types.AggState, 0)
 	for _, agg := range aggs {
 		aggStates = append(aggStates, agg.AggStates...)
-		app.LogError(app.ChainpointDb.BulkInsertAggState(agg.AggStates))
+		app.Events.Publish(events.Event{Type: events.Aggregation, AggRoot: agg.AggRoot, ProofIDs: aggProofIds(agg.AggStates)})
 	}
 	app.logger.Debug(fmt.Sprintf("Aggregated %d roots: ", len(aggs)))
 	app.logger.Debug(fmt.Sprintf("Aggregation Tree: %#v", aggs))
@@ -51,13 +61,23 @@ func (app *AnchorApplication) AnchorCalendar(height int64) (int, error) {
 	if calAgg.CalRoot != "" {
 		app.logger.Info(fmt.Sprintf("Calendar Root: %s", calAgg.CalRoot))
 		app.logger.Debug(fmt.Sprintf("Calendar Tree: %#v", calAgg))
-		result, err := app.rpc.BroadcastTx("CAL", calAgg.CalRoot, 2, time.Now().Unix(), app.ID, app.config.ECPrivateKey)
+		delivered := false
+		result, calTxHash, err := app.rpc.BroadcastTxAndHash("CAL", calAgg.CalRoot, 2, time.Now().Unix(), app.ID, app.config.ECPrivateKey)
 		if app.LogError(err) != nil {
-			return 0, err
+			// the CAL tx may have been accepted before the broadcast failed, so it's looked up before its hashes are given
+			// another chance, which would otherwise anchor them twice under two agg ids
+			committed, found := app.findCalTx(calTxHash, aggs)
+			if !found {
+				return 0, err
+			}
+			result, delivered = committed, true
 		}
 		go app.Analytics.SendEvent(app.state.LatestTimeRecord, "CreateCalTx", calAgg.CalRoot, time.Now().Format(time.RFC3339), "", "", "")
 		app.logger.Debug(fmt.Sprintf("CAL result: %+v", result))
 		if result.Code == 0 {
+			for _, agg := range aggs {
+				app.LogError(app.ChainpointDb.BulkInsertAggState(agg.AggStates))
+			}
 			var tx types.TxTm
 			tx.Hash = result.Hash.Bytes()
 			tx.Data = result.Data.Bytes()
@@ -65,16 +85,49 @@ func (app *AnchorApplication) AnchorCalendar(height int64) (int, error) {
 			app.logger.Info(fmt.Sprintf("Cal States for CalRoot %s: %#v", calAgg.CalRoot, calStates))
 			app.logger.Info("Generating Cal Batch")
 			app.LogError(app.ChainpointDb.BulkInsertCalState(calStates))
-			app.LogError(app.GenerateCalBatch(aggStates, calStates))
+			if app.LogError(app.GenerateCalBatch(aggStates, calStates)) == nil {
+				app.LogError(app.aggregator.Commit(aggs))
+			}
 			hashRoot := hex.EncodeToString(tx.Hash)
 			app.Cache.Set(hashRoot, calAgg.CalRoot)
+			if delivered {
+				app.Events.Publish(events.Event{Type: events.Cal, CalTx: hashRoot, ProofIDs: aggProofIds(aggStates)})
+			} else {
+				app.Events.Hold(hashRoot, aggProofIds(aggStates))
+			}
 			app.logger.Info("Generating Cal Batch Complete")
 			return len(aggs), nil
 		}
+		app.aggregator.Requeue(aggs)
 	}
 	return 0, errors.New("No hashes to aggregate")
 }
 
+// CAL_LOOKUP_BLOCKS : blocks a CAL tx whose broadcast failed ambiguously is looked for before its hashes are requeued
+const CAL_LOOKUP_BLOCKS = 3
+
+// calLookupInterval : how often findCalTx looks for a CAL tx, once per Calendar block
+var calLookupInterval = time.Minute
+
+// findCalTx : waits for a CAL tx whose broadcast failed ambiguously to be committed, returning it as a broadcast result if
+// it was. Its aggregations are requeued if it's still not found after CAL_LOOKUP_BLOCKS blocks. If it can't be looked up at
+// all they're left to the WAL, which replays them on restart, rather than risk anchoring them twice
+func (app *AnchorApplication) findCalTx(hash []byte, aggs []types.Aggregation) (core_types.ResultBroadcastTx, bool) {
+	for i := 0; i < CAL_LOOKUP_BLOCKS; i++ {
+		time.Sleep(calLookupInterval)
+		txResult, found, err := app.rpc.FindTx(hash)
+		if app.LogError(err) != nil {
+			return core_types.ResultBroadcastTx{}, false
+		}
+		if found {
+			app.logger.Info("CAL tx was committed despite its broadcast failing", "hash", hex.EncodeToString(hash))
+			return core_types.ResultBroadcastTx{Code: txResult.TxResult.Code, Data: txResult.TxResult.Data, Hash: txResult.Hash}, true
+		}
+	}
+	app.aggregator.Requeue(aggs)
+	return core_types.ResultBroadcastTx{}, false
+}
+
 func (app *AnchorApplication) GenerateCalBatch(aggStates []types.AggState, calStates []types.CalStateObject) error {
 	app.logger.Info(util.GetCurrentFuncName(1))
 	calLookUp := make(map[string]string)
@@ -85,6 +138,7 @@ func (app *AnchorApplication) GenerateCalBatch(aggStates []types.AggState, calSt
 	for _, aggStateRow := range aggStates {
 		proof := proof.Proof()
 		app.LogError(proof.AddChainpointHeader("https://w3id.org/chainpoint/v5", "Chainpoint", aggStateRow.Hash, aggStateRow.ProofID))
+		proof.AddHashAlgorithm(aggStateRow.HashAlgorithm)
 		app.LogError(proof.AddCalendarBranch(aggStateRow, calLookUp[aggStateRow.AggID], proof.SetProofType(app.config.BitcoinNetwork, "cal")))
 		proofBytes, err := json.Marshal(proof)
 		app.logger.Info(fmt.Sprintf("Proof: %s", string(proofBytes)))
@@ -97,5 +151,106 @@ func (app *AnchorApplication) GenerateCalBatch(aggStates []types.AggState, calSt
 		}
 		proofs = append(proofs, proofState)
 	}
-	return app.LogError(app.ChainpointDb.BulkInsertProofs(proofs))
+	if err := app.LogError(app.ChainpointDb.BulkInsertProofs(proofs)); err != nil {
+		return err
+	}
+	app.Webhooks.Enqueue(proofs, webhook.StageCal)
+	return nil
+}
+
+func aggProofIds(aggStates []types.AggState) []string {
+	proofIds := make([]string, 0, len(aggStates))
+	for _, aggState := range aggStates {
+		proofIds = append(proofIds, aggState.ProofID)
+	}
+	return proofIds
+}
+
+var calendarDataUriRegex = regexp.MustCompile(`/calendar/([a-fA-F0-9]{64})/data`)
+
+// VerifyAnchor : checks a proof anchor against the CAL, BTC-C or ETH-C transaction recorded in the Calendar
+func (app *AnchorApplication) VerifyAnchor(anchor proof.Anchor, value []byte) error {
+	expected := proof.ExpectedAnchorValue(anchor.Type, value)
+	switch anchor.Type {
+	case "cal", "tcal":
+		tx, err := app.getCalendarTx(anchor.AnchorID)
+		if err != nil {
+			return err
+		}
+		if tx.TxType != "CAL" {
+			return fmt.Errorf("anchor %s is a %s tx, not CAL", anchor.AnchorID, tx.TxType)
+		}
+		if !strings.EqualFold(tx.Data, expected) {
+			return fmt.Errorf("cal anchor %s attests to %s, not %s", anchor.AnchorID, tx.Data, expected)
+		}
+		return nil
+	case "btc", "tbtc":
+		var btccTxId string
+		for _, uri := range anchor.Uris {
+			if match := calendarDataUriRegex.FindStringSubmatch(uri); len(match) == 2 {
+				btccTxId = match[1]
+				break
+			}
+		}
+		if btccTxId == "" {
+			return fmt.Errorf("btc anchor %s has no calendar uri", anchor.AnchorID)
+		}
+		tx, err := app.getCalendarTx(btccTxId)
+		if err != nil {
+			return err
+		}
+		if tx.TxType != "BTC-C" {
+			return fmt.Errorf("anchor uri points to a %s tx, not BTC-C", tx.TxType)
+		}
+		root := tx.Data
+		btcc := types.BtcMonMsg{}
+		if err := json.Unmarshal([]byte(tx.Data), &btcc); err == nil {
+			root = btcc.BtcHeadRoot
+			if strconv.FormatInt(btcc.BtcHeadHeight, 10) != anchor.AnchorID {
+				return fmt.Errorf("btc anchor height %s does not match BTC-C height %d", anchor.AnchorID, btcc.BtcHeadHeight)
+			}
+		}
+		if !strings.EqualFold(root, expected) {
+			return fmt.Errorf("btc block %s merkle root is %s, not %s", anchor.AnchorID, root, expected)
+		}
+		return nil
+	case "eth", "teth":
+		var ethcTxId string
+		for _, uri := range anchor.Uris {
+			if match := calendarDataUriRegex.FindStringSubmatch(uri); len(match) == 2 {
+				ethcTxId = match[1]
+				break
+			}
+		}
+		if ethcTxId == "" {
+			return fmt.Errorf("eth anchor %s has no calendar uri", anchor.AnchorID)
+		}
+		tx, err := app.getCalendarTx(ethcTxId)
+		if err != nil {
+			return err
+		}
+		if tx.TxType != "ETH-C" {
+			return fmt.Errorf("anchor uri points to a %s tx, not ETH-C", tx.TxType)
+		}
+		ethc := types.BtcMonMsg{}
+		if err := json.Unmarshal([]byte(tx.Data), &ethc); err != nil {
+			return err
+		}
+		if strconv.FormatInt(ethc.BtcHeadHeight, 10) != anchor.AnchorID {
+			return fmt.Errorf("eth anchor block %s does not match ETH-C block %d", anchor.AnchorID, ethc.BtcHeadHeight)
+		}
+		if !strings.EqualFold(ethc.BtcHeadRoot, expected) {
+			return fmt.Errorf("eth anchor tx in block %s is %s, not %s", anchor.AnchorID, ethc.BtcHeadRoot, expected)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported anchor type %s", anchor.Type)
+}
+
+func (app *AnchorApplication) getCalendarTx(txid string) (types.Tx, error) {
+	result, err := app.rpc.GetTxByHash(txid)
+	if err != nil {
+		return types.Tx{}, err
+	}
+	return util.DecodeTx(result.Tx)
 }
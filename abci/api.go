@@ -1,16 +1,30 @@
 package abci
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/chainpoint/chainpoint-core/aggregator"
+	"github.com/chainpoint/chainpoint-core/anchor"
+	"github.com/chainpoint/chainpoint-core/keysend"
 	"github.com/chainpoint/chainpoint-core/leaderelection"
+	"github.com/chainpoint/chainpoint-core/ledger"
+	"github.com/chainpoint/chainpoint-core/pricing"
 	"github.com/chainpoint/chainpoint-core/proof"
 	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/chainpoint/chainpoint-core/util"
+	"github.com/chainpoint/chainpoint-core/webhook"
+	lightning "github.com/chainpoint/lightning-go"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -19,7 +33,15 @@ import (
 )
 
 type Hash struct {
-	Hash string `json:"hash`
+	Hash          string `json:"hash`
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	CallbackURL   string `json:"callback_url,omitempty"`
+}
+
+type Hashes struct {
+	Hashes        []string `json:"hashes"`
+	HashAlgorithm string   `json:"hash_algorithm,omitempty"`
+	CallbackURL   string   `json:"callback_url,omitempty"`
 }
 
 type HashResponse struct {
@@ -34,18 +56,29 @@ type ProcessingHints struct {
 	BtcHint string `json:"btc"`
 }
 
+type VerifyResponse struct {
+	ProofId  string                `json:"proof_id"`
+	Hash     string                `json:"hash"`
+	Verified bool                  `json:"verified"`
+	Branches []proof.BranchVerdict `json:"branches"`
+	Error    string                `json:"error,omitempty"`
+}
+
 func (app *AnchorApplication) LnPaymentHandler(quit chan struct{}) {
+	select {
+	case <-app.ready:
+	case <-quit:
+		return
+	}
 	for {
-		if !app.state.AppReady {
-			continue
-		}
-		hashRegex := regexp.MustCompile("^[a-fA-F0-9]{64}$")
 		errors := make(chan error)
 		results := make(chan lnrpc.Invoice)
 		subscribe := true
-		go app.LnClient.SubscribeInvoicesChannel(quit, errors, results)
+		go app.subscribeInvoices(quit, errors, results)
 		for subscribe {
 			select {
+			case <-quit:
+				return
 			case err := <-errors:
 				app.LogError(err)
 				subscribe = false
@@ -53,40 +86,131 @@ func (app *AnchorApplication) LnPaymentHandler(quit chan struct{}) {
 				break
 			case res := <-results:
 				app.logger.Info("Received Invoice", "Invoice", hex.EncodeToString(res.RHash), "Keysend", res.IsKeysend)
-				if res.IsKeysend && res.State == lnrpc.Invoice_SETTLED && (res.Value == int64(app.config.HashPrice) || res.ValueMsat == int64(app.config.HashPrice)*1000) {
-					var hash string
-					if len(res.Htlcs) >= 1 {
-						for _, htlc := range res.Htlcs {
-							for _, value := range htlc.CustomRecords {
-								if hashRegex.MatchString(hex.EncodeToString(value)) {
-									hash = hex.EncodeToString(value)
-								}
-							}
-						}
-					}
-					if hashRegex.MatchString(res.Memo) {
-						hash = res.Memo
-					}
-					if hash != "" {
-						id := sha256.Sum256([]byte(hash))
-						idStr := hex.EncodeToString(id[:])
-						app.aggregator.AddHashItem(types.HashItem{
-							ProofID: idStr,
-							Hash:    hash,
-						})
-						alternateId := hex.EncodeToString(res.RHash)
-						app.aggregator.AddHashItem(types.HashItem{
-							ProofID: alternateId,
-							Hash:    hash,
-						})
-						app.logger.Info("Accepted Hash from invoice", "ProofId", idStr, "AlternateProofId", alternateId, "hash", hash)
-					}
+				if res.State != lnrpc.Invoice_SETTLED {
+					continue
 				}
+				app.handleSettledInvoice(&res)
+				app.LogError(app.Cache.Set(SETTLE_INDEX_KEY, strconv.FormatUint(res.SettleIndex, 10)))
 			}
 		}
 	}
 }
 
+// SETTLED_INVOICE_KEY_PREFIX : marks an invoice as handled, by its payment hash
+const SETTLED_INVOICE_KEY_PREFIX = "Ln:Settled:"
+
+// handleSettledInvoice : records a settled invoice in the ledger and accepts the hashes a keysend pays for. The invoice is
+// marked as handled first, so an invoice replayed after a crash is never credited, queued or recorded twice
+func (app *AnchorApplication) handleSettledInvoice(res *lnrpc.Invoice) {
+	rHash := hex.EncodeToString(res.RHash)
+	handled, err := app.Cache.Get(SETTLED_INVOICE_KEY_PREFIX + rHash)
+	if app.LogError(err) != nil {
+		return
+	}
+	if handled != "" {
+		app.logger.Info("Invoice was already handled", "Invoice", rHash)
+		return
+	}
+	if app.LogError(app.Cache.Set(SETTLED_INVOICE_KEY_PREFIX+rHash, strconv.FormatUint(res.SettleIndex, 10))) != nil {
+		return
+	}
+	// LSAT hold invoices are settled when they are redeemed, so they're recorded here along with other invoices
+	detail := res.Memo
+	if res.IsKeysend {
+		detail = "keysend"
+	}
+	app.LogError(app.Ledger.Record(ledger.Entry{Time: time.Unix(res.SettleDate, 0).UTC(), Kind: ledger.LnReceipt, Amount: res.AmtPaidSat, TxID: rHash, Detail: detail}))
+	if res.IsKeysend {
+		app.acceptKeysend(res)
+	}
+}
+
+// SETTLE_INDEX_KEY : the settle index of the last settled invoice handled, from which the invoice subscription resumes
+const SETTLE_INDEX_KEY = "Ln:SettleIndex"
+
+// subscribeInvoices : sends invoice updates to results, starting with every invoice settled since the one at the persisted
+// settle index, so invoices settled while the subscription was down are still handled
+func (app *AnchorApplication) subscribeInvoices(quit chan struct{}, errc chan error, results chan lnrpc.Invoice) {
+	var settleIndex uint64
+	if indexStr, err := app.Cache.Get(SETTLE_INDEX_KEY); err == nil && indexStr != "" {
+		settleIndex, _ = strconv.ParseUint(indexStr, 10, 64)
+	}
+	lightning, closeFunc, err := app.LnClient.GetClient()
+	if err != nil {
+		errc <- err
+		return
+	}
+	defer closeFunc()
+	subscription, err := lightning.SubscribeInvoices(context.Background(), &lnrpc.InvoiceSubscription{SettleIndex: settleIndex})
+	if err != nil {
+		errc <- err
+		return
+	}
+	for {
+		invoice, err := subscription.Recv()
+		if err != nil {
+			errc <- err
+			return
+		}
+		select {
+		case <-quit:
+			return
+		case results <- *invoice:
+		}
+	}
+}
+
+// KEYSEND_PROOFS_KEY_PREFIX : keys the proof_ids of the hashes paid for by a keysend, by its payment hash
+const KEYSEND_PROOFS_KEY_PREFIX = "Keysend:ProofIds:"
+
+// acceptKeysend : charges a settled keysend the base price for the hashes it carries, since the gateway it names isn't
+// authenticated, and aggregates them if the payment alone covers them. Each hash's proof_id is the sha256 of the hash, and the payment hash is mapped
+// to all of them so gateways can look them up by payment
+func (app *AnchorApplication) acceptKeysend(res *lnrpc.Invoice) {
+	rHash := hex.EncodeToString(res.RHash)
+	hashes, err := keysend.InvoiceHashes(res)
+	if app.LogError(err) != nil {
+		hashes = []string{}
+	}
+	gateway := keysend.InvoiceGateway(res)
+	payment, err := app.Pricing.Pay(gateway, res.AmtPaidMsat, int64(len(hashes)), app.state.LatestBtcFee)
+	if app.LogError(err) != nil {
+		return
+	}
+	if payment.Unclaimed > 0 {
+		app.logger.Info("Keysend paid more than its hashes cost and named no gateway to credit", "Invoice", rHash, "UnclaimedMsat", payment.Unclaimed)
+	}
+	if gateway != "" {
+		app.logger.Info("Gateway credit updated", "Gateway", gateway, "CreditMsat", payment.Credit)
+	}
+	if len(hashes) == 0 {
+		return
+	}
+	if !payment.Accepted {
+		app.logger.Info("Keysend did not cover the hash price", "Invoice", rHash, "Hashes", len(hashes), "PaidMsat", res.AmtPaidMsat, "CostMsat", payment.Cost)
+		return
+	}
+	hashItems := make([]types.HashItem, 0, len(hashes))
+	proofIds := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		id := sha256.Sum256([]byte(hash))
+		idStr := hex.EncodeToString(id[:])
+		hashItems = append(hashItems, types.HashItem{ProofID: idStr, Hash: hash})
+		proofIds = append(proofIds, idStr)
+	}
+	// a payment for a single hash also serves its proof under the payment hash, as it always has
+	if len(hashes) == 1 {
+		hashItems = append(hashItems, types.HashItem{ProofID: rHash, Hash: hashes[0]})
+	}
+	if app.LogError(app.aggregator.AddHashItems(hashItems)) != nil {
+		// the keysend has been settled, so credit what it paid for the hashes back to its gateway
+		app.LogError(app.Pricing.Refund(gateway, payment.Cost, 0))
+		return
+	}
+	app.LogError(app.Cache.SetArray(KEYSEND_PROOFS_KEY_PREFIX+rHash, proofIds))
+	app.logger.Info("Accepted Hashes from invoice", "Invoice", rHash, "ProofIds", proofIds)
+}
+
 func (app *AnchorApplication) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusTeapot)
 	fmt.Fprintf(w, "This is an API endpoint. Please consult https://chainpoint.org")
@@ -105,6 +229,42 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Write([]byte(response))
 }
 
+// respondCBOR makes the response with payload as cbor format
+func respondCBOR(w http.ResponseWriter, status int, payload interface{}) {
+	response, err := cbor.Marshal(payload)
+	if util.LogError(err) != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", proof.CBORMediaType)
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// proofMediaType selects a proof encoding from the request's Accept header, defaulting to json
+func proofMediaType(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, proof.CBORMediaType) {
+		return proof.CBORMediaType
+	}
+	if strings.Contains(accept, proof.Base64MediaType) {
+		return proof.Base64MediaType
+	}
+	return "application/json"
+}
+
+// encodeProof converts a proof into the representation for the negotiated media type
+func encodeProof(p proof.P, mediaType string) (interface{}, error) {
+	switch mediaType {
+	case proof.CBORMediaType:
+		return p.ToBinary()
+	case proof.Base64MediaType:
+		return p.ToBase64()
+	}
+	return p, nil
+}
+
 func (app *AnchorApplication) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	ip := util.GetClientIP(r)
 	app.logger.Info(fmt.Sprintf("Status Client IP: %s", ip))
@@ -131,13 +291,15 @@ func (app *AnchorApplication) StatusHandler(w http.ResponseWriter, r *http.Reque
 		Uris:                info.Uris,
 		ActiveChannelsCount: int(info.NumActiveChannels),
 		Alias:               info.Alias,
-		HashPriceSatoshis:   app.config.HashPrice,
+		HashPriceSatoshis:   int(pricing.MsatToSat(app.Pricing.Price("", app.state.LatestBtcFee))),
 	}
 	apiStatus.LightningBalance.UnconfirmedBalance = strconv.FormatInt(balance.UnconfirmedBalance, 10)
 	apiStatus.LightningBalance.ConfirmedBalance = strconv.FormatInt(balance.ConfirmedBalance, 10)
 	apiStatus.LightningBalance.TotalBalance = strconv.FormatInt(balance.TotalBalance, 10)
 	apiStatus.TotalStakePrice = app.state.LnStakePrice
 	apiStatus.ValidatorStakePrice = app.state.LnStakePerVal
+	spendStatus := app.SpendPolicy.Status()
+	apiStatus.SpendPolicy = &spendStatus
 	apiStatus.Jwk = app.JWK
 	apiStatus.NodeInfo = status.NodeInfo
 	apiStatus.ValidatorInfo = status.ValidatorInfo
@@ -145,18 +307,189 @@ func (app *AnchorApplication) StatusHandler(w http.ResponseWriter, r *http.Reque
 	respondJSON(w, http.StatusOK, apiStatus)
 }
 
-func (app *AnchorApplication) HashHandler(w http.ResponseWriter, r *http.Request) {
-	ip := util.GetClientIP(r)
-	app.logger.Info(fmt.Sprintf("Client IP: %s", ip))
-	ipStrs := strings.Split(ip, ":")
-	if len(ipStrs) == 2 {
-		ip = ipStrs[0]
-	}
+// hashSubmissionAllowed : lets allowlisted gateways through, then draws the price of n hashes from the gateway's credit,
+// otherwise requires a paid LSAT. Returns the millisatoshis paid, which refundHashes credits back if the hashes can't be
+// queued after all. Responds to the client when not allowed
+func (app *AnchorApplication) hashSubmissionAllowed(w http.ResponseWriter, r *http.Request, ip string, n int64) (int64, bool) {
+	ip = gatewayIP(ip)
 	if app.config.UseAllowlist && util.ArrayContains(app.config.GatewayAllowlist, ip) {
 		app.logger.Info("IP allowed access without LSAT")
-	} else if app.LnClient.RespondLSAT(w, r) {
+		return 0, true
+	}
+	price := app.Pricing.Price(ip, app.state.LatestBtcFee) * n
+	charged, err := app.Pricing.Charge(ip, n, app.state.LatestBtcFee)
+	if app.LogError(err) == nil && charged {
+		app.logger.Info("Hashes paid from gateway credit", "Gateway", ip, "Hashes", n)
+		return price, true
+	}
+	// LSAT invoices are issued at the LightningClient's HashPrice, so price them on a copy rather than the shared client
+	lnClient := *app.LnClient
+	lnClient.HashPrice = pricing.MsatToSat(price)
+	if lnClient.RespondLSAT(w, r) {
+		return 0, false
+	}
+	if !app.redeemLSAT(w, r, lnClient.HashPrice) {
+		return 0, false
+	}
+	app.LogError(app.Pricing.AddVolume(ip, n))
+	return pricing.SatToMsat(float64(lnClient.HashPrice)), true
+}
+
+// refundHashes : credits the paidMsat that hashSubmissionAllowed took for n hashes back to the gateway at ip, once the
+// hashes turn out not to be queued
+func (app *AnchorApplication) refundHashes(ip string, paidMsat int64, n int64) {
+	if paidMsat == 0 {
+		return
+	}
+	ip = gatewayIP(ip)
+	app.logger.Info("Refunding hashes that could not be queued", "Gateway", ip, "Hashes", n)
+	app.LogError(app.Pricing.Refund(ip, paidMsat, n))
+}
+
+// redeemLSAT : RespondLSAT only checks that an LSAT's invoice is held, so this checks that the invoice covers priceSat and
+// settles it, so that the LSAT can't pay for another submission. Responds and returns false if the LSAT can't be redeemed
+func (app *AnchorApplication) redeemLSAT(w http.ResponseWriter, r *http.Request, priceSat int64) bool {
+	lsat, err := lightning.FromHeader(&r.Header)
+	if err != nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "Invalid LSAT provided in Authorization header"})
+		return false
+	}
+	invoice, err := app.LnClient.LookupInvoice(lsat.PayHash)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]interface{}{"error": fmt.Sprintf("No matching invoice found for payhash %x", lsat.PayHash)})
+		return false
+	}
+	// the LSAT's token id is the preimage of its hold invoice
+	preimage := lsat.ID[:]
+	if errMessage := lsatInvoiceError(&invoice, preimage, priceSat); errMessage != "" {
+		respondJSON(w, http.StatusPaymentRequired, map[string]interface{}{"error": errMessage})
+		return false
+	}
+	invoices, closeFunc, err := app.LnClient.GetInvoiceClient()
+	if app.LogError(err) != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not redeem LSAT"})
+		return false
+	}
+	defer closeFunc()
+	// only one of any concurrent submissions with the same LSAT can settle its invoice
+	if _, err := invoices.SettleInvoice(context.Background(), &invoicesrpc.SettleInvoiceMsg{Preimage: preimage}); err != nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "Unauthorized: LSAT has already been redeemed. Try again with a different LSAT"})
+		return false
+	}
+	return true
+}
+
+// lsatInvoiceError : returns a client-facing error message if invoice, held for an LSAT with preimage, doesn't pay priceSat,
+// or an empty string
+func lsatInvoiceError(invoice *lnrpc.Invoice, preimage []byte, priceSat int64) string {
+	hash := sha256.Sum256(preimage)
+	if !bytes.Equal(hash[:], invoice.RHash) {
+		return "Invalid LSAT: its token doesn't match its invoice"
+	}
+	if invoice.State != lnrpc.Invoice_ACCEPTED {
+		return "Payment Required"
+	}
+	if invoice.Value < priceSat {
+		return fmt.Sprintf("LSAT invoice of %d sats doesn't cover this submission's price of %d sats. Try again with a different LSAT", invoice.Value, priceSat)
+	}
+	return ""
+}
+
+// unregisterWebhooks : drops the callback registrations newHashItem made for hashes that weren't queued after all
+func (app *AnchorApplication) unregisterWebhooks(callbackURL string, items []types.HashItem) {
+	if callbackURL == "" {
+		return
+	}
+	for _, item := range items {
+		app.LogError(app.Webhooks.Unregister(item.ProofID))
+	}
+}
+
+// queueRetrySeconds : how long clients are asked to wait before resubmitting hashes the aggregation queue had no room for
+const queueRetrySeconds = 30
+
+// respondQueueError : tells the client its hashes weren't queued, asking it to retry later if the queue was just full
+func respondQueueError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, aggregator.ErrQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(queueRetrySeconds))
+		respondJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "aggregation queue is full, try again later"})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": message})
+}
+
+// gatewayIP : strips the port, if any, from a client's IPv4 or IPv6 address
+func gatewayIP(ip string) string {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		return host
+	}
+	return ip
+}
+
+// CreditHandler : reports the requesting gateway's prepaid credit and current price per hash, in millisatoshis
+func (app *AnchorApplication) CreditHandler(w http.ResponseWriter, r *http.Request) {
+	ip := gatewayIP(util.GetClientIP(r))
+	credit, err := app.Pricing.Credit(ip)
+	if app.LogError(err) != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not query for credit"})
 		return
 	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"gateway":            ip,
+		"credit_msat":        credit,
+		"hash_price_msat":    app.Pricing.Price(ip, app.state.LatestBtcFee),
+		"credit_record_type": pricing.CreditRecordType,
+	})
+}
+
+var hashRegex = regexp.MustCompile("^([a-fA-F0-9]{2}){20,64}$")
+
+// validateHash : returns a client-facing error message for an unacceptable hash submission, or an empty string.
+// Hashes with a declared algorithm must be exactly that algorithm's digest length
+func validateHash(hash string, algorithm string, callbackURL string) string {
+	if !hashRegex.MatchString(hash) {
+		return "invalid JSON body: bad hash submitted"
+	}
+	if algorithm != "" {
+		if err := proof.ValidateHashForAlgorithm(hash, algorithm); err != nil {
+			return "invalid JSON body: bad hash submitted: " + err.Error()
+		}
+	}
+	if callbackURL != "" {
+		if err := webhook.ValidateURL(callbackURL); err != nil {
+			return "invalid JSON body: bad callback_url: " + err.Error()
+		}
+	}
+	return ""
+}
+
+// newHashItem : assigns a proof id to a hash and registers its callback url
+func (app *AnchorApplication) newHashItem(hash string, algorithm string, callbackURL string) (types.HashItem, HashResponse, error) {
+	proofId, err := app.ULIDGenerator.NewUlid()
+	if err != nil {
+		return types.HashItem{}, HashResponse{}, err
+	}
+	proofIdStr := proofId.String()
+	if callbackURL != "" {
+		if err := app.Webhooks.Register(proofIdStr, callbackURL); err != nil {
+			return types.HashItem{}, HashResponse{}, err
+		}
+	}
+	hashResponse := HashResponse{
+		Hash:         hash,
+		ProofId:      proofIdStr,
+		HashReceived: time.Now().Format(time.RFC3339),
+		ProcessingHints: ProcessingHints{
+			CalHint: time.Now().Add(140 * time.Second).Format(time.RFC3339),
+			BtcHint: time.Now().Add(90 * time.Minute).Format(time.RFC3339),
+		},
+	}
+	return types.HashItem{Hash: hash, ProofID: proofIdStr, HashAlgorithm: algorithm}, hashResponse, nil
+}
+
+func (app *AnchorApplication) HashHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Client IP: %s", ip))
 	contentType := r.Header.Get("Content-type")
 	if contentType != "application/json" {
 		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid content type"})
@@ -170,33 +503,141 @@ func (app *AnchorApplication) HashHandler(w http.ResponseWriter, r *http.Request
 		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: missing hash"})
 		return
 	}
-	match, err := regexp.MatchString("^([a-fA-F0-9]{2}){20,64}$", hash.Hash)
-	if app.LogError(err) != nil || !match {
-		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: bad hash submitted"})
+	if errStr := validateHash(hash.Hash, hash.HashAlgorithm, hash.CallbackURL); errStr != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errStr})
+		return
+	}
+	paid, allowed := app.hashSubmissionAllowed(w, r, ip, 1)
+	if !allowed {
 		return
 	}
+	hashItem, hashResponse, err := app.newHashItem(hash.Hash, hash.HashAlgorithm, hash.CallbackURL)
+	if app.LogError(err) != nil {
+		app.refundHashes(ip, paid, 1)
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not assign proof_id"})
+		return
+	}
+	// Append hash item to aggregator
+	if err := app.aggregator.AddHashItem(hashItem); app.LogError(err) != nil {
+		app.refundHashes(ip, paid, 1)
+		app.unregisterWebhooks(hash.CallbackURL, []types.HashItem{hashItem})
+		respondQueueError(w, err, "could not queue hash")
+		return
+	}
+	go app.Analytics.SendEvent(app.state.LatestTimeRecord, "HashReceived", hashResponse.ProofId, hashResponse.HashReceived, ip, "", ip)
+	respondJSON(w, http.StatusOK, hashResponse)
+}
 
-	proofId, err := app.ULIDGenerator.NewUlid()
+// HashesHandler : accepts up to MaxHashesPerBatch hashes in one request. Every hash is validated before any is
+// enqueued, and the batch is handed to the aggregator as a single item so it is anchored under one aggregation root.
+func (app *AnchorApplication) HashesHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Batch Client IP: %s", ip))
+	contentType := r.Header.Get("Content-type")
+	if contentType != "application/json" {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid content type"})
+		return
+	}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	hashes := Hashes{}
+	err := d.Decode(&hashes)
+	if app.LogError(err) != nil || len(hashes.Hashes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: missing hashes"})
+		return
+	}
+	if len(hashes.Hashes) > app.config.MaxHashesPerBatch {
+		errStr := fmt.Sprintf("invalid request, too many hashes (%d max)", app.config.MaxHashesPerBatch)
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errStr})
+		return
+	}
+	for _, hash := range hashes.Hashes {
+		if errStr := validateHash(hash, hashes.HashAlgorithm, hashes.CallbackURL); errStr != "" {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errStr + ": " + hash})
+			return
+		}
+	}
+	paid, allowed := app.hashSubmissionAllowed(w, r, ip, int64(len(hashes.Hashes)))
+	if !allowed {
+		return
+	}
+	hashItems := make([]types.HashItem, 0, len(hashes.Hashes))
+	hashResponses := make([]HashResponse, 0, len(hashes.Hashes))
+	for _, hash := range hashes.Hashes {
+		hashItem, hashResponse, err := app.newHashItem(hash, hashes.HashAlgorithm, hashes.CallbackURL)
+		if app.LogError(err) != nil {
+			app.refundHashes(ip, paid, int64(len(hashes.Hashes)))
+			app.unregisterWebhooks(hashes.CallbackURL, hashItems)
+			respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not assign proof_id"})
+			return
+		}
+		hashItems = append(hashItems, hashItem)
+		hashResponses = append(hashResponses, hashResponse)
+	}
+	if err := app.aggregator.AddHashItems(hashItems); app.LogError(err) != nil {
+		app.refundHashes(ip, paid, int64(len(hashItems)))
+		app.unregisterWebhooks(hashes.CallbackURL, hashItems)
+		respondQueueError(w, err, "could not queue hashes")
+		return
+	}
+	go app.Analytics.SendEvent(app.state.LatestTimeRecord, "HashesReceived", hashResponses[0].ProofId, hashResponses[0].HashReceived, ip, "", ip)
+	respondJSON(w, http.StatusOK, hashResponses)
+}
+
+// maxDocumentBytes : largest document HashDocumentHandler will hash
+const maxDocumentBytes = 10 << 20
+
+// HashDocumentHandler : hashes a raw uploaded document for clients that cannot hash locally, then submits it like HashHandler.
+// The algorithm (default sha-256) and an optional callback url are given as hash_algorithm and callback_url query parameters.
+func (app *AnchorApplication) HashDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Document Client IP: %s", ip))
+	query := r.URL.Query()
+	algorithm := query.Get("hash_algorithm")
+	if algorithm == "" {
+		algorithm = proof.SHA256
+	}
+	if !proof.IsHashAlgorithm(algorithm) {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid request, unsupported hash_algorithm: " + algorithm})
+		return
+	}
+	callbackURL := query.Get("callback_url")
+	if callbackURL != "" {
+		if err := webhook.ValidateURL(callbackURL); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid request, bad callback_url: " + err.Error()})
+			return
+		}
+	}
+	// the document is only read once it's paid for
+	paid, allowed := app.hashSubmissionAllowed(w, r, ip, 1)
+	if !allowed {
+		return
+	}
+	hash, err := proof.HashDocument(http.MaxBytesReader(w, r.Body, maxDocumentBytes), algorithm)
 	if app.LogError(err) != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "cannot compute ulid"})
+		errStr := fmt.Sprintf("invalid request, could not read document (%d bytes max)", maxDocumentBytes)
+		app.refundHashes(ip, paid, 1)
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errStr})
 		return
 	}
-	proofIdStr := proofId.String()
-	hashResponse := HashResponse{
-		Hash:         hash.Hash,
-		ProofId:      proofIdStr,
-		HashReceived: time.Now().Format(time.RFC3339),
-		ProcessingHints: ProcessingHints{
-			CalHint: time.Now().Add(140 * time.Second).Format(time.RFC3339),
-			BtcHint: time.Now().Add(90 * time.Minute).Format(time.RFC3339),
-		},
+	hashItem, hashResponse, err := app.newHashItem(hash, algorithm, callbackURL)
+	if app.LogError(err) != nil {
+		app.refundHashes(ip, paid, 1)
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not assign proof_id"})
+		return
+	}
+	if err := app.aggregator.AddHashItem(hashItem); app.LogError(err) != nil {
+		app.refundHashes(ip, paid, 1)
+		app.unregisterWebhooks(callbackURL, []types.HashItem{hashItem})
+		respondQueueError(w, err, "could not queue hash")
+		return
 	}
 	go app.Analytics.SendEvent(app.state.LatestTimeRecord, "HashReceived", hashResponse.ProofId, hashResponse.HashReceived, ip, "", ip)
-	// Append hash item to aggregator
-	app.aggregator.AddHashItem(types.HashItem{Hash: hash.Hash, ProofID: proofIdStr})
 	respondJSON(w, http.StatusOK, hashResponse)
 }
 
+var proofIdRegex = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[1][0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12})|([0123456789ABCDEFGHJKMNPQRSTVWXYZ]{26})|([a-f0-9]{64})$`)
+
 func (app *AnchorApplication) ProofHandler(w http.ResponseWriter, r *http.Request) {
 	ip := util.GetClientIP(r)
 	app.logger.Info(fmt.Sprintf("Proof Client IP: %s", ip))
@@ -210,9 +651,8 @@ func (app *AnchorApplication) ProofHandler(w http.ResponseWriter, r *http.Reques
 		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid request, too many hash ids (250 max)"})
 		return
 	}
-	uuidOrUlidRegex := regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[1][0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12})|([0123456789ABCDEFGHJKMNPQRSTVWXYZ]{26})|([a-f0-9]{64})$`)
 	for _, id := range proofids {
-		if !uuidOrUlidRegex.MatchString(id) {
+		if !proofIdRegex.MatchString(id) {
 			errStr := fmt.Sprintf("invalid request, bad proof_id: %s", id)
 			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errStr})
 			return
@@ -223,6 +663,10 @@ func (app *AnchorApplication) ProofHandler(w http.ResponseWriter, r *http.Reques
 		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "could not retrieve proofs"})
 		return
 	}
+	// add the branches of engines other than btc, serving the proofs without them if they can't be read
+	proofStates, err = anchor.MergeProofs(app.ChainpointDb, proofStates)
+	app.LogError(err)
+	mediaType := proofMediaType(r)
 	response := make([]proof.P, 0)
 	for _, id := range proofids {
 		if val, exists := proofStates[id]; exists {
@@ -231,31 +675,229 @@ func (app *AnchorApplication) ProofHandler(w http.ResponseWriter, r *http.Reques
 				response = append(response, map[string]interface{}{"proof_id": id, "proof": nil})
 			}
 			go app.Analytics.SendEvent(app.state.LatestTimeRecord, "GetProof", id, time.Now().Format(time.RFC3339), ip, "", ip)
-			response = append(response, map[string]interface{}{"proof_id": id, "proof": rawJSON})
+			encoded, err := encodeProof(rawJSON, mediaType)
+			if app.LogError(err) != nil {
+				encoded = nil
+			}
+			response = append(response, map[string]interface{}{"proof_id": id, "proof": encoded})
 		} else {
 			response = append(response, map[string]interface{}{"proof_id": id, "proof": nil})
 		}
 	}
+	if mediaType == proof.CBORMediaType {
+		respondCBOR(w, http.StatusOK, response)
+		return
+	}
 	respondJSON(w, http.StatusOK, response)
 }
 
+var paymentHashRegex = regexp.MustCompile("^[a-f0-9]{64}$")
+
+// PaymentProofsHandler : lists the proof_ids of the hashes paid for by the keysend with payment hash {rhash}
+func (app *AnchorApplication) PaymentProofsHandler(w http.ResponseWriter, r *http.Request) {
+	rHash := strings.ToLower(mux.Vars(r)["rhash"])
+	if !paymentHashRegex.MatchString(rHash) {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid request, bad payment hash"})
+		return
+	}
+	proofIds, err := app.Cache.GetArray(KEYSEND_PROOFS_KEY_PREFIX + rHash)
+	if app.LogError(err) != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not retrieve proof_ids"})
+		return
+	}
+	if len(proofIds) == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]interface{}{"error": "no hashes were accepted for payment hash " + rHash})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"payment_hash": rHash, "proof_ids": proofIds})
+}
+
 func (app *AnchorApplication) ProofUpgradeHandler(w http.ResponseWriter, r *http.Request) {
 	ip := util.GetClientIP(r)
 	app.logger.Info(fmt.Sprintf("Proof Upgrade Client IP: %s", ip))
 	vars := mux.Vars(r)
 	if _, exists := vars["txid"]; exists {
 		app.logger.Info("Upgrading proof", "cal", vars["txid"])
-		proof, err := app.Anchor.ConstructProof(vars["txid"])
+		coreProof, err := app.constructProof(vars["txid"])
 		if app.LogError(err) != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "could not reconstruct core proof"})
 			return
 		}
-		respondJSON(w, http.StatusOK, proof)
+		switch proofMediaType(r) {
+		case proof.CBORMediaType:
+			binary, err := coreProof.ToBinary()
+			if app.LogError(err) != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not encode proof"})
+				return
+			}
+			w.Header().Set("Content-Type", proof.CBORMediaType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(binary)
+		case proof.Base64MediaType:
+			encoded, err := coreProof.ToBase64()
+			if app.LogError(err) != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "could not encode proof"})
+				return
+			}
+			w.Header().Set("Content-Type", proof.Base64MediaType)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(encoded))
+		default:
+			respondJSON(w, http.StatusOK, coreProof)
+		}
 		return
 	}
 	respondJSON(w, http.StatusNotFound, map[string]interface{}{"error": "txid parameter required"})
 }
 
+// constructProof : rebuilds the branch of every anchor engine for a CAL tx from the Calendar, failing only if none can be built
+func (app *AnchorApplication) constructProof(txid string) (proof.P, error) {
+	coreProof := proof.Proof()
+	branches := []proof.P{}
+	err := errors.New("no anchor engines registered")
+	for _, engine := range app.Anchors.Engines() {
+		engineProof, constructErr := engine.ConstructProof(txid)
+		if constructErr != nil {
+			err = constructErr
+			continue
+		}
+		branches = append(branches, engineProof.Branches()...)
+	}
+	if len(branches) == 0 {
+		return coreProof, err
+	}
+	coreProof["branches"] = branches
+	return coreProof, nil
+}
+
+func (app *AnchorApplication) ProofVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Proof Verify Client IP: %s", ip))
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); app.LogError(err) != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body"})
+		return
+	}
+	proofs := make([]proof.P, 0)
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(body, &proofs); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: expected a proof or array of proofs"})
+			return
+		}
+	} else {
+		single := proof.Proof()
+		if err := json.Unmarshal(body, &single); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: expected a proof or array of proofs"})
+			return
+		}
+		proofs = append(proofs, single)
+	}
+	if len(proofs) > 250 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid request, too many proofs (250 max)"})
+		return
+	}
+	response := make([]VerifyResponse, 0)
+	for _, p := range proofs {
+		result := VerifyResponse{Branches: []proof.BranchVerdict{}}
+		if id, ok := p["proof_id"].(string); ok {
+			result.ProofId = id
+		}
+		if hash, ok := p["hash"].(string); ok {
+			result.Hash = hash
+		}
+		verdicts, err := proof.Verify(p, app)
+		if err != nil {
+			result.Error = err.Error()
+			response = append(response, result)
+			continue
+		}
+		result.Branches = verdicts
+		result.Verified = len(verdicts) > 0
+		for _, verdict := range verdicts {
+			result.Verified = result.Verified && verdict.Verified
+		}
+		response = append(response, result)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+var streamUpgrader = websocket.Upgrader{
+	// the stream is read-only and public, like the rest of the api
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+const streamPingInterval = 30 * time.Second
+const streamWriteTimeout = 10 * time.Second
+
+// ProofStreamHandler : upgrades to a websocket and pushes proof state transitions for the proof ids given
+// in the proofids query parameter or header. Without proof ids every transition is streamed.
+func (app *AnchorApplication) ProofStreamHandler(w http.ResponseWriter, r *http.Request) {
+	ip := util.GetClientIP(r)
+	app.logger.Info(fmt.Sprintf("Proof Stream Client IP: %s", ip))
+	proofidParam := r.URL.Query().Get("proofids")
+	if len(proofidParam) == 0 {
+		proofidParam = r.Header.Get("proofids")
+	}
+	proofids := []string{}
+	if len(proofidParam) > 0 {
+		proofids = strings.Split(strings.ReplaceAll(proofidParam, " ", ""), ",")
+	}
+	if len(proofids) > 250 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid request, too many hash ids (250 max)"})
+		return
+	}
+	for _, id := range proofids {
+		if !proofIdRegex.MatchString(id) {
+			errStr := fmt.Sprintf("invalid request, bad proof_id: %s", id)
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{"error": errStr})
+			return
+		}
+	}
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if app.LogError(err) != nil {
+		// Upgrade has already responded to the client
+		return
+	}
+	defer conn.Close()
+	sub := app.Events.Subscribe(proofids)
+	defer app.Events.Unsubscribe(sub)
+
+	// clients don't send anything, but reading is required to process pongs and notice the connection closing.
+	// The read deadline replaces the api server's ReadTimeout, which would otherwise end every stream
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	})
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case event := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
 func (app *AnchorApplication) CalHandler(w http.ResponseWriter, r *http.Request) {
 	ip := util.GetClientIP(r)
 	app.logger.Info(fmt.Sprintf("Cal Client IP: %s", ip))
@@ -0,0 +1,140 @@
+package abci
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/aggregator"
+	"github.com/chainpoint/chainpoint-core/analytics"
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/ledger"
+	"github.com/chainpoint/chainpoint-core/pricing"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestLSATInvoiceError(t *testing.T) {
+	preimage := sha256.Sum256([]byte("preimage"))
+	hash := sha256.Sum256(preimage[:])
+	invoice := &lnrpc.Invoice{RHash: hash[:], Value: 10, State: lnrpc.Invoice_ACCEPTED}
+	assert.Equal(t, "", lsatInvoiceError(invoice, preimage[:], 10))
+	assert.Contains(t, lsatInvoiceError(invoice, preimage[:], 11), "doesn't cover", "an LSAT for one hash can't pay for a batch")
+	assert.Contains(t, lsatInvoiceError(invoice, hash[:], 10), "doesn't match")
+	invoice.State = lnrpc.Invoice_SETTLED
+	assert.NotEqual(t, "", lsatInvoiceError(invoice, preimage[:], 10), "a redeemed LSAT can't be replayed")
+}
+
+func TestGatewayIP(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", gatewayIP("10.0.0.1:3000"))
+	assert.Equal(t, "10.0.0.1", gatewayIP("10.0.0.1"))
+	assert.Equal(t, "2001:db8::1", gatewayIP("[2001:db8::1]:3000"))
+	assert.Equal(t, "2001:db8::1", gatewayIP("2001:db8::1"))
+}
+
+// testHashesApp : an app whose allowlisted gateway 10.0.0.1 submits for free, and whose aggregator queue holds queueSize
+// entries since no workers are running
+func testHashesApp(t *testing.T, queueSize string) (*AnchorApplication, *aggregator.WAL) {
+	t.Setenv("AGGREGATION_QUEUE_SIZE", queueSize)
+	db := dbm.NewDB("api", dbm.MemDBBackend, "")
+	cache := level.NewKVStore(&db, log.NewNopLogger())
+	wal := aggregator.NewWAL(dbm.NewMemDB())
+	ulidGen := ulidthreadsafe.NewThreadSafeUlid()
+	return &AnchorApplication{
+		state:         &types.AnchorState{},
+		config:        types.AnchorConfig{MaxHashesPerBatch: 3, UseAllowlist: true, GatewayAllowlist: []string{"10.0.0.1"}},
+		logger:        log.NewNopLogger(),
+		aggregator:    aggregator.NewAggregator(log.NewNopLogger(), ulidGen, wal),
+		Pricing:       pricing.NewEngine(types.HashPricingConfig{BasePriceMsat: 1000}, cache),
+		Analytics:     &analytics.UniversalAnalytics{},
+		ULIDGenerator: ulidGen,
+	}, wal
+}
+
+func postHashes(app *AnchorApplication, gateway string, hashes ...string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(Hashes{Hashes: hashes})
+	r := httptest.NewRequest(http.MethodPost, "/hashes", bytes.NewReader(body))
+	r.Header.Set("Content-type", "application/json")
+	r.RemoteAddr = gateway + ":5000"
+	w := httptest.NewRecorder()
+	app.HashesHandler(w, r)
+	return w
+}
+
+func TestHashesHandler(t *testing.T) {
+	app, wal := testHashesApp(t, "10")
+	hashes := []string{strings.Repeat("aa", 32), strings.Repeat("bb", 32), strings.Repeat("cc", 20)}
+
+	w := postHashes(app, "10.0.0.1", append(hashes, strings.Repeat("dd", 32))...)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "too many hashes (3 max)")
+
+	// one bad hash fails the whole batch, before any of it is queued
+	w = postHashes(app, "10.0.0.1", hashes[0], "not a hash", hashes[2])
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "not a hash")
+	entries, err := wal.Entries()
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	w = postHashes(app, "10.0.0.1", hashes...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var responses []HashResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &responses))
+	assert.Len(t, responses, 3)
+	proofIds := map[string]bool{}
+	for i, response := range responses {
+		assert.Equal(t, hashes[i], response.Hash, "responses are in the order the hashes were submitted")
+		proofIds[response.ProofId] = true
+	}
+	assert.Len(t, proofIds, 3, "each hash gets its own proof_id")
+	entries, err = wal.Entries()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1, "the batch is queued as a single entry")
+	assert.Len(t, entries[0], 3)
+}
+
+func TestHashesHandlerQueueFull(t *testing.T) {
+	app, _ := testHashesApp(t, "1")
+	hashes := []string{strings.Repeat("aa", 32), strings.Repeat("bb", 32)}
+	assert.Equal(t, http.StatusOK, postHashes(app, "10.0.0.1", hashes[0]).Code)
+
+	// a gateway paying from credit gets its credit back when the queue has no room
+	_, err := app.Pricing.Pay("10.0.0.2", 2000, 0, 0)
+	assert.Nil(t, err)
+	w := postHashes(app, "10.0.0.2", hashes...)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	credit, err := app.Pricing.Credit("10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2000), credit)
+}
+
+func TestSettledInvoiceIsHandledOnce(t *testing.T) {
+	app, _ := testHashesApp(t, "1")
+	db := dbm.NewDB("invoices", dbm.MemDBBackend, "")
+	app.Cache = level.NewKVStore(&db, log.NewNopLogger())
+	app.Ledger = ledger.NewLedger(dbm.NewMemDB())
+	hash := sha256.Sum256([]byte("preimage"))
+	invoice := &lnrpc.Invoice{RHash: hash[:], State: lnrpc.Invoice_SETTLED, IsKeysend: true, SettleIndex: 7, SettleDate: time.Now().Unix(),
+		AmtPaidSat: 3, AmtPaidMsat: 3000, Htlcs: []*lnrpc.InvoiceHTLC{{CustomRecords: map[uint64][]byte{pricing.CreditRecordType: []byte("10.0.0.2")}}}}
+
+	// an invoice replayed after a crash, before its settle index was persisted, isn't credited or recorded again
+	app.handleSettledInvoice(invoice)
+	app.handleSettledInvoice(invoice)
+	credit, err := app.Pricing.Credit("10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000), credit)
+	entries, err := app.Ledger.Entries(time.Unix(0, 0), time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -2,10 +2,14 @@ package abci
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/chainpoint/chainpoint-core/beacon"
 	fee2 "github.com/chainpoint/chainpoint-core/fee"
+	"github.com/chainpoint/chainpoint-core/leaderelection"
+	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/chainpoint/chainpoint-core/util"
+	"strconv"
 	"time"
 )
 
@@ -39,46 +43,112 @@ func (app *AnchorApplication) SyncMonitor() {
 		} else {
 			app.state.ChainSynced = true
 		}
-		if app.state.ChainSynced && app.state.Height > 2 && app.ID != "" {
+		if app.state.ChainSynced && app.state.Height > 2 && app.ID != "" && !app.state.AppReady {
 			app.state.AppReady = true
+			close(app.ready)
 		}
 	}
 }
 
-// BeaconMonitor : elects a leader to poll DRAND. Called every minute by ABCI.commit
+// BeaconMonitor : elects a leader to poll the configured DRAND beacons and broadcast a verified round as a DRAND tx, which every
+// Core adopts in DeliverTx. Falls back to the latest Calendar block hash when no DRAND tx has landed recently. Called every minute by ABCI.commit
 func (app *AnchorApplication) BeaconMonitor() {
 	time.Sleep(30 * time.Second) //sleep after commit for a few seconds
 	if app.state.AppReady {
-		//round, randomness, err := beacon.GetPublicRandomness()
-		round, randomness, err := beacon.GetCloudflareRandomness()
-		chainpointFormat := fmt.Sprintf("%d:%s", round, randomness)
-		if app.LogError(err) != nil {
-			chainpointFormat = app.state.LatestTimeRecord // use the last "good" entropy beacon value known to this Core
-		} else {
-			app.state.LatestTimeRecord = chainpointFormat
-			app.aggregator.LatestTime = app.state.LatestTimeRecord
+		amLeader, _ := leaderelection.ElectValidatorAsLeader(1, []string{}, *app.state, app.config)
+		if amLeader {
+			beacons, err := app.state.Drand().Beacons(app.config.BeaconURLs)
+			if app.LogError(err) != nil {
+				return
+			}
+			round, from, err := beacon.LatestRound(beacons)
+			if app.LogError(err) == nil && int64(round.Round) > app.state.LatestDrandRound {
+				roundBytes, err := json.Marshal(round)
+				if app.LogError(err) == nil {
+					_, err = app.rpc.BroadcastTxWithMeta("DRAND", string(roundBytes), 2, time.Now().Unix(), app.ID, from.String(), app.config.ECPrivateKey)
+					app.LogError(err)
+				}
+			}
 		}
-		if app.LogError(err) != nil {
-			app.logger.Debug(fmt.Sprintf("Failed to obtain DRAND beacon value of %s", chainpointFormat))
+		if app.state.Height-app.state.LatestDrandHeight <= DRAND_STALE_BLOCKS {
+			return
+		}
+		if app.config.EntropyFallback && app.state.LatestBlockHash != "" {
+			app.logger.Info("No recent DRAND tx, using Calendar block hash as entropy", "height", app.state.LatestBlockHeight)
+			app.setEntropy(types.Entropy{Source: types.EntropySourceTendermint, Beacon: app.config.ChainId, Round: app.state.LatestBlockHeight, Value: app.state.LatestBlockHash})
+			return
 		}
-		app.ULIDGenerator.NewSeededEntropy(randomness)
+		// use the last "good" entropy known to this Core
+		app.logger.Debug(fmt.Sprintf("No recent DRAND tx, keeping %s entropy", app.aggregator.Entropy().Source))
 	}
 }
 
-// FeeMonitor : elects a leader to poll and gossip Fee. Called every n minutes by ABCI.commit
+// setEntropy : records the entropy for new aggregations and reseeds proof id generation with it
+func (app *AnchorApplication) setEntropy(entropy types.Entropy) {
+	app.state.LatestEntropy = entropy
+	app.aggregator.SetEntropy(entropy)
+	app.ULIDGenerator.NewSeededEntropy(entropy.Value)
+}
+
+// FeeMonitor : elects a leader to poll the configured fee sources and broadcast their agreed fee as a FEE tx, which every
+// Core adopts in DeliverTx. Anchors at this Core's own estimate when no FEE tx has landed recently, while hashes stay priced at
+// the last FEE tx's rate. Called every block by ABCI.EndBlock
 func (app *AnchorApplication) FeeMonitor() {
 	time.Sleep(15 * time.Second) //sleep after commit for a few seconds
-	if app.state.AppReady && app.state.Height-app.state.LastBtcFeeHeight >= app.config.FeeInterval {
-		var fee int64
-		lndFee, _ := app.LnClient.GetLndFeeEstimate()
-		app.logger.Info(fmt.Sprintf("FEE from LND: %d", lndFee))
-		thirdPartyFee, _ := fee2.GetThirdPartyFeeEstimate()
-		app.logger.Info(fmt.Sprintf("FEE from Third Party: %d", thirdPartyFee))
-		fee = util.MaxInt64(lndFee, thirdPartyFee)
-		fee = util.MaxInt64(fee, STATIC_FEE_AMT)
-		app.logger.Info(fmt.Sprintf("Ln Wallet EstimateFEE: %v", fee))
-		app.state.LatestBtcFee = fee
-		app.state.LastBtcFeeHeight = app.state.Height
-		app.LnClient.LastFee = app.state.LatestBtcFee
+	if !app.state.AppReady || app.state.Height-app.state.LastBtcFeeHeight < app.config.FeeInterval {
+		return
+	}
+	amLeader, _ := leaderelection.ElectValidatorAsLeader(1, []string{}, *app.state, app.config)
+	stale := app.state.Height-app.state.LastBtcFeeHeight >= FEE_STALE_INTERVALS*app.config.FeeInterval &&
+		app.state.Height-app.localFeeHeight >= app.config.FeeInterval
+	if !amLeader && !stale {
+		return
+	}
+	fee, err := app.estimateFee()
+	if app.LogError(err) != nil {
+		return
+	}
+	app.logger.Info(fmt.Sprintf("Wallet EstimateFEE: %v", fee))
+	if amLeader {
+		_, err := app.rpc.BroadcastTx("FEE", strconv.FormatInt(fee, 10), 2, time.Now().Unix(), app.ID, app.config.ECPrivateKey)
+		app.LogError(err)
+	}
+	if stale {
+		app.logger.Info("No recent FEE tx, anchoring at local fee estimate", "fee", fee)
+		app.SpendPolicy.SetLocalRate(fee)
+		app.localFeeHeight = app.state.Height
+		app.LnClient.LastFee = fee
+	}
+}
+
+// estimateFee : the median of the configured fee sources' estimates, less outliers and capped at the fee ceiling
+func (app *AnchorApplication) estimateFee() (int64, error) {
+	estimates := []int64{}
+	for _, estimator := range app.FeeEstimators {
+		estimate, err := estimator.EstimateFee(app.config.FeeConfTarget)
+		if err != nil || estimate <= 0 {
+			app.logger.Info(fmt.Sprintf("FEE from %s unavailable: %v", estimator, err))
+			continue
+		}
+		app.logger.Info(fmt.Sprintf("FEE from %s: %d", estimator, estimate))
+		estimates = append(estimates, estimate)
+	}
+	fee, err := fee2.Combine(estimates, app.config.FeeCeiling)
+	if err != nil {
+		return 0, err
+	}
+	return util.MaxInt64(fee, STATIC_FEE_AMT), nil
+}
+
+// PruneState : removes proof state past its retention period and reports what was removed
+func (app *AnchorApplication) PruneState() {
+	report, err := app.ChainpointDb.PruneOldState()
+	if app.LogError(err) != nil || report.Total() == 0 {
+		return
+	}
+	keyvals := []interface{}{"total", report.Total(), "duration", report.Duration.String()}
+	for stateType, count := range report.Pruned {
+		keyvals = append(keyvals, stateType, count)
 	}
+	app.logger.Info("db pruned", keyvals...)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/chainpoint/chainpoint-core/leaderelection"
+	"github.com/chainpoint/chainpoint-core/ledger"
 	"github.com/chainpoint/chainpoint-core/txratelimiter"
 	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/chainpoint/chainpoint-core/util"
@@ -124,7 +125,9 @@ func (app *AnchorApplication) StakeIdentity() {
 						if !chanExists {
 							app.logger.Info(fmt.Sprintf("Adding Lightning Channel of local balance %d for Peer %s...", app.state.LnStakePerVal, lnID.Peer))
 							_, err := app.LnClient.CreateChannel(lnID.Peer, app.state.LnStakePerVal)
-							app.LogError(err)
+							if app.LogError(err) == nil {
+								app.LogError(app.Ledger.Record(ledger.Entry{Kind: ledger.ChannelOpen, Amount: -app.state.LnStakePerVal, Detail: lnID.Peer}))
+							}
 						} else {
 							app.logger.Info(fmt.Sprintf("Lightning Channel %s exists, skipping...", lnID.Peer))
 							continue
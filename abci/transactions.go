@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/chainpoint/chainpoint-core/anchor/bitcoin"
+	"github.com/chainpoint/chainpoint-core/anchor/ethereum"
+	"github.com/chainpoint/chainpoint-core/beacon"
+	"github.com/chainpoint/chainpoint-core/events"
 	"github.com/chainpoint/chainpoint-core/leaderelection"
 	"github.com/tendermint/tendermint/crypto/tmhash"
 	"strconv"
@@ -54,7 +58,35 @@ func (app *AnchorApplication) validateTx(rawTx []byte) types2.ResponseCheckTx {
 		if err := json.Unmarshal([]byte(tx.Data), &btcTxObj); app.LogError(err) != nil {
 			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
 		}
-		if matchErr := app.Anchor.CheckAnchor(btcTxObj); app.LogError(matchErr) != nil {
+		if matchErr := app.Anchors.Get(bitcoin.AnchorName).CheckAnchor(btcTxObj); app.LogError(matchErr) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+	case "BTC-R":
+		var btcr types.BtcTxMsg
+		if err := json.Unmarshal([]byte(tx.Data), &btcr); app.LogError(err) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+		// only the Core whose wallet sent an anchor tx can replace it
+		if app.LogError(app.checkBtcReplacement(btcr, tx.CoreID)) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+		if matchErr := app.Anchors.Get(bitcoin.AnchorName).CheckAnchor(btcr); app.LogError(matchErr) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+	case "ETH-A":
+		var etha types.BtcTxMsg
+		if err := json.Unmarshal([]byte(tx.Data), &etha); app.LogError(err) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+		if matchErr := ethereum.CheckAnchorTx(etha); app.LogError(matchErr) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+	case "ETH-C":
+		var ethc types.BtcMonMsg
+		if err := json.Unmarshal([]byte(tx.Data), &ethc); app.LogError(err) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+		if app.LogError(app.checkEthConfirmation(ethc, tx, isSubmitterVal)) != nil {
 			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
 		}
 	case "VAL":
@@ -88,6 +120,16 @@ func (app *AnchorApplication) validateTx(rawTx []byte) types2.ResponseCheckTx {
 				}
 			}
 		}
+	case "DRAND":
+		if _, err := app.verifyDrandTx(tx); !isSubmitterVal || app.LogError(err) != nil {
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
+	case "FEE":
+		fee, err := strconv.ParseInt(tx.Data, 10, 64)
+		if !isSubmitterVal || err != nil || (app.config.FeeCeiling > 0 && fee > app.config.FeeCeiling) {
+			app.logger.Info("FEE tx is not from a validator or exceeds the fee ceiling", "CoreID", tx.CoreID, "fee", tx.Data)
+			return types2.ResponseCheckTx{Code: code.CodeTypeUnauthorized, GasWanted: 1}
+		}
 	case "CHNGSTK":
 		newStakePerCore, err := strconv.ParseInt(tx.Data, 10, 64)
 		if err != nil || newStakePerCore != app.PendingChangeStake {
@@ -135,6 +177,8 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte) types2.ResponseDel
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
 	case "CAL":
 		if app.state.ChainSynced {
+			calTxHash := hex.EncodeToString(tmhash.Sum(rawTx))
+			app.Events.PublishHeld(calTxHash, events.Event{Type: events.Cal, CalTx: calTxHash})
 			go func() {
 				time.Sleep(1 * time.Minute)
 				txHash := tmhash.Sum(rawTx)
@@ -156,58 +200,149 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte) types2.ResponseDel
 		if util.LoggerError(app.logger, json.Unmarshal([]byte(tx.Data), &btca)) != nil {
 			break
 		}
+		btcCursor := app.state.Cursor(bitcoin.AnchorName)
 		//Begin monitoring using the data contained in this transaction
 		if app.state.ChainSynced {
-			go app.Anchor.BeginTxMonitor([]byte(tx.Data))
+			go app.Anchors.Get(bitcoin.AnchorName).BeginTxMonitor([]byte(tx.Data))
 			app.logger.Info(fmt.Sprintf("BTC-A StartAnchoring Data: %s", tx.Data))
+			app.Events.Publish(events.Event{Type: events.BtcA, BtcTxID: btca.BtcTxID})
 		} else {
-			app.state.BeginCalTxInt = btca.EndCalTxInt
+			btcCursor.BeginCalTxInt = btca.EndCalTxInt
 		}
 		app.state.LatestBtcaTx = rawTx
-		app.state.LatestBtcaHeight = app.state.Height + 1
+		// until the first ETH-A, evm anchoring starts with the hashes the next bitcoin anchor will include rather than the whole
+		// Calendar. Every node seeds the eth cursor here, whether or not it anchors to evm chains, so their states agree
+		if ethCursor := app.state.Cursor(ethereum.AnchorName); ethCursor.LatestAnchorTxInt == 0 && btca.EndCalTxInt > ethCursor.BeginCalTxInt {
+			ethCursor.BeginCalTxInt = btca.EndCalTxInt
+		}
+		btcCursor.Announce(btca.BtcTxID, tx.CoreID, app.state.Height, app.state.Height-ANNOUNCEMENT_BLOCKS)
+		btcCursor.LatestAnchorHeight = app.state.Height + 1
 		tags = app.incrementTxInt(tags)
-		app.state.LatestBtcaTxInt = app.state.TxInt
+		btcCursor.LatestAnchorTxInt = app.state.TxInt
 		// Keep a placeholder in case a CAL Tx is sent in between the time of a BTC-A broadcast and its handling
 		tags = append(tags, kv.Pair{Key: []byte("BTCTX"), Value: []byte(btca.BtcTxID)})
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
+	case "BTC-R":
+		var btcr types.BtcTxMsg
+		if util.LoggerError(app.logger, json.Unmarshal([]byte(tx.Data), &btcr)) != nil {
+			break
+		}
+		if app.LogError(app.checkBtcReplacement(btcr, tx.CoreID)) != nil {
+			break
+		}
+		// a replacement carries the same anchor as the tx it replaces, so the Calendar's anchor cursor doesn't move
+		btcCursor := app.state.Cursor(bitcoin.AnchorName)
+		btcCursor.Forget(btcr.ReplacesBtcTxID)
+		btcCursor.Announce(btcr.BtcTxID, tx.CoreID, app.state.Height, app.state.Height-ANNOUNCEMENT_BLOCKS)
+		if app.state.ChainSynced {
+			if btcEngine, ok := app.Anchors.Get(bitcoin.AnchorName).(*bitcoin.AnchorBTC); ok {
+				go btcEngine.ReplaceTxMonitor([]byte(tx.Data))
+			}
+			app.logger.Info(fmt.Sprintf("BTC-R %s replaces %s", btcr.BtcTxID, btcr.ReplacesBtcTxID))
+			app.Events.Publish(events.Event{Type: events.BtcR, BtcTxID: btcr.BtcTxID})
+		}
+		tags = append(tags, []kv.Pair{{Key: []byte("BTCTX"), Value: []byte(btcr.BtcTxID)},
+			{Key: []byte("REPLACES"), Value: []byte(btcr.ReplacesBtcTxID)}}...)
+		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
 	case "BTC-C":
+		btcCursor := app.state.Cursor(bitcoin.AnchorName)
 		if tx.Version == 3 {
 			btcc := types.BtcMonMsg{}
 			err := json.Unmarshal([]byte(tx.Data), &btcc)
 			if app.LogError(err) != nil {
 				break
 			}
-			if btcc.BtcHeadRoot == string(app.state.LatestBtccTx) {
-				app.logger.Info(fmt.Sprintf("We've already seen this BTC-C confirmation tx: %s", btcc.BtcHeadHeight))
+			if btcc.BtcHeadRoot == btcCursor.LatestConfirmed {
+				app.logger.Info(fmt.Sprintf("We've already seen this BTC-C confirmation tx: %d", btcc.BtcHeadHeight))
 				break
 			}
-			app.state.LatestBtccTx = []byte(btcc.BtcHeadRoot)
+			btcCursor.LatestConfirmed = btcc.BtcHeadRoot
+			btcCursor.Forget(btcc.BtcTxID)
 			tags = append(tags, []kv.Pair{{Key: []byte("BTCC"), Value: []byte(btcc.BtcHeadRoot)},
 				{Key: []byte("BTCCTX"), Value: []byte(btcc.BtcTxID)},
 				{Key: []byte("BTCCBH"), Value: util.Int64ToByte(btcc.BtcHeadHeight)}}...)
 		} else if tx.Version == 2 {
-			if tx.Data == string(app.state.LatestBtccTx) {
+			if tx.Data == btcCursor.LatestConfirmed {
 				app.logger.Info(fmt.Sprintf("We've already seen this BTC-C confirmation tx: %s", tx.Data))
 				break
 			}
-			app.state.LatestBtccTx = []byte(tx.Data)
+			btcCursor.LatestConfirmed = tx.Data
 			tags = append(tags, kv.Pair{Key: []byte("BTCC"), Value: []byte(tx.Data)})
 		}
 		tags = app.incrementTxInt(tags)
-		app.state.LatestBtccTxInt = app.state.TxInt
-		app.state.LatestBtccHeight = app.state.Height + 1
+		btcCursor.LatestConfirmTxInt = app.state.TxInt
+		btcCursor.LatestConfirmHeight = app.state.Height + 1
 		metadata := strings.Split(tx.Meta, "|") // first part of meta is core ID that issued TX, second part is BTC TX ID
 		if len(metadata) > 0 {
 			app.state.LastAnchorCoreID = metadata[0]
 			if app.state.ChainSynced {
-				go app.Anchor.AnchorReward(app.state.LastAnchorCoreID)
+				go app.Anchors.Get(bitcoin.AnchorName).AnchorReward(app.state.LastAnchorCoreID)
 			}
 			txratelimiter.IncrementSuccessAnchor(app.state.LastAnchorCoreID, app.state)
 		}
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
+	case "ETH-A":
+		var etha types.BtcTxMsg
+		if util.LoggerError(app.logger, json.Unmarshal([]byte(tx.Data), &etha)) != nil {
+			break
+		}
+		ethCursor := app.state.Cursor(ethereum.AnchorName)
+		//Begin monitoring using the data contained in this transaction
+		if app.state.ChainSynced {
+			if ethEngine := app.Anchors.Get(ethereum.AnchorName); ethEngine != nil {
+				go ethEngine.BeginTxMonitor([]byte(tx.Data))
+			}
+		} else {
+			ethCursor.BeginCalTxInt = etha.EndCalTxInt
+		}
+		ethCursor.Announce(etha.BtcTxID, tx.CoreID, app.state.Height, app.state.Height-ANNOUNCEMENT_BLOCKS)
+		ethCursor.LatestAnchorHeight = app.state.Height + 1
+		ethCursor.LatestAnchorTx = etha.BtcTxID
+		ethCursor.LatestAnchorRoot = etha.AnchorBtcAggRoot
+		tags = app.incrementTxInt(tags)
+		ethCursor.LatestAnchorTxInt = app.state.TxInt
+		tags = append(tags, kv.Pair{Key: []byte("ETHTX"), Value: []byte(etha.BtcTxID)})
+		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
+	case "ETH-C":
+		ethc := types.BtcMonMsg{}
+		if app.LogError(json.Unmarshal([]byte(tx.Data), &ethc)) != nil {
+			break
+		}
+		isSubmitterVal, _ := leaderelection.IsValidator(*app.state, tx.CoreID)
+		if app.LogError(app.checkEthConfirmation(ethc, tx, isSubmitterVal)) != nil {
+			break
+		}
+		ethCursor := app.state.Cursor(ethereum.AnchorName)
+		if ethc.BtcTxID == ethCursor.LatestConfirmed {
+			app.logger.Info(fmt.Sprintf("We've already seen this ETH-C confirmation tx: %s", ethc.BtcTxID))
+			break
+		}
+		ethCursor.LatestConfirmed = ethc.BtcTxID
+		ethCursor.Forget(ethc.BtcTxID)
+		tags = append(tags, []kv.Pair{{Key: []byte("ETHC"), Value: []byte(ethc.BtcHeadRoot)},
+			{Key: []byte("ETHCTX"), Value: []byte(ethc.BtcTxID)},
+			{Key: []byte("ETHCBH"), Value: util.Int64ToByte(ethc.BtcHeadHeight)}}...)
+		tags = app.incrementTxInt(tags)
+		ethCursor.LatestConfirmTxInt = app.state.TxInt
+		ethCursor.LatestConfirmHeight = app.state.Height + 1
+		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
 	case "NIST":
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
 	case "DRAND":
+		// a proposer can include txs that never passed this node's CheckTx, so the submitter is checked again
+		if isSubmitterVal, _ := leaderelection.IsValidator(*app.state, tx.CoreID); !isSubmitterVal {
+			app.LogError(errors.New(fmt.Sprintf("DRAND from %s, which is not a validator", tx.CoreID)))
+			break
+		}
+		round, err := app.verifyDrandTx(tx)
+		if app.LogError(err) != nil {
+			break
+		}
+		app.state.LatestTimeRecord = fmt.Sprintf("%d:%s:%s", round.Round, round.Randomness, round.Signature)
+		app.state.LatestDrandRound = int64(round.Round)
+		app.state.LatestDrandHeight = app.state.Height
+		app.setEntropy(types.Entropy{Source: types.EntropySourceDrand, Beacon: app.state.Drand().ChainHash, Round: int64(round.Round), Value: round.Randomness})
+		tags = append(tags, kv.Pair{Key: []byte("ROUND"), Value: util.Int64ToByte(int64(round.Round))})
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
 	case "FEE":
 		i, err := strconv.ParseInt(tx.Data, 10, 64)
@@ -216,6 +351,7 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte) types2.ResponseDel
 		}
 		app.state.LatestBtcFee = i
 		app.state.LastBtcFeeHeight = app.state.Height
+		app.SpendPolicy.SetLocalRate(0)
 		app.LnClient.LastFee = app.state.LatestBtcFee
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK}
 	case "JWK":
@@ -234,3 +370,55 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte) types2.ResponseDel
 	resp.Events = events
 	return resp
 }
+
+// verifyDrandTx : decodes the beacon round in a DRAND tx, accepting it only if it is newer than the current round and signed by
+// the Calendar's drand network. The network is set in genesis rather than by each node, so every node reaches the same verdict
+func (app *AnchorApplication) verifyDrandTx(tx types.Tx) (beacon.Round, error) {
+	var round beacon.Round
+	if err := json.Unmarshal([]byte(tx.Data), &round); err != nil {
+		return beacon.Round{}, err
+	}
+	if int64(round.Round) <= app.state.LatestDrandRound {
+		return beacon.Round{}, fmt.Errorf("drand round %d is not newer than round %d", round.Round, app.state.LatestDrandRound)
+	}
+	if err := app.state.Drand().Verify(round); err != nil {
+		return beacon.Round{}, err
+	}
+	return round, nil
+}
+
+// checkBtcReplacement : a BTC-R may only replace an unconfirmed btc tx that its submitter announced, in a BTC-A or an
+// earlier BTC-R. Announcers are read from the btc cursor, so every node reaches the same verdict in DeliverTx
+func (app *AnchorApplication) checkBtcReplacement(btcr types.BtcTxMsg, submitterID string) error {
+	if btcr.ReplacesBtcTxID == "" || btcr.CoreID != submitterID {
+		return errors.New(fmt.Sprintf("BTC-R from %s does not name a tx replaced by its submitter", submitterID))
+	}
+	announcerID := app.state.Cursor(bitcoin.AnchorName).Announcer(btcr.ReplacesBtcTxID)
+	if announcerID == "" {
+		return errors.New(fmt.Sprintf("BTC-R from %s replaces %s, which is not an announced unconfirmed tx", submitterID, btcr.ReplacesBtcTxID))
+	}
+	if announcerID != submitterID {
+		return errors.New(fmt.Sprintf("BTC-R from %s replaces %s, which was announced by %s", submitterID, btcr.ReplacesBtcTxID, announcerID))
+	}
+	return nil
+}
+
+// checkEthConfirmation : an ETH-C must come from a validator, confirm a tx announced in an ETH-A by the Core named in its meta,
+// and record that tx as the one anchored, since proofs' eth anchors are verified against it. Announcers are read from the eth
+// cursor, so every node reaches the same verdict in DeliverTx
+func (app *AnchorApplication) checkEthConfirmation(ethc types.BtcMonMsg, tx types.Tx, isSubmitterVal bool) error {
+	if !isSubmitterVal {
+		return errors.New(fmt.Sprintf("ETH-C from %s, which is not a validator", tx.CoreID))
+	}
+	if ethc.BtcTxID == "" || !strings.EqualFold(ethc.BtcHeadRoot, ethc.BtcTxID) {
+		return errors.New(fmt.Sprintf("ETH-C from %s records %s as the anchor tx of %s", tx.CoreID, ethc.BtcHeadRoot, ethc.BtcTxID))
+	}
+	announcerID := app.state.Cursor(ethereum.AnchorName).Announcer(ethc.BtcTxID)
+	if announcerID == "" {
+		return errors.New(fmt.Sprintf("ETH-C from %s confirms %s, which is not an announced unconfirmed tx", tx.CoreID, ethc.BtcTxID))
+	}
+	if tx.Meta != announcerID {
+		return errors.New(fmt.Sprintf("ETH-C from %s names %s as the anchoring Core of %s, which was announced by %s", tx.CoreID, tx.Meta, ethc.BtcTxID, announcerID))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package abci
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/aggregator"
+	"github.com/chainpoint/chainpoint-core/anchor/bitcoin"
+	"github.com/chainpoint/chainpoint-core/anchor/ethereum"
+	"github.com/chainpoint/chainpoint-core/beacon"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
+	"github.com/chainpoint/chainpoint-core/util"
+	lightning "github.com/chainpoint/lightning-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/abci/example/code"
+	"github.com/tendermint/tendermint/libs/log"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// testDeliverApp : an app replaying the Calendar, so DeliverTx updates state without starting any anchor engines
+func testDeliverApp() *AnchorApplication {
+	app := &AnchorApplication{state: &types.AnchorState{Height: 10}, logger: log.NewNopLogger(), LnClient: &lightning.LightningClient{},
+		SpendPolicy: bitcoin.NewSpendPolicy(types.SpendPolicyConfig{}, nil, nil)}
+	app.state.AddCursor(bitcoin.AnchorName)
+	app.state.AddCursor(ethereum.AnchorName)
+	return app
+}
+
+// testValidators : validators with the given hex addresses, which are their Core IDs
+func testValidators(ids ...string) []*tmtypes.Validator {
+	validators := make([]*tmtypes.Validator, 0, len(ids))
+	for _, id := range ids {
+		address, _ := hex.DecodeString(id)
+		validators = append(validators, &tmtypes.Validator{Address: address})
+	}
+	return validators
+}
+
+func deliver(app *AnchorApplication, tx types.Tx, data interface{}) uint32 {
+	dataJSON, _ := json.Marshal(data)
+	tx.Data = string(dataJSON)
+	return app.updateStateFromTx([]byte(util.EncodeTx(tx))).Code
+}
+
+func TestDeliverBtcReplacementChecksAnnouncer(t *testing.T) {
+	assert := assert.New(t)
+	app := testDeliverApp()
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "BTC-A", CoreID: "A"}, types.BtcTxMsg{BtcTxID: "tx1", CoreID: "A"}))
+
+	replacement := types.BtcTxMsg{BtcTxID: "tx2", ReplacesBtcTxID: "tx1", CoreID: "B"}
+	assert.Equal(code.CodeTypeUnauthorized, deliver(app, types.Tx{TxType: "BTC-R", CoreID: "B"}, replacement), "B didn't announce tx1")
+	replacement.CoreID = "A"
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "BTC-R", CoreID: "A"}, replacement))
+	assert.Equal(code.CodeTypeUnauthorized, deliver(app, types.Tx{TxType: "BTC-R", CoreID: "A"}, replacement), "tx1 was already replaced")
+
+	replacement = types.BtcTxMsg{BtcTxID: "tx3", ReplacesBtcTxID: "tx2", CoreID: "A"}
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "BTC-R", CoreID: "A"}, replacement), "a replacement can be replaced in turn")
+	assert.Equal("A", app.state.Cursor(bitcoin.AnchorName).Announcer("tx3"))
+}
+
+func TestDeliverEthConfirmationChecksAnnouncer(t *testing.T) {
+	assert := assert.New(t)
+	app := testDeliverApp()
+	app.state.Validators = testValidators("ABCD")
+	txID := "0xabc"
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "ETH-A", CoreID: "A"}, types.BtcTxMsg{BtcTxID: txID}))
+
+	ethc := types.BtcMonMsg{BtcTxID: txID, BtcHeadRoot: txID}
+	assert.Equal(code.CodeTypeUnauthorized, deliver(app, types.Tx{TxType: "ETH-C", CoreID: "ABCD", Meta: "B"}, ethc), "B didn't announce the tx")
+	assert.Equal(code.CodeTypeUnauthorized, deliver(app, types.Tx{TxType: "ETH-C", CoreID: "A", Meta: "A"}, ethc), "A isn't a validator")
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "ETH-C", CoreID: "ABCD", Meta: "A"}, ethc))
+	assert.Equal(txID, app.state.Cursor(ethereum.AnchorName).LatestConfirmed)
+}
+
+func TestDeliverBtcAnchorSeedsEthCursor(t *testing.T) {
+	assert := assert.New(t)
+	app := testDeliverApp()
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "BTC-A", CoreID: "A"}, types.BtcTxMsg{BtcTxID: "tx1", EndCalTxInt: 40}))
+	assert.Equal(int64(40), app.state.Cursor(ethereum.AnchorName).BeginCalTxInt)
+
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "ETH-A", CoreID: "A"}, types.BtcTxMsg{BtcTxID: "0xabc", BeginCalTxInt: 40, EndCalTxInt: 50}))
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "BTC-A", CoreID: "A"}, types.BtcTxMsg{BtcTxID: "tx2", EndCalTxInt: 60}))
+	assert.Equal(int64(50), app.state.Cursor(ethereum.AnchorName).BeginCalTxInt, "only the first evm anchor is seeded")
+}
+
+func TestDeliverDrandUsesNetwork(t *testing.T) {
+	assert := assert.New(t)
+	app := testDeliverApp()
+	app.ULIDGenerator = ulidthreadsafe.NewThreadSafeUlid()
+	app.aggregator = aggregator.NewAggregator(log.NewNopLogger(), app.ULIDGenerator, nil)
+	app.state.Validators = testValidators("ABCD")
+	local := beacon.NewLocalBeacon()
+	app.state.DrandNetwork = beacon.Network{PublicKey: local.PublicKeyHex(), ChainHash: "c0ffee"}
+	round, _ := local.LatestRound()
+
+	assert.Equal(code.CodeTypeUnauthorized, deliver(app, types.Tx{TxType: "DRAND", CoreID: "EF01"}, round), "only validators submit rounds")
+	impostor, _ := beacon.NewLocalBeacon().LatestRound()
+	assert.Equal(code.CodeTypeUnauthorized, deliver(app, types.Tx{TxType: "DRAND", CoreID: "ABCD"}, impostor), "rounds are verified against the network")
+	assert.Equal(code.CodeTypeOK, deliver(app, types.Tx{TxType: "DRAND", CoreID: "ABCD", Meta: "https://example.com"}, round))
+	assert.Equal("c0ffee", app.state.LatestEntropy.Beacon, "the beacon is the network's chain hash, not the submitter's meta")
+}
+
+func TestDeliverFeeReplacesLocalRate(t *testing.T) {
+	assert := assert.New(t)
+	app := testDeliverApp()
+	app.state.LatestBtcFee = 1000
+	app.SpendPolicy.SetLocalRate(5000)
+
+	tx := types.Tx{TxType: "FEE", Data: "2000", CoreID: "A"}
+	assert.Equal(code.CodeTypeOK, app.updateStateFromTx([]byte(util.EncodeTx(tx))).Code)
+	assert.Equal(int64(2000), app.state.LatestBtcFee)
+	assert.Equal(int64(2000), app.SpendPolicy.Rate(app.state.LatestBtcFee), "anchors go back to the Calendar's rate")
+}
@@ -1,14 +1,13 @@
 package aggregator
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -16,198 +15,264 @@ import (
 	"github.com/tendermint/tendermint/libs/log"
 
 	"github.com/chainpoint/chainpoint-core/util"
-
-	merkletools "github.com/chainpoint/merkletools-go"
-	"github.com/enriquebris/goconcurrentqueue"
 )
 
-// Aggregator : object includes rabbitURI and Logger
+// Aggregator : batches submitted hashes into merkle trees. Hashes arrive on a channel and are aggregated by a pool
+// of workers whenever a worker has HASHES_PER_MERKLE_TREE hashes, every AGGREGATION_FLUSH_SECONDS, or when AggregateAndReset is called
 type Aggregator struct {
-	HashItems    goconcurrentqueue.Queue //In
-	Logger       log.Logger
-	LatestTime   string
-	Aggregations goconcurrentqueue.Queue //Out
-	AggMutex     sync.Mutex
-	RestartMutex sync.Mutex
-	QueueMutex   sync.Mutex
-	TempStop     chan struct{}
-	WaitGroup    sync.WaitGroup
-	UlidGen      *ulidthreadsafe.ThreadSafeUlid
+	Logger        log.Logger
+	UlidGen       *ulidthreadsafe.ThreadSafeUlid
+	Wal           *WAL
+	hashes        chan walEntry
+	flushes       []chan chan struct{}
+	stopped       chan struct{}
+	entropyMutex  sync.RWMutex
+	entropy       types.Entropy
+	batchSize     int
+	flushInterval time.Duration
+	aggMutex      sync.Mutex
+	aggregations  []types.Aggregation
+	walKeys       map[string][]string // the WAL keys of the hashes in each uncommitted aggregation, by agg id
+}
+
+// NewAggregator : creates an aggregator whose accepted hashes are logged to wal until committed
+func NewAggregator(logger log.Logger, ulidGen *ulidthreadsafe.ThreadSafeUlid, wal *WAL) *Aggregator {
+	aggThreads, _ := strconv.Atoi(util.GetEnv("AGGREGATION_THREADS", "4"))
+	hashBatchSize, _ := strconv.Atoi(util.GetEnv("HASHES_PER_MERKLE_TREE", "25000"))
+	flushSeconds, _ := strconv.Atoi(util.GetEnv("AGGREGATION_FLUSH_SECONDS", "30"))
+	queueSize, _ := strconv.Atoi(util.GetEnv("AGGREGATION_QUEUE_SIZE", "100000"))
+	if aggThreads < 1 {
+		aggThreads = 1
+	}
+	if flushSeconds < 1 {
+		flushSeconds = 1
+	}
+	flushes := make([]chan chan struct{}, aggThreads)
+	for i := range flushes {
+		flushes[i] = make(chan chan struct{})
+	}
+	return &Aggregator{
+		Logger:        logger,
+		UlidGen:       ulidGen,
+		Wal:           wal,
+		hashes:        make(chan walEntry, queueSize),
+		flushes:       flushes,
+		stopped:       make(chan struct{}),
+		entropy:       types.Entropy{Source: types.EntropySourceNone},
+		batchSize:     hashBatchSize,
+		flushInterval: time.Duration(flushSeconds) * time.Second,
+		aggregations:  make([]types.Aggregation, 0),
+		walKeys:       map[string][]string{},
+	}
+}
+
+// SetEntropy : sets the entropy prefixed to the leaves of subsequent aggregations
+func (aggregator *Aggregator) SetEntropy(entropy types.Entropy) {
+	aggregator.entropyMutex.Lock()
+	aggregator.entropy = entropy
+	aggregator.entropyMutex.Unlock()
 }
 
+// Entropy : the entropy prefixed to the leaves of new aggregations
+func (aggregator *Aggregator) Entropy() types.Entropy {
+	aggregator.entropyMutex.RLock()
+	defer aggregator.entropyMutex.RUnlock()
+	return aggregator.entropy
+}
+
+// AggregateAndReset : has every worker aggregate the hashes it holds, then returns and clears all pending aggregations
 func (aggregator *Aggregator) AggregateAndReset() []types.Aggregation {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered", r)
-		}
-	}()
-	close(aggregator.TempStop)
-	aggregator.RestartMutex.Lock()
-	queueLen := aggregator.Aggregations.GetLen()
-	aggregations := make([]types.Aggregation, 0)
-	if aggregator.Aggregations.GetLen() > 0 {
-		for i := 0; i < queueLen; i++ {
-			item, err := aggregator.Aggregations.Dequeue()
-			if util.LogError(err) != nil {
-				return aggregations
-			}
-			value, ok := item.(types.Aggregation)
-			if !ok {
-				return aggregations
-			}
-			aggregations = append(aggregations, value)
+	acks := make([]chan struct{}, 0, len(aggregator.flushes))
+	for _, flush := range aggregator.flushes {
+		ack := make(chan struct{})
+		select {
+		case flush <- ack:
+			acks = append(acks, ack)
+		case <-aggregator.stopped:
 		}
 	}
+	for _, ack := range acks {
+		<-ack
+	}
+	aggregator.aggMutex.Lock()
+	aggregations := aggregator.aggregations
+	aggregator.aggregations = make([]types.Aggregation, 0)
+	aggregator.aggMutex.Unlock()
 	aggregator.Logger.Info(fmt.Sprintf("Retrieved aggregation tree of %d items and resetting", len(aggregations)))
-	aggregator.RestartMutex.Unlock()
 	return aggregations
 }
 
-func (aggregator *Aggregator) AddHashItem(item types.HashItem) {
-	util.LogError(aggregator.HashItems.Enqueue(item))
+// ErrQueueFull : returned instead of blocking when the aggregation queue has no room for more hashes
+var ErrQueueFull = errors.New("aggregation queue is full")
+
+// AddHashItem : logs a hash to the WAL and queues it for aggregation, or returns ErrQueueFull
+func (aggregator *Aggregator) AddHashItem(item types.HashItem) error {
+	return aggregator.AddHashItems([]types.HashItem{item})
 }
 
-func (aggregator *Aggregator) HeadHashItem() types.HashItem {
-	item, err := aggregator.HashItems.Dequeue()
+// AddHashItems : logs a batch to the WAL and queues it as a single entry, so the whole batch is aggregated into the same tree.
+// If the queue is full the batch is dropped from the WAL again and ErrQueueFull is returned, so that request handlers
+// never block on a backed up aggregator
+func (aggregator *Aggregator) AddHashItems(items []types.HashItem) error {
+	key, err := aggregator.Wal.Append(items)
 	if err != nil {
-		return types.HashItem{}
+		return err
 	}
-	value, ok := item.(types.HashItem)
-	if !ok {
-		return types.HashItem{}
+	select {
+	case aggregator.hashes <- walEntry{Key: key, Items: items}:
+		return nil
+	default:
+		if err := aggregator.Wal.Remove([]string{key}); err != nil {
+			return err
+		}
+		return ErrQueueFull
 	}
-	return value
 }
 
-// ReceiveCalRMQ : Continually consume the calendar work queue and
-// process any resulting messages from the tx and monitor services
-func (aggregator *Aggregator) StartAggregation() error {
-	aggThreads, _ := strconv.Atoi(util.GetEnv("AGGREGATION_THREADS", "4"))
-	hashBatchSize, _ := strconv.Atoi(util.GetEnv("HASHES_PER_MERKLE_TREE", "25000"))
-	aggregator.Logger.Info(fmt.Sprintf("Starting aggregation with %d threads and %d batch size", aggThreads, hashBatchSize))
-	aggregator.HashItems = goconcurrentqueue.NewFIFO()
-	//Consume queue in goroutines with output slice guarded by mutex
-	aggregator.Aggregations = goconcurrentqueue.NewFIFO()
-	aggregator.UlidGen = ulidthreadsafe.NewThreadSafeUlid()
-	for {
-		aggregator.RestartMutex.Lock()
-		aggregator.TempStop = make(chan struct{})
-		consume := true
-		// Spin up {aggThreads} number of threads to process incoming hashes from the API
-		for i := 0; i < aggThreads; i++ {
-			aggregator.WaitGroup.Add(1)
-			go func() {
-				msgStructSlice := make([]types.HashItem, 0)
-				defer aggregator.WaitGroup.Done()
-				for consume {
-					select {
-					case <-aggregator.TempStop:
-						consume = false
-						break
-					default:
-						hash := aggregator.HeadHashItem()
-						if len(hash.Hash) > 0 {
-							msgStructSlice = append(msgStructSlice, hash)
-							//aggregator.Logger.Info(fmt.Sprintf("Hash: %s", hash.Hash))
-							//create new agg roots under heavy load
-							if len(msgStructSlice) > hashBatchSize {
-								if agg := aggregator.ProcessAggregation(msgStructSlice, aggregator.LatestTime); agg.AggRoot != "" {
-									aggregator.Aggregations.Enqueue(agg)
-									msgStructSlice = make([]types.HashItem, 0)
-								}
-							}
-						}
-					}
-				}
-				if len(msgStructSlice) > 0 {
-					if agg := aggregator.ProcessAggregation(msgStructSlice, aggregator.LatestTime); agg.AggRoot != "" {
-						aggregator.Aggregations.Enqueue(agg)
-					}
-				}
-			}()
-		}
-		aggregator.WaitGroup.Wait()
-		aggregator.Logger.Info("aggregation threads stopped")
-		aggregator.RestartMutex.Unlock()
-		time.Sleep(5 * time.Second)
+// Replay : queues every hash left in the WAL by a previous run, returning the number of hashes recovered.
+// Entries are queued in the background so a large backlog can't block startup
+func (aggregator *Aggregator) Replay() (int, error) {
+	entries, err := aggregator.Wal.entries()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		count += len(entry.Items)
 	}
+	go func() {
+		for _, entry := range entries {
+			aggregator.hashes <- entry
+		}
+	}()
+	return count, nil
 }
 
-// ProcessAggregation creates merkle trees of received hashes a la https://github.com/chainpoint/chainpoint-services/blob/develop/node-aggregator-service/server.js#L66
-func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []types.HashItem, drand string) types.Aggregation {
-	aggStates := make([]types.AggState, 0)
-	var agg types.Aggregation
-	hashSlice := make([][]byte, 0) // byte array
+// Requeue : returns aggregations that couldn't be committed to the pending aggregations, so the next AggregateAndReset
+// returns them again. Their hashes are still in the WAL. Unlike queueing their hashes, this never blocks the caller
+func (aggregator *Aggregator) Requeue(aggs []types.Aggregation) {
+	aggregator.aggMutex.Lock()
+	requeued := make([]types.Aggregation, 0, len(aggs)+len(aggregator.aggregations))
+	aggregator.aggregations = append(append(requeued, aggs...), aggregator.aggregations...)
+	aggregator.aggMutex.Unlock()
+}
 
-	for _, msgHash := range msgStructSlice {
-		//decode hash to bytes and concatenate onto nist bytes
-		hashBytes, _ := hex.DecodeString(msgHash.Hash)
+// Commit : removes the hashes of committed aggregations from the WAL
+func (aggregator *Aggregator) Commit(aggs []types.Aggregation) error {
+	keys := []string{}
+	aggregator.aggMutex.Lock()
+	for _, agg := range aggs {
+		keys = append(keys, aggregator.walKeys[agg.AggID]...)
+		delete(aggregator.walKeys, agg.AggID)
+	}
+	aggregator.aggMutex.Unlock()
+	return aggregator.Wal.Remove(keys)
+}
 
-		//Create checksum
-		var newHash [32]byte
+// StartAggregation : runs the aggregation workers until ctx is cancelled
+func (aggregator *Aggregator) StartAggregation(ctx context.Context) {
+	aggregator.Logger.Info(fmt.Sprintf("Starting aggregation with %d threads, %d batch size and %s flush interval",
+		len(aggregator.flushes), aggregator.batchSize, aggregator.flushInterval))
+	var wg sync.WaitGroup
+	for _, flush := range aggregator.flushes {
+		wg.Add(1)
+		go func(flush chan chan struct{}) {
+			defer wg.Done()
+			aggregator.work(ctx, flush)
+		}(flush)
+	}
+	wg.Wait()
+	close(aggregator.stopped)
+	aggregator.Logger.Info("aggregation threads stopped")
+}
 
-		if drand != "" {
-			var timeBuffer bytes.Buffer
-			timeBuffer.WriteString(fmt.Sprintf("drand:%s", drand))
-			newHash = sha256.Sum256(append(timeBuffer.Bytes(), hashBytes...))
-		} else {
-			copy(newHash[:], hashBytes)
+// work : collects hashes until the batch size is reached, the flush interval passes or a flush is requested.
+// Hashes held when ctx is cancelled stay in the WAL and are replayed on the next start
+func (aggregator *Aggregator) work(ctx context.Context, flush chan chan struct{}) {
+	pending := make([]types.HashItem, 0)
+	pendingKeys := make([]string, 0)
+	aggregate := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if agg := aggregator.ProcessAggregation(pending, aggregator.Entropy()); agg.AggRoot != "" {
+			aggregator.aggMutex.Lock()
+			aggregator.aggregations = append(aggregator.aggregations, agg)
+			aggregator.walKeys[agg.AggID] = pendingKeys
+			aggregator.aggMutex.Unlock()
+			pending = make([]types.HashItem, 0)
+			pendingKeys = make([]string, 0)
 		}
-		hashSlice = append(hashSlice, newHash[:])
 	}
+	ticker := time.NewTicker(aggregator.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-aggregator.hashes:
+			pending = append(pending, entry.Items...)
+			pendingKeys = append(pendingKeys, entry.Key)
+			if len(pending) >= aggregator.batchSize {
+				aggregate()
+			}
+		case <-ticker.C:
+			aggregate()
+		case ack := <-flush:
+			aggregate()
+			close(ack)
+		}
+	}
+}
 
-	if len(msgStructSlice) == 0 || len(hashSlice) == 0 {
+// ProcessAggregation creates merkle trees of received hashes a la https://github.com/chainpoint/chainpoint-services/blob/develop/node-aggregator-service/server.js#L66
+// Subtrees and proof paths are built in parallel, and ops are left in AggState.Ops to be marshalled when stored.
+// The source of the leaf prefix is recorded alongside the ops of every AggState, even when there is none
+func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []types.HashItem, entropy types.Entropy) types.Aggregation {
+	if len(msgStructSlice) == 0 {
 		return types.Aggregation{}
 	}
+	var agg types.Aggregation
+	var prefix []types.ProofLineItem
+	entropyBytes := []byte(entropy.Prefix())
+	if len(entropyBytes) > 0 {
+		prefix = []types.ProofLineItem{{Left: string(entropyBytes)}, {Op: "sha-256"}}
+	}
 
 	//Merkle tree creation
-	var tree merkletools.MerkleTree
-	tree.AddLeaves(hashSlice)
-	tree.MakeTree()
+	tree := buildChunkedMerkleTree(len(msgStructSlice), func(i int) []byte {
+		//decode hash to bytes and concatenate onto entropy bytes
+		hashBytes, _ := hex.DecodeString(msgStructSlice[i].Hash)
+		var newHash [32]byte
+		if len(entropyBytes) > 0 {
+			newHash = sha256.Sum256(append(append(make([]byte, 0, len(entropyBytes)+len(hashBytes)), entropyBytes...), hashBytes...))
+		} else {
+			copy(newHash[:], hashBytes)
+		}
+		return newHash[:]
+	})
 	ulid, err := aggregator.UlidGen.NewUlid()
 	if util.LogError(err) != nil {
 		return types.Aggregation{}
 	}
 	agg.AggID = ulid.String()
-	agg.AggRoot = hex.EncodeToString(tree.GetMerkleRoot())
+	agg.AggRoot = hex.EncodeToString(tree.root())
 
 	//Create proof paths
+	paths := tree.paths(len(msgStructSlice), prefix)
+	aggStates := make([]types.AggState, len(msgStructSlice))
 	for i, unPackedHash := range msgStructSlice {
-		var proofData types.ProofData
-		proofData.ProofID = unPackedHash.ProofID
-		proofData.Hash = unPackedHash.Hash
-		proofs := tree.GetProof(i)
-		if drand != "" {
-			proofs = append([]merkletools.ProofStep{{Left: true, Value: []byte(fmt.Sprintf("drand:%s", drand))}}, proofs...)
-		}
-		proofOps := make([]types.ProofLineItem, 0)
-		for _, p := range proofs {
-			if p.Left {
-				if strings.Contains(string(p.Value), "drand") {
-					proofOps = append(proofOps, types.ProofLineItem{Left: string(p.Value)})
-				} else {
-					proofOps = append(proofOps, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
-				}
-			} else {
-				proofOps = append(proofOps, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
-			}
-			proofOps = append(proofOps, types.ProofLineItem{Op: "sha-256"})
-		}
-		aggState := types.AggState{}
-		aggState.AggID = agg.AggID
-		aggState.AggRoot = agg.AggRoot
-		aggState.ProofID = unPackedHash.ProofID
-		aggState.Hash = unPackedHash.Hash
-		ops := types.OpsState{}
-		ops.Ops = proofOps
-		opsBytes, err := json.Marshal(ops)
-		if err != nil {
-			continue
+		aggStates[i] = types.AggState{
+			ProofID:       unPackedHash.ProofID,
+			Hash:          unPackedHash.Hash,
+			HashAlgorithm: unPackedHash.HashAlgorithm,
+			AggID:         agg.AggID,
+			AggRoot:       agg.AggRoot,
+			Ops:           paths[i],
+			Entropy:       &entropy,
 		}
-		aggState.AggState = string(opsBytes)
-		aggStates = append(aggStates, aggState)
 	}
-	aggregator.Logger.Debug(fmt.Sprintf("Aggregated: %#v", aggStates))
+	aggregator.Logger.Debug(fmt.Sprintf("Aggregated %d hashes under root %s", len(aggStates), agg.AggRoot))
 	agg.AggStates = aggStates
 	return agg
 }
@@ -0,0 +1,59 @@
+package aggregator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestAggregateAndResetFlushesWorkers(t *testing.T) {
+	assert := assert.New(t)
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), NewWAL(dbm.NewMemDB()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		agg.StartAggregation(ctx)
+		close(done)
+	}()
+
+	assert.Nil(agg.AddHashItem(types.HashItem{ProofID: "a", Hash: strings.Repeat("aa", 32)}))
+	assert.Nil(agg.AddHashItems([]types.HashItem{{ProofID: "b", Hash: strings.Repeat("bb", 32)}, {ProofID: "c", Hash: strings.Repeat("cc", 32)}}))
+	// queued hashes may not have reached a worker yet, so keep flushing until all three are aggregated
+	proofIds := map[string]bool{}
+	for len(proofIds) < 3 {
+		for _, aggregation := range agg.AggregateAndReset() {
+			for _, aggState := range aggregation.AggStates {
+				proofIds[aggState.ProofID] = true
+			}
+		}
+	}
+	assert.Empty(agg.AggregateAndReset())
+
+	cancel()
+	<-done
+	// flushing a stopped aggregator must not block
+	assert.Empty(agg.AggregateAndReset())
+	// requeued aggregations come back from the next flush without going through the queue
+	requeued := []types.Aggregation{{AggRoot: "root", AggStates: []types.AggState{{ProofID: "a"}}}}
+	agg.Requeue(requeued)
+	assert.Equal(requeued, agg.AggregateAndReset())
+}
+
+func TestAddHashItemsWhenQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("AGGREGATION_QUEUE_SIZE", "1")
+	wal := NewWAL(dbm.NewMemDB())
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), wal)
+	assert.Nil(agg.AddHashItem(types.HashItem{ProofID: "a", Hash: "aa"}))
+	assert.Equal(ErrQueueFull, agg.AddHashItems([]types.HashItem{{ProofID: "b", Hash: "bb"}, {ProofID: "c", Hash: "cc"}}))
+	// the rejected batch must not be replayed after a restart
+	entries, err := wal.Entries()
+	assert.Nil(err)
+	assert.Equal([][]types.HashItem{{{ProofID: "a", Hash: "aa"}}}, entries)
+}
@@ -0,0 +1,148 @@
+package aggregator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime"
+	"sync"
+
+	"github.com/chainpoint/chainpoint-core/types"
+)
+
+// minChunkSize : fewest leaves worth handing to their own goroutine
+const minChunkSize = 256
+
+// merkleTree : a sha-256 merkle tree kept as flat levels, leaves first and the root last. It is built like
+// merkletools' MakeTree: nodes are paired left to right and an odd last node is promoted unchanged.
+// Every node is hex encoded once so proof paths can share the strings.
+type merkleTree struct {
+	levels    [][][]byte
+	hexLevels [][]string
+}
+
+func buildMerkleTree(leaves [][]byte) merkleTree {
+	tree := merkleTree{levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next[i/2] = level[i]
+				continue
+			}
+			pair := make([]byte, 0, len(level[i])+len(level[i+1]))
+			pair = append(append(pair, level[i]...), level[i+1]...)
+			sum := sha256.Sum256(pair)
+			next[i/2] = sum[:]
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	tree.hexLevels = make([][]string, len(tree.levels))
+	for l, level := range tree.levels {
+		tree.hexLevels[l] = make([]string, len(level))
+		for i, node := range level {
+			tree.hexLevels[l][i] = hex.EncodeToString(node)
+		}
+	}
+	return tree
+}
+
+func (tree merkleTree) root() []byte {
+	return tree.levels[len(tree.levels)-1][0]
+}
+
+// appendPath : appends the ops leading from the leaf at index to the root, skipping levels where the node was promoted
+func (tree merkleTree) appendPath(ops []types.ProofLineItem, index int) []types.ProofLineItem {
+	for l := 0; l < len(tree.levels)-1; l++ {
+		sibling := index ^ 1
+		if sibling < len(tree.levels[l]) {
+			if sibling < index {
+				ops = append(ops, types.ProofLineItem{Left: tree.hexLevels[l][sibling]})
+			} else {
+				ops = append(ops, types.ProofLineItem{Right: tree.hexLevels[l][sibling]})
+			}
+			ops = append(ops, types.ProofLineItem{Op: "sha-256"})
+		}
+		index /= 2
+	}
+	return ops
+}
+
+// chunkedMerkleTree : one merkle tree over all leaves, built as power-of-two sized subtrees in parallel plus a top tree
+// over their roots. Because each chunk is a power of two, every subtree root is exactly the node the single tree
+// would have at that position, so roots and proof paths match a tree built in one piece.
+type chunkedMerkleTree struct {
+	chunkSize int
+	chunks    []merkleTree
+	top       merkleTree
+}
+
+// buildChunkedMerkleTree : hashes leaves with leafHash and builds the tree, spreading the work over GOMAXPROCS goroutines
+func buildChunkedMerkleTree(count int, leafHash func(i int) []byte) chunkedMerkleTree {
+	chunkSize := minChunkSize
+	for chunkSize*runtime.GOMAXPROCS(0) < count {
+		chunkSize *= 2
+	}
+	tree := chunkedMerkleTree{
+		chunkSize: chunkSize,
+		chunks:    make([]merkleTree, (count+chunkSize-1)/chunkSize),
+	}
+	var wg sync.WaitGroup
+	for c := range tree.chunks {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			start, end := tree.chunkRange(c, count)
+			leaves := make([][]byte, 0, end-start)
+			for i := start; i < end; i++ {
+				leaves = append(leaves, leafHash(i))
+			}
+			tree.chunks[c] = buildMerkleTree(leaves)
+		}(c)
+	}
+	wg.Wait()
+	roots := make([][]byte, len(tree.chunks))
+	for c, chunk := range tree.chunks {
+		roots[c] = chunk.root()
+	}
+	tree.top = buildMerkleTree(roots)
+	return tree
+}
+
+func (tree chunkedMerkleTree) chunkRange(c int, count int) (int, int) {
+	start := c * tree.chunkSize
+	end := start + tree.chunkSize
+	if end > count {
+		end = count
+	}
+	return start, end
+}
+
+func (tree chunkedMerkleTree) root() []byte {
+	return tree.top.root()
+}
+
+// paths : derives every leaf's proof ops in a single parallel pass. Each leaf's ops begin with prefix, and the
+// path above each subtree is computed once and shared by all of its leaves
+func (tree chunkedMerkleTree) paths(count int, prefix []types.ProofLineItem) [][]types.ProofLineItem {
+	paths := make([][]types.ProofLineItem, count)
+	var wg sync.WaitGroup
+	for c := range tree.chunks {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			topPath := tree.top.appendPath(nil, c)
+			chunk := tree.chunks[c]
+			depth := 2 * (len(chunk.levels) - 1)
+			start, end := tree.chunkRange(c, count)
+			for i := start; i < end; i++ {
+				ops := make([]types.ProofLineItem, 0, len(prefix)+depth+len(topPath))
+				ops = append(ops, prefix...)
+				ops = chunk.appendPath(ops, i-start)
+				paths[i] = append(ops, topPath...)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return paths
+}
@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
+	merkletools "github.com/chainpoint/merkletools-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func testHashItems(count int) []types.HashItem {
+	items := make([]types.HashItem, count)
+	for i := range items {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		items[i] = types.HashItem{ProofID: strconv.Itoa(i), Hash: hex.EncodeToString(sum[:])}
+	}
+	return items
+}
+
+// merkletoolsPaths : root and ops for each leaf as ProcessAggregation produced them with merkletools
+func merkletoolsPaths(leaves [][]byte) ([]byte, [][]types.ProofLineItem) {
+	var tree merkletools.MerkleTree
+	tree.AddLeaves(leaves)
+	tree.MakeTree()
+	paths := make([][]types.ProofLineItem, len(leaves))
+	for i := range leaves {
+		ops := []types.ProofLineItem{}
+		for _, p := range tree.GetProof(i) {
+			if p.Left {
+				ops = append(ops, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
+			} else {
+				ops = append(ops, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
+			}
+			ops = append(ops, types.ProofLineItem{Op: "sha-256"})
+		}
+		paths[i] = ops
+	}
+	return tree.GetMerkleRoot(), paths
+}
+
+func TestChunkedMerkleTreeMatchesMerkletools(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 5, 8, 255, 256, 257, 1000, 3001} {
+		leaves := make([][]byte, count)
+		for i := range leaves {
+			sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+			leaves[i] = sum[:]
+		}
+		expectedRoot, expectedPaths := merkletoolsPaths(leaves)
+		tree := buildChunkedMerkleTree(count, func(i int) []byte { return leaves[i] })
+		assert.Equal(t, expectedRoot, tree.root(), "root for %d leaves", count)
+		paths := tree.paths(count, nil)
+		for i := range leaves {
+			if !assert.Equal(t, expectedPaths[i], paths[i], "path %d of %d leaves", i, count) {
+				break
+			}
+		}
+	}
+}
+
+var testDrand = types.Entropy{Source: types.EntropySourceDrand, Beacon: "https://api.drand.sh", Round: 123, Value: "abc"}
+
+func TestProcessAggregationPrefixesDrand(t *testing.T) {
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), nil)
+	aggregation := agg.ProcessAggregation(testHashItems(3), testDrand)
+	assert.Len(t, aggregation.AggStates, 3)
+	ops := aggregation.AggStates[0].Ops
+	assert.Equal(t, types.ProofLineItem{Left: "drand:123:abc"}, ops[0])
+	assert.Equal(t, types.ProofLineItem{Op: "sha-256"}, ops[1])
+	opsJSON, err := aggregation.AggStates[0].OpsJSON()
+	assert.Nil(t, err)
+	stored := types.OpsState{}
+	assert.Nil(t, json.Unmarshal([]byte(opsJSON), &stored))
+	assert.Equal(t, ops, stored.Ops)
+	assert.Equal(t, &testDrand, stored.Entropy)
+}
+
+func TestProcessAggregationRecordsEntropySource(t *testing.T) {
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), nil)
+	tendermint := types.Entropy{Source: types.EntropySourceTendermint, Beacon: "mainnet-chain-32", Round: 42, Value: "ABCD"}
+	aggState := agg.ProcessAggregation(testHashItems(2), tendermint).AggStates[0]
+	assert.Equal(t, &tendermint, aggState.Entropy)
+	assert.Equal(t, "tendermint:42:ABCD", aggState.Ops[0].Left)
+
+	items := testHashItems(2)
+	aggState = agg.ProcessAggregation(items, agg.Entropy()).AggStates[0]
+	assert.Equal(t, types.EntropySourceNone, aggState.Entropy.Source)
+	assert.Equal(t, types.ProofLineItem{Right: items[1].Hash}, aggState.Ops[0])
+}
+
+// legacyProcessAggregation : the previous single merkletools tree with a GetProof walk and JSON marshal per leaf, kept as a baseline
+func legacyProcessAggregation(items []types.HashItem, drand string) []types.AggState {
+	leaves := make([][]byte, len(items))
+	for i, item := range items {
+		hashBytes, _ := hex.DecodeString(item.Hash)
+		sum := sha256.Sum256(append([]byte(fmt.Sprintf("drand:%s", drand)), hashBytes...))
+		leaves[i] = sum[:]
+	}
+	var tree merkletools.MerkleTree
+	tree.AddLeaves(leaves)
+	tree.MakeTree()
+	aggStates := make([]types.AggState, 0, len(items))
+	for i, item := range items {
+		ops := []types.ProofLineItem{{Left: fmt.Sprintf("drand:%s", drand)}, {Op: "sha-256"}}
+		for _, p := range tree.GetProof(i) {
+			if p.Left {
+				ops = append(ops, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
+			} else {
+				ops = append(ops, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
+			}
+			ops = append(ops, types.ProofLineItem{Op: "sha-256"})
+		}
+		opsBytes, _ := json.Marshal(types.OpsState{Ops: ops})
+		aggStates = append(aggStates, types.AggState{ProofID: item.ProofID, Hash: item.Hash, AggState: string(opsBytes)})
+	}
+	return aggStates
+}
+
+func benchmarkProcessAggregation(b *testing.B, count int) {
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), nil)
+	items := testHashItems(count)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		agg.ProcessAggregation(items, testDrand)
+	}
+}
+
+func benchmarkLegacyProcessAggregation(b *testing.B, count int) {
+	items := testHashItems(count)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		legacyProcessAggregation(items, "123:abc")
+	}
+}
+
+func BenchmarkProcessAggregation1k(b *testing.B)        { benchmarkProcessAggregation(b, 1000) }
+func BenchmarkProcessAggregation25k(b *testing.B)       { benchmarkProcessAggregation(b, 25000) }
+func BenchmarkLegacyProcessAggregation1k(b *testing.B)  { benchmarkLegacyProcessAggregation(b, 1000) }
+func BenchmarkLegacyProcessAggregation25k(b *testing.B) { benchmarkLegacyProcessAggregation(b, 25000) }
+
+// BenchmarkStoreAggStates : the deferred marshalling cost paid when aggregation states are stored
+func BenchmarkStoreAggStates25k(b *testing.B) {
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), nil)
+	aggregation := agg.ProcessAggregation(testHashItems(25000), testDrand)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, aggState := range aggregation.AggStates {
+			aggState.OpsJSON()
+		}
+	}
+}
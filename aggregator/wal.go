@@ -0,0 +1,86 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// walPrefix : entries are keyed by walPrefix, the time they were logged in unix nanoseconds and a sequence number, so that
+// batches sharing proof ids never overwrite each other and are replayed in the order they were accepted
+const walPrefix = "aggwal:"
+
+// WAL : write-ahead log of accepted hashes that haven't been committed to a CAL tx yet, so they survive a restart.
+// A batch is logged as a single entry, since batches are always aggregated and committed together.
+type WAL struct {
+	db  dbm.DB
+	seq uint64
+}
+
+// walEntry : a logged hash or batch and the key it is logged under
+type walEntry struct {
+	Key   string
+	Items []types.HashItem
+}
+
+// NewWAL : creates a WAL stored in db
+func NewWAL(db dbm.DB) *WAL {
+	return &WAL{db: db}
+}
+
+// Append : durably logs a hash or batch of hashes, returning the key to remove it by once committed
+func (wal *WAL) Append(items []types.HashItem) (string, error) {
+	if len(items) == 0 {
+		return "", nil
+	}
+	itemBytes, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%020d:%010d", walPrefix, time.Now().UnixNano(), atomic.AddUint64(&wal.seq, 1))
+	return key, wal.db.SetSync([]byte(key), itemBytes)
+}
+
+// Remove : drops the entries logged under keys
+func (wal *WAL) Remove(keys []string) error {
+	batch := wal.db.NewBatch()
+	defer batch.Close()
+	for _, key := range keys {
+		batch.Delete([]byte(key))
+	}
+	return batch.WriteSync()
+}
+
+// Entries : returns every logged hash or batch
+func (wal *WAL) Entries() ([][]types.HashItem, error) {
+	entries, err := wal.entries()
+	if err != nil {
+		return nil, err
+	}
+	items := make([][]types.HashItem, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.Items)
+	}
+	return items, nil
+}
+
+func (wal *WAL) entries() ([]walEntry, error) {
+	it, err := dbm.IteratePrefix(wal.db, []byte(walPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	entries := []walEntry{}
+	for ; it.Valid(); it.Next() {
+		items := []types.HashItem{}
+		if err := json.Unmarshal(it.Value(), &items); err != nil {
+			continue
+		}
+		entries = append(entries, walEntry{Key: string(it.Key()), Items: items})
+	}
+	return entries, nil
+}
@@ -0,0 +1,60 @@
+package aggregator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/ulidthreadsafe"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestReplayRecoversUncommittedHashes(t *testing.T) {
+	assert := assert.New(t)
+	db := dbm.NewMemDB()
+	agg := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), NewWAL(db))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		agg.StartAggregation(ctx)
+		close(done)
+	}()
+	assert.Nil(agg.AddHashItem(types.HashItem{ProofID: "a", Hash: strings.Repeat("aa", 32)}))
+	assert.Nil(agg.AddHashItems([]types.HashItem{{ProofID: "b", Hash: strings.Repeat("bb", 32)}, {ProofID: "c", Hash: strings.Repeat("cc", 32)}}))
+
+	// "a" and the "b"/"c" batch were anchored before the restart
+	committed := []types.Aggregation{}
+	for aggregated := 0; aggregated < 3; {
+		for _, aggregation := range agg.AggregateAndReset() {
+			committed = append(committed, aggregation)
+			aggregated += len(aggregation.AggStates)
+		}
+	}
+	assert.Nil(agg.Commit(committed))
+	cancel()
+	<-done
+	assert.Nil(agg.AddHashItem(types.HashItem{ProofID: "d", Hash: "dd"}))
+
+	restarted := NewAggregator(log.NewNopLogger(), ulidthreadsafe.NewThreadSafeUlid(), NewWAL(db))
+	recovered, err := restarted.Replay()
+	assert.Nil(err)
+	assert.Equal(1, recovered)
+	assert.Equal([]types.HashItem{{ProofID: "d", Hash: "dd"}}, (<-restarted.hashes).Items)
+}
+
+func TestWALKeepsBatchesSharingProofIds(t *testing.T) {
+	assert := assert.New(t)
+	wal := NewWAL(dbm.NewMemDB())
+	first, err := wal.Append([]types.HashItem{{ProofID: "a", Hash: "aa"}})
+	assert.Nil(err)
+	_, err = wal.Append([]types.HashItem{{ProofID: "a", Hash: "aa"}, {ProofID: "b", Hash: "bb"}})
+	assert.Nil(err)
+
+	assert.Nil(wal.Remove([]string{first}))
+	entries, err := wal.Entries()
+	assert.Nil(err)
+	assert.Equal([][]types.HashItem{{{ProofID: "a", Hash: "aa"}, {ProofID: "b", Hash: "bb"}}}, entries)
+}
@@ -0,0 +1,248 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/chainpoint/chainpoint-core/util"
+	lightning "github.com/chainpoint/lightning-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+)
+
+// Block : a bitcoin block's merkle root and txids, in the byte order displayed by block explorers
+type Block struct {
+	Height     int64
+	MerkleRoot string
+	TxIDs      []string
+}
+
+// ChainBackend : the bitcoin wallet and chain access used by the bitcoin anchor engine
+type ChainBackend interface {
+	// SendOpReturn : funds, signs and broadcasts a tx carrying data in an OP_RETURN output, paying satPerKw, and returns its txid and raw tx without witness data
+	SendOpReturn(data []byte, satPerKw int64) (string, string, error)
+
+	// SendTaprootCommitment : like SendOpReturn, but commits to data in the tweak of a taproot output of internalKey. The
+	// outputs of previous anchors that are still unspent fund it
+	SendTaprootCommitment(internalKey *btcec.PrivateKey, data []byte, previous []TaprootAnchor, satPerKw int64) (string, string, error)
+
+	// BumpTaprootCommitment : like BumpFee, for a tx sent by SendTaprootCommitment that pays to the output of one of known
+	BumpTaprootCommitment(internalKey *btcec.PrivateKey, txid string, known []TaprootAnchor, satPerKw int64) (string, string, error)
+
+	// BumpFee : raises the fee rate of an unconfirmed anchor tx to satPerKw, returning the txid and raw tx without witness
+	// data of the tx now carrying the anchor. That's the replacement for backends that replace by fee, or the original tx
+	// for backends that have a child pay for it
+	BumpFee(txid string, satPerKw int64) (string, string, error)
+
+	// ChildTx : the txid of the wallet's tx spending an output of the anchor tx txid, such as a child paying for it, or empty
+	// if the wallet hasn't broadcast one
+	ChildTx(txid string) (string, error)
+
+	// SendCoins : pays amount satoshis to addr, returning the txid
+	SendCoins(addr string, amount int64) (string, error)
+
+	// TxFee : the fee in satoshis the wallet paid for its tx txid
+	TxFee(txid string) (int64, error)
+
+	// WalletBalance : the wallet's confirmed balance in satoshis
+	WalletBalance() (int64, error)
+
+	// EstimateFee : the fee rate in sat/kw for confirmation within confTarget blocks
+	EstimateFee(confTarget int64) (int64, error)
+
+	// BlockHeight : the height of the best block
+	BlockHeight() (int64, error)
+
+	// GetBlockByHeight : the block at height
+	GetBlockByHeight(height int64) (Block, error)
+
+	// TxBlockHeights : the heights of the blocks txids were mined in. Unmined txs are left out. Backends that can't look txs up
+	// directly search the blocks from fromHeight to toHeight
+	TxBlockHeights(txids []string, fromHeight int64, toHeight int64) (map[string]int64, error)
+}
+
+// TaprootAnchor : a taproot anchor tx, whose output is spent by the anchoring Core's internal key tweaked with Commitment
+type TaprootAnchor struct {
+	TxID       string `json:"txid"`
+	Commitment string `json:"commitment"` // the hex anchor root
+}
+
+// LndBackend : ChainBackend of the Core's lnd node. Only the anchoring Core's own txs can be found without scanning blocks
+type LndBackend struct {
+	LnClient *lightning.LightningClient
+}
+
+var _ ChainBackend = (*LndBackend)(nil)
+
+// lndStaticFee : the sat/kw rate lnd returns when its chain backend can't estimate fees
+const lndStaticFee = 12500
+
+// NewLndBackend : creates a ChainBackend for an lnd node
+func NewLndBackend(lnClient *lightning.LightningClient) *LndBackend {
+	return &LndBackend{LnClient: lnClient}
+}
+
+func (lnd *LndBackend) SendOpReturn(data []byte, satPerKw int64) (string, string, error) {
+	if satPerKw > 0 {
+		lnd.LnClient.LastFee = satPerKw
+	}
+	return lnd.LnClient.SendOpReturn(data)
+}
+
+// SendTaprootCommitment : lnd's wallet can't be made to spend an output of an arbitrary tweaked key
+func (lnd *LndBackend) SendTaprootCommitment(internalKey *btcec.PrivateKey, data []byte, previous []TaprootAnchor, satPerKw int64) (string, string, error) {
+	return "", "", errors.New("taproot anchor commitments require the bitcoind backend")
+}
+
+func (lnd *LndBackend) BumpTaprootCommitment(internalKey *btcec.PrivateKey, txid string, known []TaprootAnchor, satPerKw int64) (string, string, error) {
+	return "", "", errors.New("taproot anchor commitments require the bitcoind backend")
+}
+
+// BumpFee : lnd bumps unconfirmed txs by spending their change in a child paying for both, so the anchor tx keeps its txid
+func (lnd *LndBackend) BumpFee(txid string, satPerKw int64) (string, string, error) {
+	if _, err := lnd.LnClient.ReplaceByFee(txid, false, int(satPerKw*4/1000)); err != nil {
+		return "", "", err
+	}
+	return txid, "", nil
+}
+
+// ChildTx : lnd's sweeper broadcasts the child paying for a tx in its own time, so the wallet's txs are searched for it
+func (lnd *LndBackend) ChildTx(txid string) (string, error) {
+	client, closeFunc, err := lnd.LnClient.GetClient()
+	if err != nil {
+		return "", err
+	}
+	defer closeFunc()
+	txs, err := client.GetTransactions(context.Background(), &lnrpc.GetTransactionsRequest{EndHeight: -1})
+	if err != nil {
+		return "", err
+	}
+	for _, tx := range txs.Transactions {
+		if tx.TxHash == txid {
+			continue
+		}
+		rawTx, err := hex.DecodeString(tx.RawTxHex)
+		if err != nil {
+			continue
+		}
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+			continue
+		}
+		for _, txIn := range msgTx.TxIn {
+			if txIn.PreviousOutPoint.Hash.String() == txid {
+				return tx.TxHash, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (lnd *LndBackend) SendCoins(addr string, amount int64) (string, error) {
+	resp, err := lnd.LnClient.SendCoins(addr, amount, int32(lnd.LnClient.MinConfs))
+	return resp.Txid, err
+}
+
+func (lnd *LndBackend) TxFee(txid string) (int64, error) {
+	txBytes, err := hex.DecodeString(txid)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid txid %s", txid))
+	}
+	details, err := lnd.LnClient.GetTransaction(txBytes)
+	if err != nil {
+		return 0, err
+	}
+	if len(details.Transactions) == 0 {
+		return 0, errors.New(fmt.Sprintf("tx %s not found in the lnd wallet", txid))
+	}
+	return details.Transactions[0].TotalFees, nil
+}
+
+func (lnd *LndBackend) WalletBalance() (int64, error) {
+	balance, err := lnd.LnClient.GetWalletBalance()
+	if err != nil {
+		return 0, err
+	}
+	return balance.ConfirmedBalance, nil
+}
+
+// EstimateFee : asks lnd's wallet for an estimate, rejecting the static rate lnd falls back to when it has none
+func (lnd *LndBackend) EstimateFee(confTarget int64) (int64, error) {
+	wallet, closeFunc, err := lnd.LnClient.GetWalletClient()
+	if err != nil {
+		return 0, err
+	}
+	defer closeFunc()
+	fee, err := wallet.EstimateFee(context.Background(), &walletrpc.EstimateFeeRequest{ConfTarget: int32(confTarget)})
+	if err != nil {
+		return 0, err
+	}
+	if fee.SatPerKw == lndStaticFee {
+		return 0, errors.New("lnd returned its static fee")
+	}
+	return fee.SatPerKw, nil
+}
+
+func (lnd *LndBackend) BlockHeight() (int64, error) {
+	info, err := lnd.LnClient.GetInfo()
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.BlockHeight), nil
+}
+
+func (lnd *LndBackend) GetBlockByHeight(height int64) (Block, error) {
+	block, err := lnd.LnClient.GetBlockByHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{
+		Height:     height,
+		MerkleRoot: util.ReverseTxHex(hex.EncodeToString(block.MerkleRoot)),
+		TxIDs:      block.Transactions,
+	}, nil
+}
+
+// TxBlockHeights : looks txids up in the lnd wallet, then, since lnd can't retrieve other confirmed txs, searches block by block
+func (lnd *LndBackend) TxBlockHeights(txids []string, fromHeight int64, toHeight int64) (map[string]int64, error) {
+	heights := make(map[string]int64)
+	pending := make(map[string]bool)
+	for _, txid := range txids {
+		txBytes, err := hex.DecodeString(txid)
+		if err != nil {
+			return heights, errors.New(fmt.Sprintf("invalid txid %s", txid))
+		}
+		details, err := lnd.LnClient.GetTransaction(txBytes)
+		if err == nil && len(details.Transactions) > 0 && details.Transactions[0].BlockHeight > 0 {
+			heights[txid] = int64(details.Transactions[0].BlockHeight)
+			continue
+		}
+		pending[txid] = true
+	}
+	return heights, scanBlocks(lnd, pending, heights, fromHeight, toHeight)
+}
+
+// scanBlocks : searches the blocks from fromHeight to toHeight for the pending txids, recording the height of each one found
+func scanBlocks(backend ChainBackend, pending map[string]bool, heights map[string]int64, fromHeight int64, toHeight int64) error {
+	if fromHeight <= 0 {
+		return nil
+	}
+	for i := fromHeight; i <= toHeight && len(pending) > 0; i++ {
+		block, err := backend.GetBlockByHeight(i)
+		if err != nil {
+			return err
+		}
+		for _, txid := range block.TxIDs {
+			if pending[txid] {
+				heights[txid] = i
+				delete(pending, txid)
+			}
+		}
+	}
+	return nil
+}
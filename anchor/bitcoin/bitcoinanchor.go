@@ -2,55 +2,101 @@ package bitcoin
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	analytics2 "github.com/chainpoint/chainpoint-core/analytics"
 	"github.com/chainpoint/chainpoint-core/calendar"
 	"github.com/chainpoint/chainpoint-core/database"
 	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/events"
 	"github.com/chainpoint/chainpoint-core/leaderelection"
+	"github.com/chainpoint/chainpoint-core/ledger"
 	"github.com/chainpoint/chainpoint-core/proof"
 	"github.com/chainpoint/chainpoint-core/tendermintrpc"
 	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/chainpoint/chainpoint-core/util"
+	"github.com/chainpoint/chainpoint-core/webhook"
 	lightning "github.com/chainpoint/lightning-go"
 	merkletools "github.com/chainpoint/merkletools-go"
-	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/tendermint/tendermint/libs/log"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// AnchorName : names the engine in the anchor registry and keys its cursor in AnchorState.Anchors
+const AnchorName = "btc"
+
 const CONFIRMED_BTC_TX_IDS_KEY = "BTC_Mon:ConfirmedBTCTxIds"
 const CHECK_BTC_TX_IDS_KEY = "BTC_Mon:CheckNewBTCTxIds"
+const PENDING_BTC_TXS_KEY = "BTC_Mon:PendingBTCTxs"  // BTC-A and BTC-R msgs of this Core's unmined anchor txs, whose fees it bumps
+const TAPROOT_ANCHORS_KEY = "BTC_Mon:TaprootAnchors" // this Core's latest taproot anchor txs, whose outputs fund the next ones
+const CPFP_CHILDREN_KEY = "BTC_Mon:CPFPChildren"     // children paying for this Core's unmined anchor txs, whose fees are recorded once known
+
+// cpfpChild : the child paying for an anchor tx. Fee is the child's fee already recorded in the ledger, and Estimate what
+// bumps were charged to the spend policy before the wallet broadcast the child carrying them
+type cpfpChild struct {
+	TxID             string `json:"txid"`
+	ParentTxID       string `json:"parent_txid"`
+	AnchorBtcAggRoot string `json:"anchor_btc_agg_root"`
+	Fee              int64  `json:"fee"`
+	Estimate         int64  `json:"estimate"`
+}
+
+// maxTaprootAnchors : how many of this Core's latest taproot anchor txs are kept to fund and bump anchors with
+const maxTaprootAnchors = 10
+
+// maxReplacements : how many BTC-R replacements of an anchor tx are followed when constructing proofs
+const maxReplacements = 10
 
 type AnchorBTC struct {
 	state         *types.AnchorState
+	cursor        *types.AnchorCursor
 	config        types.AnchorConfig
 	tendermintRpc *tendermintrpc.RPC
 	Cache         *level.KVStore
 	Db            database.ChainpointDatabase
 	LnClient      *lightning.LightningClient
+	Chain         ChainBackend
+	Spend         *SpendPolicy
+	Ledger        *ledger.Ledger
+	taprootKey    *btcec.PrivateKey // set if anchor roots are committed to in taproot outputs rather than OP_RETURN outputs
 	logger        log.Logger
 	analytics     *analytics2.UniversalAnalytics
+	webhooks      *webhook.Notifier
+	events        *events.Broker
 }
 
 func NewBTCAnchorEngine(state *types.AnchorState, config types.AnchorConfig, tendermintRpc *tendermintrpc.RPC,
-	database *database.ChainpointDatabase, cache *level.KVStore, LnClient *lightning.LightningClient, logger log.Logger, analytics *analytics2.UniversalAnalytics) *AnchorBTC {
+	database *database.ChainpointDatabase, cache *level.KVStore, LnClient *lightning.LightningClient, chain ChainBackend, spend *SpendPolicy, txLedger *ledger.Ledger, logger log.Logger, analytics *analytics2.UniversalAnalytics, webhooks *webhook.Notifier, broker *events.Broker) *AnchorBTC {
+	var taprootKey *btcec.PrivateKey
+	if config.BtcCommitment == "taproot" {
+		taprootKey = TaprootInternalKey(config.ECPrivateKey)
+	}
 	return &AnchorBTC{
 		state:         state,
+		cursor:        state.Cursor(AnchorName),
 		config:        config,
 		tendermintRpc: tendermintRpc,
 		Cache:         cache,
 		Db:            *database,
 		LnClient:      LnClient,
+		Chain:         chain,
+		Spend:         spend,
+		Ledger:        txLedger,
+		taprootKey:    taprootKey,
 		logger:        logger,
 		analytics:     analytics,
+		webhooks:      webhooks,
+		events:        broker,
 	}
 }
 
@@ -82,7 +128,7 @@ func (app *AnchorBTC) AnchorToChain(startTxRange int64, endTxRange int64) error
 	if err != nil {
 		return err
 	}
-	app.logger.Info(fmt.Sprintf("StartAnchoring tx ranges %d to %d at Height %d, latestBtcaHeight %d, for aggroot: %s", startTxRange, endTxRange, app.state.Height, app.state.LatestBtcaHeight, treeData.AnchorBtcAggRoot))
+	app.logger.Info(fmt.Sprintf("StartAnchoring tx ranges %d to %d at Height %d, latestBtcaHeight %d, for aggroot: %s", startTxRange, endTxRange, app.state.Height, app.cursor.LatestAnchorHeight, treeData.AnchorBtcAggRoot))
 	app.logger.Info(fmt.Sprintf("treeData for StartAnchoring: %#v", treeData))
 
 	// If we have something to anchor, perform anchoring and proofgen functions
@@ -96,17 +142,16 @@ func (app *AnchorBTC) AnchorToChain(startTxRange int64, endTxRange int64) error
 		if iAmLeader {
 			btcTx, btca, err := app.SendBtcTx(treeData, app.state.Height, startTxRange, endTxRange)
 			if app.LogError(err) != nil {
+				// hand the anchor to another Core, including when the spend policy refused it
 				_, err := app.tendermintRpc.BroadcastTx("BTC-E", treeData.AnchorBtcAggRoot, 2, time.Now().Unix(), app.state.ID, app.config.ECPrivateKey)
 				if app.LogError(err) != nil {
 					panic(err)
 				}
-			}
-			_, err = app.tendermintRpc.BroadcastTx("BTC-A", string(btca), 2, time.Now().Unix(), app.state.ID, app.config.ECPrivateKey)
-			if app.LogError(err) != nil {
+			} else if _, err = app.tendermintRpc.BroadcastTx("BTC-A", string(btca), 2, time.Now().Unix(), app.state.ID, app.config.ECPrivateKey); app.LogError(err) != nil {
 				app.logger.Info(fmt.Sprintf("failed sending BTC-A"))
 				panic(err)
 			} else {
-				go app.analytics.SendEvent(app.state.LatestTimeRecord, "CreateAnchorTx", btcTx, time.Now().Format(time.RFC3339), "", strconv.FormatInt(app.state.LatestBtcFee*4/1000, 10), "")
+				go app.analytics.SendEvent(app.state.LatestTimeRecord, "CreateAnchorTx", btcTx, time.Now().Format(time.RFC3339), "", strconv.FormatInt(app.Spend.Rate(app.state.LatestBtcFee)*4/1000, 10), "")
 			}
 		}
 
@@ -114,7 +159,7 @@ func (app *AnchorBTC) AnchorToChain(startTxRange int64, endTxRange int64) error
 		failedAnchorCheck := types.AnchorRange{
 			AnchorBtcAggRoot: treeData.AnchorBtcAggRoot,
 			CalBlockHeight:   app.state.Height,
-			BtcBlockHeight:   int64(app.state.LNState.BlockHeight),
+			BtcBlockHeight:   app.state.BtcHeight,
 			BeginCalTxInt:    startTxRange,
 			EndCalTxInt:      endTxRange,
 			AmLeader:         iAmLeader,
@@ -124,24 +169,46 @@ func (app *AnchorBTC) AnchorToChain(startTxRange int64, endTxRange int64) error
 		if app.LogError(err) != nil {
 			return err
 		}
-		app.state.BeginCalTxInt = endTxRange
-		app.state.EndCalTxInt = endTxRange            // Ensure we update our range of CAL txs for next anchor period
-		app.state.LatestBtcaHeight = app.state.Height // So no one will try to re-anchor while processing the btc tx
+		app.cursor.BeginCalTxInt = endTxRange
+		app.cursor.EndCalTxInt = endTxRange              // Ensure we update our range of CAL txs for next anchor period
+		app.cursor.LatestAnchorHeight = app.state.Height // So no one will try to re-anchor while processing the btc tx
 		return nil
 	}
 	return errors.New("no transactions to aggregate")
 }
 
-// SendBtcTx : sends btc tx to lnd and enqueues tx monitoring information
+// SendBtcTx : sends btc tx through the chain backend and enqueues tx monitoring information
 func (app *AnchorBTC) SendBtcTx(anchorDataObj types.BtcAgg, height int64, start int64, end int64) (string, []byte, error) {
 	hexRoot, err := hex.DecodeString(anchorDataObj.AnchorBtcAggRoot)
 	if util.LogError(err) != nil {
 		return "", []byte{}, err
 	}
-	txid, rawtx, err := app.LnClient.SendOpReturn(hexRoot)
+	feeRate, err := app.Spend.AnchorRate(app.Spend.Rate(app.state.LatestBtcFee))
+	if app.LogError(err) != nil {
+		return "", []byte{}, err
+	}
+	var txid, rawtx, internalKey string
+	var fee int64
+	err = app.Spend.Spend("anchor", AnchorFee(feeRate), func() (string, int64, error) {
+		if app.taprootKey != nil {
+			txid, rawtx, err = app.Chain.SendTaprootCommitment(app.taprootKey, hexRoot, app.taprootAnchors(), feeRate)
+			internalKey = hex.EncodeToString(schnorr.SerializePubKey(app.taprootKey.PubKey()))
+			if err == nil {
+				app.addTaprootAnchor(txid, anchorDataObj.AnchorBtcAggRoot)
+			}
+		} else {
+			txid, rawtx, err = app.Chain.SendOpReturn(hexRoot, feeRate)
+		}
+		if err != nil {
+			return txid, 0, err
+		}
+		fee = app.txFee(txid, AnchorFee(feeRate))
+		return txid, fee, nil
+	})
 	if util.LogError(err) != nil {
 		return "", []byte{}, err
 	}
+	app.LogError(app.Ledger.Record(ledger.Entry{Kind: ledger.AnchorFee, Fee: fee, TxID: txid, Detail: anchorDataObj.AnchorBtcAggRoot}))
 	msgBtcMon := types.BtcTxMsg{
 		AnchorBtcAggID:   anchorDataObj.AnchorBtcAggID,
 		AnchorBtcAggRoot: anchorDataObj.AnchorBtcAggRoot,
@@ -151,9 +218,13 @@ func (app *AnchorBTC) SendBtcTx(anchorDataObj types.BtcAgg, height int64, start
 		CalBlockHeight:   height,
 		BeginCalTxInt:    start,
 		EndCalTxInt:      end,
+
+		TaprootInternalKey: internalKey,
+		CoreID:             app.state.ID,
+		FeeRate:            feeRate,
 	}
 	btcJSON, err := json.Marshal(msgBtcMon)
-	app.logger.Info(fmt.Sprint("Sending BTC-A OP_RETURN: %#v", msgBtcMon))
+	app.logger.Info(fmt.Sprintf("Sending BTC-A: %#v", msgBtcMon))
 	return txid, btcJSON, err
 }
 
@@ -171,11 +242,23 @@ func (app *AnchorBTC) AnchorReward(CoreID string) error {
 		if len(status.LightningAddress) == 0 {
 			return errors.New("Reward not sent; Can't obtain status for peer")
 		}
-		resp, err := app.LnClient.SendCoins(status.LightningAddress, int64(app.config.AnchorReward), int32(app.LnClient.MinConfs))
+		var txid string
+		var fee int64
+		err := app.Spend.Spend("reward", int64(app.config.AnchorReward), func() (string, int64, error) {
+			var err error
+			txid, err = app.Chain.SendCoins(status.LightningAddress, int64(app.config.AnchorReward))
+			if err != nil {
+				return txid, 0, err
+			}
+			fee = app.txFee(txid, 0)
+			return txid, int64(app.config.AnchorReward) + fee, nil
+		})
 		if app.LogError(err) != nil {
 			return err
 		}
-		app.logger.Info(fmt.Sprintf("Reward Sent to %s with txid %s", CoreID, resp.Txid))
+		app.LogError(app.Ledger.Record(ledger.Entry{Kind: ledger.AnchorReward, Amount: -int64(app.config.AnchorReward), Fee: fee, TxID: txid, Detail: CoreID}))
+		app.logger.Info(fmt.Sprintf("Reward Sent to %s with txid %s", CoreID, txid))
+		return nil
 	}
 	app.logger.Info(fmt.Sprintf("Reward of %d not sent to CoreID %s", app.config.AnchorReward, CoreID))
 	return errors.New(fmt.Sprintf("Reward not sent; LnURI of CoreID %s not found in local database", CoreID))
@@ -187,11 +270,14 @@ func (app *AnchorBTC) BeginTxMonitor(msgBytes []byte) error {
 	if err := json.Unmarshal(msgBytes, &btcTxObj); err != nil {
 		return app.LogError(err)
 	}
-	app.state.LatestBtcTx = btcTxObj.BtcTxID // Update app state with txID so we can broadcast BTC-A
-	app.state.LatestBtcAggRoot = btcTxObj.AnchorBtcAggRoot
-	stateObj := calendar.GenerateAnchorBtcTxState(btcTxObj)
+	app.cursor.LatestAnchorTx = btcTxObj.BtcTxID // Update app state with txID so we can broadcast BTC-A
+	app.cursor.LatestAnchorRoot = btcTxObj.AnchorBtcAggRoot
+	stateObj, err := calendar.GenerateAnchorBtcTxState(btcTxObj)
+	if app.LogError(err) != nil {
+		return err
+	}
 	app.logger.Info(fmt.Sprintf("BTC-A BtcTx State Obj: %#v", stateObj))
-	err := app.Db.BulkInsertBtcTxState([]types.AnchorBtcTxState{stateObj})
+	err = app.Db.BulkInsertBtcTxState([]types.AnchorBtcTxState{stateObj})
 	if app.LogError(err) != nil {
 		return err
 	}
@@ -203,6 +289,9 @@ func (app *AnchorBTC) BeginTxMonitor(msgBytes []byte) error {
 	}
 	// end monitoring for failed anchor
 	app.FindAndRemoveBtcCheck(btcTxObj.AnchorBtcAggRoot)
+	if btcTxObj.CoreID == app.state.ID {
+		app.LogError(app.Cache.Append(PENDING_BTC_TXS_KEY, string(msgBytes)))
+	}
 
 	btcAgg, err := app.GetTreeFromCalRange(btcTxObj.BeginCalTxInt, btcTxObj.EndCalTxInt)
 	if app.LogError(err) != nil {
@@ -225,11 +314,55 @@ func (app *AnchorBTC) BeginTxMonitor(msgBytes []byte) error {
 	return nil
 }
 
+// ReplaceTxMonitor : consumes a BTC-R message, moving proof generation and monitoring from the replaced tx to its replacement
+func (app *AnchorBTC) ReplaceTxMonitor(msgBytes []byte) error {
+	var replacement types.BtcTxMsg
+	if err := json.Unmarshal(msgBytes, &replacement); err != nil {
+		return app.LogError(err)
+	}
+	if replacement.ReplacesBtcTxID == "" {
+		return app.LogError(errors.New("BTC-R does not name the tx it replaces"))
+	}
+	monitored, tx := app.IsInConfirmedTxs(replacement.AnchorBtcAggRoot)
+	if monitored && tx.TxID == replacement.ReplacesBtcTxID && tx.BlockHeight != 0 {
+		app.logger.Info(fmt.Sprintf("BTC-R %s arrived after %s was mined", replacement.BtcTxID, tx.TxID))
+		return nil
+	}
+	if app.cursor.LatestAnchorTx == replacement.ReplacesBtcTxID {
+		app.cursor.LatestAnchorTx = replacement.BtcTxID
+	}
+	// the replacement's tx state supersedes the replaced tx's for the same aggregation
+	stateObj, err := calendar.GenerateAnchorBtcTxState(replacement)
+	if app.LogError(err) != nil {
+		return err
+	}
+	if err := app.LogError(app.Db.BulkInsertBtcTxState([]types.AnchorBtcTxState{stateObj})); err != nil {
+		return err
+	}
+	if monitored && tx.TxID == replacement.ReplacesBtcTxID {
+		oldTxIDBytes, _ := json.Marshal(tx)
+		app.LogError(app.Cache.Del(CONFIRMED_BTC_TX_IDS_KEY, string(oldTxIDBytes)))
+		tx.TxID = replacement.BtcTxID
+		txIDBytes, _ := json.Marshal(tx)
+		if err := app.LogError(app.Cache.Append(CONFIRMED_BTC_TX_IDS_KEY, string(txIDBytes))); err != nil {
+			return err
+		}
+	}
+	if replacement.CoreID == app.state.ID {
+		app.LogError(app.Cache.Append(PENDING_BTC_TXS_KEY, string(msgBytes)))
+	}
+	app.logger.Info(fmt.Sprintf("BTC-R %s replaced %s", replacement.BtcTxID, replacement.ReplacesBtcTxID))
+	return nil
+}
+
 // ConfirmAnchor : consumes a btc mon message and issues a BTC-Confirm transaction along with completing btc proof generation
 func (app *AnchorBTC) ConfirmAnchor(btcMonObj types.BtcMonMsg) error {
 	app.logger.Info(fmt.Sprintf("Creating BTC-C for %s", btcMonObj.BtcTxID))
 	var hash []byte
 	anchoringCoreID, err := app.tendermintRpc.GetAnchoringCore(fmt.Sprintf("BTC-A.BTCTX='%s'", btcMonObj.BtcTxID))
+	if len(anchoringCoreID) == 0 {
+		anchoringCoreID, err = app.tendermintRpc.GetAnchoringCore(fmt.Sprintf("BTC-R.BTCTX='%s'", btcMonObj.BtcTxID))
+	}
 	if len(anchoringCoreID) == 0 {
 		app.logger.Error(fmt.Sprintf("StartAnchoring confirmation: Cannot retrieve BTCTX-tagged transaction for btc tx: %s", btcMonObj.BtcTxID))
 	} else {
@@ -242,7 +375,7 @@ func (app *AnchorBTC) ConfirmAnchor(btcMonObj types.BtcMonMsg) error {
 	deadline := time.Now().Add(time.Duration(5) * time.Minute)
 	for !time.Now().After(deadline) {
 		//only start BTC-C leader election process if someone else hasn't
-		if btcMonObj.BtcHeadRoot != string(app.state.LatestBtccTx) {
+		if btcMonObj.BtcHeadRoot != app.cursor.LatestConfirmed {
 			// Broadcast the confirmation message with metadata
 			amLeader, _ := leaderelection.ElectValidatorAsLeader(1, []string{anchoringCoreID}, *app.state, app.config)
 			if amLeader {
@@ -254,7 +387,7 @@ func (app *AnchorBTC) ConfirmAnchor(btcMonObj types.BtcMonMsg) error {
 				})
 				result, err := app.tendermintRpc.BroadcastTxWithMeta("BTC-C", string(btcc), 3, time.Now().Unix(), app.state.ID, anchoringCoreID, app.config.ECPrivateKey)
 				app.LogError(err)
-				app.logger.Info(fmt.Sprint("BTC-C confirmation Hash: %v", result.Hash))
+				app.logger.Info(fmt.Sprintf("BTC-C confirmation Hash: %v", result.Hash))
 			}
 		}
 		time.Sleep(70 * time.Second) // wait until next block to query for btc-c
@@ -270,6 +403,14 @@ func (app *AnchorBTC) ConfirmAnchor(btcMonObj types.BtcMonMsg) error {
 	app.LogError(err)
 	app.logger.Info(fmt.Sprintf("BtcHeadState: %#v", headStateObj))
 	app.LogError(app.GenerateBtcBatch(proofIds, headStateObj))
+	if len(proofIds) > 0 {
+		app.events.Publish(events.Event{
+			Type:          events.BtcC,
+			ProofIDs:      proofIds,
+			BtcTxID:       btcMonObj.BtcTxID,
+			BtcHeadHeight: btcMonObj.BtcHeadHeight,
+		})
+	}
 	go app.analytics.SendEvent(app.state.LatestTimeRecord, "CreateConfirmTx", btcMonObj.BtcTxID, time.Now().Format(time.RFC3339), "", "", "")
 	return nil
 }
@@ -279,6 +420,17 @@ func (app *AnchorBTC) ConstructProof(txid string) (proof.P, error) {
 	if app.LogError(err) != nil {
 		return proof.Proof(), err
 	}
+	// the tx that was mined may be a replacement of the one BTC-A announced
+	for i := 0; i < maxReplacements; i++ {
+		if _, confirmedHeight := app.tendermintRpc.GetAnchorHeight(btca); confirmedHeight != 0 {
+			break
+		}
+		replacement, replaced := app.tendermintRpc.GetBtcReplacement(btca.BtcTxID)
+		if !replaced {
+			break
+		}
+		btca = replacement
+	}
 	btcAgg, err := app.GetTreeFromCalRange(btca.BeginCalTxInt, btca.EndCalTxInt)
 	if app.LogError(err) != nil {
 		return proof.Proof(), err
@@ -287,7 +439,10 @@ func (app *AnchorBTC) ConstructProof(txid string) (proof.P, error) {
 		app.logger.Info(fmt.Sprintf("ConstructProof TreeData calculation failure for BTC-A aggroot: %s, local treeData result was %s", btca.AnchorBtcAggRoot, btcAgg.AnchorBtcAggRoot))
 		return proof.Proof(), errors.New("StartAnchoring failure, AggRoot mismatch")
 	}
-	anchorBtcTxState := calendar.GenerateAnchorBtcTxState(btca)
+	anchorBtcTxState, err := calendar.GenerateAnchorBtcTxState(btca)
+	if app.LogError(err) != nil {
+		return proof.Proof(), err
+	}
 	anchorBTCAggStateObjects := calendar.PrepareBtcaStateData(btcAgg)
 	/*	err = app.Db.BulkInsertBtcAggState(anchorBTCAggStateObjects)
 		if app.LogError(err) != nil {
@@ -298,25 +453,19 @@ func (app *AnchorBTC) ConstructProof(txid string) (proof.P, error) {
 	if blockHeight == 0 {
 		//TODO: sad path: contact anchoringCore for tx/height, reconstruct blocktree
 		//TODO: or we're querying too soon
-		txBytes, err := hex.DecodeString(btca.BtcTxID)
+		txHeights, err := app.Chain.TxBlockHeights([]string{btca.BtcTxID}, 0, 0)
 		if app.LogError(err) != nil {
 			return proof.Proof(), err
 		}
-		txDetails, err := app.LnClient.GetTransaction(txBytes)
-		if app.LogError(err) != nil {
-			return proof.Proof(), err
-		}
-		if len(txDetails.Transactions) > 0 {
-			blockHeight = int64(txDetails.Transactions[0].BlockHeight)
-		}
-		blockDetails, err := app.LnClient.GetBlockByHeight(blockHeight)
+		blockHeight = txHeights[btca.BtcTxID]
+		block, err := app.Chain.GetBlockByHeight(blockHeight)
 		if app.LogError(err) != nil {
 			return proof.Proof(), err
 		}
 		btccHash = app.tendermintRpc.GetBTCCForBtcRoot(types.BtcMonMsg{
 			BtcTxID:       "",
 			BtcHeadHeight: 0,
-			BtcHeadRoot:   util.ReverseTxHex(hex.EncodeToString(blockDetails.MerkleRoot)),
+			BtcHeadRoot:   block.MerkleRoot,
 			Path:          nil,
 		})
 		if len(btccHash) == 0 {
@@ -395,6 +544,7 @@ func (app *AnchorBTC) GenerateBtcBatch(proofIds []string, btcHeadState types.Anc
 	for _, aggStateRow := range aggStates {
 		proof := proof.Proof()
 		app.LogError(proof.AddChainpointHeader("https://w3id.org/chainpoint/v5", "Chainpoint", aggStateRow.Hash, aggStateRow.ProofID))
+		proof.AddHashAlgorithm(aggStateRow.HashAlgorithm)
 
 		app.LogError(proof.AddCalendarBranch(aggStateRow, calLookUp[aggStateRow.AggID].CalState, proof.SetProofType(app.config.BitcoinNetwork, "cal")))
 
@@ -420,7 +570,11 @@ func (app *AnchorBTC) GenerateBtcBatch(proofIds []string, btcHeadState types.Anc
 		proofs = append(proofs, proofState)
 	}
 	app.logger.Info(fmt.Sprintf("btc proofs: %#v", proofs))
-	return app.LogError(app.Db.BulkInsertProofs(proofs))
+	if err := app.LogError(app.Db.BulkInsertProofs(proofs)); err != nil {
+		return err
+	}
+	app.webhooks.Enqueue(proofs, webhook.StageBtc)
+	return nil
 }
 
 func (app *AnchorBTC) LogError(err error) error {
@@ -457,11 +611,8 @@ func (app *AnchorBTC) CheckAnchor(btcmsg types.BtcTxMsg) error {
 	btcBodyBytes, _ := hex.DecodeString(btcmsg.BtcTxBody)
 	var msgTx wire.MsgTx
 	msgTx.DeserializeNoWitness(bytes.NewReader(btcBodyBytes))
-	b := txscript.NewScriptBuilder()
-	b.AddOp(txscript.OP_RETURN)
 	rootBytes, _ := hex.DecodeString(btcmsg.AnchorBtcAggRoot)
-	b.AddData(rootBytes)
-	outputScript, err := b.Script()
+	outputScript, err := anchorScript(btcmsg.TaprootInternalKey, rootBytes)
 	if app.LogError(err) != nil {
 		return err
 	}
@@ -475,6 +626,30 @@ func (app *AnchorBTC) CheckAnchor(btcmsg types.BtcTxMsg) error {
 	return errors.New("unable to verify BTC-A")
 }
 
+// anchorScript : the output script a BTC-A tx commits to root with, either OP_RETURN or a taproot output of internalKey
+func anchorScript(internalKey string, root []byte) ([]byte, error) {
+	if internalKey == "" {
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(root).Script()
+	}
+	keyBytes, err := hex.DecodeString(internalKey)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := schnorr.ParsePubKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return taprootScript(pubKey, root)
+}
+
+// TaprootInternalKey : the secp256k1 key a Core's taproot anchor outputs are tweaked from, derived from its ECDSA identity key
+// so that it can be recovered along with the rest of the Core's identity
+func TaprootInternalKey(ecKey *ecdsa.PrivateKey) *btcec.PrivateKey {
+	seed := sha256.Sum256(append([]byte("chainpoint taproot anchor"), ecKey.D.Bytes()...))
+	key, _ := btcec.PrivKeyFromBytes(seed[:])
+	return key
+}
+
 //BlockSyncMonitor : maintains unlock of wallet while abci is running, updates height, runs confirmation loop
 func (app *AnchorBTC) BlockSyncMonitor() {
 	app.logger.Info("Starting LND Monitor...")
@@ -482,32 +657,35 @@ func (app *AnchorBTC) BlockSyncMonitor() {
 	state, err := app.LnClient.GetInfo()
 	if app.LogError(err) == nil {
 		app.state.LNState = *state
-		app.logger.Info(fmt.Sprintf("LND state retrieved currHeight: %d vs newHeight: %d", app.state.BtcHeight, app.state.LNState.BlockHeight))
-		if app.state.BtcHeight != int64(app.state.LNState.BlockHeight) {
-			app.logger.Info("New Blocks detected from LND")
-			currBlockHeightInt64 := int64(app.state.LNState.BlockHeight)
-			isSynced := currBlockHeightInt64-app.state.BtcHeight < 36 // core should have a gap of less than 6 hours
-			if currBlockHeightInt64 != 0 && isSynced {
-				app.logger.Info("Monitoring Blocks from LND for Txs")
-				err = app.MonitorBlocksForConfirmation(app.state.BtcHeight, currBlockHeightInt64)
-				if app.LogError(err) != nil {
-					return
-				}
+	}
+	app.logger.Info("Finished LND Monitor")
+	currBlockHeightInt64, err := app.Chain.BlockHeight()
+	if app.LogError(err) != nil {
+		return
+	}
+	app.logger.Info(fmt.Sprintf("BTC height retrieved currHeight: %d vs newHeight: %d", app.state.BtcHeight, currBlockHeightInt64))
+	if app.state.BtcHeight != currBlockHeightInt64 {
+		app.logger.Info("New Blocks detected from chain backend")
+		isSynced := currBlockHeightInt64-app.state.BtcHeight < 36 // core should have a gap of less than 6 hours
+		if currBlockHeightInt64 != 0 && isSynced {
+			app.logger.Info("Monitoring Blocks for Txs")
+			err = app.MonitorBlocksForConfirmation(app.state.BtcHeight, currBlockHeightInt64)
+			if app.LogError(err) != nil {
+				return
 			}
-			app.state.BtcHeight = int64(app.state.LNState.BlockHeight)
-			app.logger.Info(fmt.Sprintf("New BTC Block %d", app.state.BtcHeight))
 		}
+		app.state.BtcHeight = currBlockHeightInt64
+		app.logger.Info(fmt.Sprintf("New BTC Block %d", app.state.BtcHeight))
 	}
-	app.logger.Info("Finished LND Monitor")
 }
 
 //FailedAnchorMonitor: ensures transactions reach btc chain within certain time limit
 func (app *AnchorBTC) MonitorFailedAnchor() {
-	if app.state.LNState.BlockHeight == 0 {
+	if app.state.BtcHeight == 0 {
 		app.logger.Info("BTC Height record is 0, waiting for update from btc chain...")
 		return
 	}
-	btcHeight := int64(app.state.LNState.BlockHeight)
+	btcHeight := app.state.BtcHeight
 	checkResults, err := app.Cache.GetArray(CHECK_BTC_TX_IDS_KEY)
 	if app.LogError(err) != nil {
 		return
@@ -537,7 +715,7 @@ func (app *AnchorBTC) MonitorFailedAnchor() {
 			// this usually means there's something seriously wrong with LND
 			app.logger.Info("StartAnchoring Timeout while waiting for mempool", "AnchorBtcAggRoot", anchor.AnchorBtcAggRoot)
 			// if there are subsequent anchors, we try to re-anchor just that range, else reset for a new anchor period
-			if app.state.BeginCalTxInt >= anchor.EndCalTxInt {
+			if app.cursor.BeginCalTxInt >= anchor.EndCalTxInt {
 				go app.AnchorToChain(anchor.BeginCalTxInt, anchor.EndCalTxInt)
 			} else {
 				app.ResetAnchor(anchor.BeginCalTxInt)
@@ -548,6 +726,183 @@ func (app *AnchorBTC) MonitorFailedAnchor() {
 			app.Cache.Del(CHECK_BTC_TX_IDS_KEY, s)
 		}
 	}
+	app.MonitorStuckAnchors()
+}
+
+// MonitorStuckAnchors : bumps the fee of this Core's unmined anchor txs once the Calendar's fee rate has moved past the rate
+// they pay, rather than leaving them to time out. Replacements are announced with BTC-R so that every Core monitors them instead
+func (app *AnchorBTC) MonitorStuckAnchors() {
+	app.MonitorChildFees()
+	results, err := app.Cache.GetArray(PENDING_BTC_TXS_KEY)
+	if app.LogError(err) != nil {
+		return
+	}
+	for _, s := range results {
+		var pending types.BtcTxMsg
+		if app.LogError(json.Unmarshal([]byte(s), &pending)) != nil {
+			app.Cache.Del(PENDING_BTC_TXS_KEY, s)
+			continue
+		}
+		// stop once the tx has been mined, replaced, or is no longer monitored
+		if monitored, tx := app.IsInConfirmedTxs(pending.AnchorBtcAggRoot); !monitored || tx.TxID != pending.BtcTxID || tx.BlockHeight != 0 {
+			app.Cache.Del(PENDING_BTC_TXS_KEY, s)
+			continue
+		}
+		rate := app.Spend.Rate(app.state.LatestBtcFee)
+		if rate <= pending.FeeRate {
+			continue
+		}
+		feeRate, err := app.Spend.AnchorRate(rate)
+		if app.LogError(err) != nil || feeRate <= pending.FeeRate {
+			continue
+		}
+		app.logger.Info(fmt.Sprintf("Bumping anchor tx %s from %d to %d sat/kw", pending.BtcTxID, pending.FeeRate, feeRate))
+		var txid, rawTx string
+		var added int64
+		err = app.Spend.Spend("bump", AnchorFee(feeRate)-AnchorFee(pending.FeeRate), func() (string, int64, error) {
+			if pending.TaprootInternalKey != "" && app.taprootKey != nil {
+				txid, rawTx, err = app.Chain.BumpTaprootCommitment(app.taprootKey, pending.BtcTxID, app.taprootAnchors(), feeRate)
+				if err == nil {
+					app.addTaprootAnchor(txid, pending.AnchorBtcAggRoot)
+				}
+			} else {
+				txid, rawTx, err = app.Chain.BumpFee(pending.BtcTxID, feeRate)
+			}
+			if err != nil {
+				return txid, 0, err
+			}
+			added = AnchorFee(feeRate) - AnchorFee(pending.FeeRate)
+			if txid != pending.BtcTxID {
+				added = app.txFee(txid, AnchorFee(feeRate)) - app.txFee(pending.BtcTxID, AnchorFee(pending.FeeRate))
+			}
+			return txid, added, nil
+		})
+		if app.LogError(err) != nil {
+			continue
+		}
+		app.Cache.Del(PENDING_BTC_TXS_KEY, s)
+		bumped := pending
+		bumped.FeeRate = feeRate
+		if txid == pending.BtcTxID {
+			// a child tx pays for the anchor tx, which is unchanged. Its fee is recorded once the wallet has broadcast it
+			app.LogError(app.addChildEstimate(pending, added))
+			bumpedJSON, _ := json.Marshal(bumped)
+			app.LogError(app.Cache.Append(PENDING_BTC_TXS_KEY, string(bumpedJSON)))
+			continue
+		}
+		app.LogError(app.Ledger.Record(ledger.Entry{Kind: ledger.FeeBump, Fee: added, TxID: txid, Detail: pending.BtcTxID}))
+		bumped.BtcTxID = txid
+		bumped.BtcTxBody = rawTx
+		bumped.ReplacesBtcTxID = pending.BtcTxID
+		bumpedJSON, _ := json.Marshal(bumped)
+		_, err = app.tendermintRpc.BroadcastTx("BTC-R", string(bumpedJSON), 2, time.Now().Unix(), app.state.ID, app.config.ECPrivateKey)
+		if app.LogError(err) != nil {
+			continue
+		}
+		go app.analytics.SendEvent(app.state.LatestTimeRecord, "ReplaceAnchorTx", txid, time.Now().Format(time.RFC3339), "", strconv.FormatInt(bumped.FeeRate*4/1000, 10), "")
+	}
+}
+
+// MonitorChildFees : records the fees of the children paying for this Core's anchor txs once the wallet has broadcast them,
+// correcting what the spend policy was charged for them. A bump replaces the previous child, so only the fee it adds to it
+// is recorded. Children never seen before their anchor tx leaves the pending txs are recorded at their estimate
+func (app *AnchorBTC) MonitorChildFees() {
+	results, err := app.Cache.GetArray(CPFP_CHILDREN_KEY)
+	if app.LogError(err) != nil {
+		return
+	}
+	for _, s := range results {
+		var child cpfpChild
+		if app.LogError(json.Unmarshal([]byte(s), &child)) != nil {
+			app.Cache.Del(CPFP_CHILDREN_KEY, s)
+			continue
+		}
+		updated := child
+		if txid, err := app.Chain.ChildTx(child.ParentTxID); app.LogError(err) == nil && txid != "" && txid != child.TxID {
+			if fee, err := app.Chain.TxFee(txid); app.LogError(err) == nil {
+				added := fee - child.Fee
+				app.LogError(app.Ledger.Record(ledger.Entry{Kind: ledger.FeeBump, Fee: added, TxID: txid, Detail: child.ParentTxID}))
+				app.LogError(app.Spend.Correct("bump", txid, added-child.Estimate))
+				updated = cpfpChild{TxID: txid, ParentTxID: child.ParentTxID, AnchorBtcAggRoot: child.AnchorBtcAggRoot, Fee: fee}
+			}
+		}
+		if monitored, tx := app.IsInConfirmedTxs(child.AnchorBtcAggRoot); !monitored || tx.TxID != child.ParentTxID || tx.BlockHeight != 0 {
+			if updated.Estimate > 0 {
+				app.LogError(app.Ledger.Record(ledger.Entry{Kind: ledger.FeeBump, Fee: updated.Estimate, TxID: child.ParentTxID, Detail: child.ParentTxID}))
+			}
+			app.Cache.Del(CPFP_CHILDREN_KEY, s)
+			continue
+		}
+		if updated != child {
+			updatedJSON, _ := json.Marshal(updated)
+			app.Cache.Del(CPFP_CHILDREN_KEY, s)
+			app.LogError(app.Cache.Append(CPFP_CHILDREN_KEY, string(updatedJSON)))
+		}
+	}
+}
+
+// addChildEstimate : charges the estimated fee of a bump to the child paying for the anchor tx of pending
+func (app *AnchorBTC) addChildEstimate(pending types.BtcTxMsg, estimate int64) error {
+	results, err := app.Cache.GetArray(CPFP_CHILDREN_KEY)
+	if err != nil {
+		return err
+	}
+	child := cpfpChild{ParentTxID: pending.BtcTxID, AnchorBtcAggRoot: pending.AnchorBtcAggRoot}
+	for _, s := range results {
+		var existing cpfpChild
+		if json.Unmarshal([]byte(s), &existing) == nil && existing.ParentTxID == pending.BtcTxID {
+			child = existing
+			if err := app.Cache.Del(CPFP_CHILDREN_KEY, s); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	child.Estimate += estimate
+	childJSON, err := json.Marshal(child)
+	if err != nil {
+		return err
+	}
+	return app.Cache.Append(CPFP_CHILDREN_KEY, string(childJSON))
+}
+
+// taprootAnchors : this Core's latest taproot anchor txs, oldest first
+func (app *AnchorBTC) taprootAnchors() []TaprootAnchor {
+	results, err := app.Cache.GetArray(TAPROOT_ANCHORS_KEY)
+	if app.LogError(err) != nil {
+		return nil
+	}
+	anchors := []TaprootAnchor{}
+	for _, s := range results {
+		var anchor TaprootAnchor
+		if app.LogError(json.Unmarshal([]byte(s), &anchor)) == nil {
+			anchors = append(anchors, anchor)
+		}
+	}
+	return anchors
+}
+
+// addTaprootAnchor : keeps txid, committing to the hex root, among the latest taproot anchor txs
+func (app *AnchorBTC) addTaprootAnchor(txid string, root string) {
+	results, err := app.Cache.GetArray(TAPROOT_ANCHORS_KEY)
+	if app.LogError(err) != nil {
+		return
+	}
+	anchorJSON, _ := json.Marshal(TaprootAnchor{TxID: txid, Commitment: root})
+	results = append(results, string(anchorJSON))
+	if len(results) > maxTaprootAnchors {
+		results = results[len(results)-maxTaprootAnchors:]
+	}
+	app.LogError(app.Cache.SetArray(TAPROOT_ANCHORS_KEY, results))
+}
+
+// txFee : the fee the wallet paid for txid, or estimate if the backend can't find it
+func (app *AnchorBTC) txFee(txid string, estimate int64) int64 {
+	fee, err := app.Chain.TxFee(txid)
+	if app.LogError(err) != nil {
+		return estimate
+	}
+	return fee
 }
 
 func (app *AnchorBTC) IsInConfirmedTxs(anchorRoot string) (bool, types.TxID) {
@@ -608,7 +963,7 @@ func (app *AnchorBTC) GenerateBtcHeaderProof(tx types.TxID) (types.BtcMonMsg, er
 	var btcmsg types.BtcMonMsg
 	btcmsg.BtcTxID = tx.TxID
 	btcmsg.BtcHeadHeight = tx.BlockHeight
-	btcmsg.BtcHeadRoot = util.ReverseTxHex(hex.EncodeToString(block.MerkleRoot))
+	btcmsg.BtcHeadRoot = block.MerkleRoot
 	proofs := tree.GetProof(txIndex)
 	jsproofs := make([]types.JSProof, len(proofs))
 	for i, proof := range proofs {
@@ -623,15 +978,24 @@ func (app *AnchorBTC) GenerateBtcHeaderProof(tx types.TxID) (types.BtcMonMsg, er
 }
 
 // GetBlockTree : constructs block merkel tree with transaction as index
-func (app *AnchorBTC) GetBlockTree(btcTx types.TxID) (lnrpc.BlockDetails, merkletools.MerkleTree, int, error) {
-	block, err := app.LnClient.GetBlockByHeight(btcTx.BlockHeight)
+func (app *AnchorBTC) GetBlockTree(btcTx types.TxID) (Block, merkletools.MerkleTree, int, error) {
+	block, err := app.Chain.GetBlockByHeight(btcTx.BlockHeight)
 	if app.LogError(err) != nil {
-		return lnrpc.BlockDetails{}, merkletools.MerkleTree{}, -1, err
+		return Block{}, merkletools.MerkleTree{}, -1, err
+	}
+	tree, txIndex, err := blockTree(block, btcTx.TxID)
+	if err != nil {
+		return block, merkletools.MerkleTree{}, -1, err
 	}
+	return block, tree, txIndex, nil
+}
+
+// blockTree : rebuilds the merkle tree of block and checks it against the block's merkle root
+func blockTree(block Block, txid string) (merkletools.MerkleTree, int, error) {
 	var tree merkletools.MerkleTree
 	txIndex := -1
-	for i, t := range block.Transactions {
-		if t == btcTx.TxID {
+	for i, t := range block.TxIDs {
+		if t == txid {
 			txIndex = i
 		}
 		tx := util.ReverseTxHex(t)
@@ -639,20 +1003,19 @@ func (app *AnchorBTC) GetBlockTree(btcTx types.TxID) (lnrpc.BlockDetails, merkle
 		tree.AddLeaf(hexTx)
 	}
 	if txIndex == -1 {
-		return lnrpc.BlockDetails{}, merkletools.MerkleTree{}, -1, errors.New(fmt.Sprintf("Transaction %s not found in block %d", btcTx.TxID, btcTx.BlockHeight))
+		return merkletools.MerkleTree{}, -1, errors.New(fmt.Sprintf("Transaction %s not found in block %d", txid, block.Height))
 	}
 	tree.MakeBTCTree()
 	root := tree.GetMerkleRoot()
 	reversedRoot := util.ReverseTxHex(hex.EncodeToString(root))
-	reversedRootBytes, _ := hex.DecodeString(reversedRoot)
-	reversedMerkleRoot, _ := hex.DecodeString(util.ReverseTxHex(hex.EncodeToString(block.MerkleRoot)))
-	if !bytes.Equal(reversedRootBytes, reversedMerkleRoot) {
-		return block, merkletools.MerkleTree{}, -1, errors.New(fmt.Sprintf("%s does not equal block merkle root %s", hex.EncodeToString(reversedRootBytes), hex.EncodeToString(block.MerkleRoot)))
+	if reversedRoot != block.MerkleRoot {
+		return merkletools.MerkleTree{}, -1, errors.New(fmt.Sprintf("%s does not equal block merkle root %s", reversedRoot, block.MerkleRoot))
 	}
-	return block, tree, txIndex, nil
+	return tree, txIndex, nil
 }
 
-// MonitorBlocksForConfirmation : since LND can't retrieve confirmed Txs, search block by block
+// MonitorBlocksForConfirmation : records the block height of unconfirmed anchor txs, searching from startHeight to endHeight
+// if the chain backend can't look them up directly
 func (app *AnchorBTC) MonitorBlocksForConfirmation(startHeight int64, endHeight int64) error {
 	confirmationTxs := make([]types.TxID, 0)
 	txsIdStrings := make([]string, 0)
@@ -673,29 +1036,28 @@ func (app *AnchorBTC) MonitorBlocksForConfirmation(startHeight int64, endHeight
 		txsIdStrings = append(txsIdStrings, tx.TxID)
 		txsStrings = append(txsStrings, s)
 	}
-	for i := startHeight; i < endHeight+1; i++ {
-		block, err := app.LnClient.GetBlockByHeight(i)
-		if app.LogError(err) != nil {
-			return err
-		}
-		for _, t := range block.Transactions {
-			if contains, index := util.ArrayContainsIndex(txsIdStrings, t); contains {
-				confirmationTx := txsStrings[index]
-				tx := confirmationTxs[index]
-				app.Cache.Del(CONFIRMED_BTC_TX_IDS_KEY, confirmationTx)
-				tx.BlockHeight = i
-				txIDBytes, _ := json.Marshal(tx)
-				app.Cache.Append(CONFIRMED_BTC_TX_IDS_KEY, string(txIDBytes))
-				app.logger.Info(fmt.Sprintf("Found tx %s in block %d", tx.TxID, i))
-			}
+	if len(txsIdStrings) == 0 {
+		return nil
+	}
+	// heights found before a lookup failure are still recorded
+	heights, err := app.Chain.TxBlockHeights(txsIdStrings, startHeight, endHeight)
+	for index, tx := range confirmationTxs {
+		height, found := heights[tx.TxID]
+		if !found {
+			continue
 		}
+		app.Cache.Del(CONFIRMED_BTC_TX_IDS_KEY, txsStrings[index])
+		tx.BlockHeight = height
+		txIDBytes, _ := json.Marshal(tx)
+		app.Cache.Append(CONFIRMED_BTC_TX_IDS_KEY, string(txIDBytes))
+		app.logger.Info(fmt.Sprintf("Found tx %s in block %d", tx.TxID, height))
 	}
-	return nil
+	return app.LogError(err)
 }
 
 // ResetAnchor ensures that anchoring will begin again in the next block
 func (app *AnchorBTC) ResetAnchor(startTxRange int64) {
 	app.logger.Info(fmt.Sprintf("StartAnchoring failure, restarting anchor epoch from tx %d", startTxRange))
-	app.state.BeginCalTxInt = startTxRange
-	app.state.LatestBtcaHeight = -1 //ensure election and anchoring reoccurs next block
+	app.cursor.BeginCalTxInt = startTxRange
+	app.cursor.LatestAnchorHeight = -1 //ensure election and anchoring reoccurs next block
 }
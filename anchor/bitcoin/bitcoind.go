@@ -0,0 +1,706 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/chainpoint/chainpoint-core/types"
+)
+
+// BitcoindBackend : ChainBackend of a bitcoind node's JSON-RPC interface and wallet. Txs are looked up directly with
+// gettransaction, getrawtransaction and gettxoutproof, so blocks are only scanned for txs none of them can find
+type BitcoindBackend struct {
+	url    string
+	user   string
+	pass   string
+	client *http.Client
+	nextID uint64
+}
+
+// taprootDust : the smallest taproot output bitcoind relays
+const taprootDust = 330
+
+var _ ChainBackend = (*BitcoindBackend)(nil)
+
+// RPCError : an error returned by bitcoind
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("bitcoind error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// NewBitcoindBackend : creates a ChainBackend for the bitcoind node and, if set, wallet in config
+func NewBitcoindBackend(config types.BitcoindConfig) *BitcoindBackend {
+	url := strings.TrimRight(config.RPCURL, "/")
+	if config.Wallet != "" {
+		url = url + "/wallet/" + config.Wallet
+	}
+	return &BitcoindBackend{
+		url:    url,
+		user:   config.RPCUser,
+		pass:   config.RPCPass,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call : invokes method with params, decoding its result into result unless result is nil
+func (b *BitcoindBackend) call(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcRequest{JSONRPC: "1.0", ID: atomic.AddUint64(&b.nextID, 1), Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.user != "" || b.pass != "" {
+		req.SetBasicAuth(b.user, b.pass)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// bitcoind reports RPC errors with a 404 or 500 status and a JSON body, but rejected credentials with an empty one
+	var rpcResp rpcResponse
+	if err := json.Unmarshal(respBody, &rpcResp); err != nil {
+		return errors.New(fmt.Sprintf("bitcoind %s returned status %d", method, resp.StatusCode))
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// btcAmount : formats satoshis as the BTC amount bitcoind expects, without float rounding in the encoded JSON
+func btcAmount(sats int64) json.Number {
+	return json.Number(strconv.FormatFloat(float64(sats)/1e8, 'f', 8, 64))
+}
+
+// fundOptions : fundrawtransaction options paying satPerKw, or bitcoind's own estimate if it's 0. Anchor txs signal
+// replaceability so that BumpFee can replace them
+func fundOptions(satPerKw int64) map[string]interface{} {
+	options := map[string]interface{}{"replaceable": true}
+	if satPerKw > 0 {
+		options["feeRate"] = btcAmount(satPerKw * 4) // BTC per kvB
+	}
+	return options
+}
+
+// SendOpReturn : creates a tx with an OP_RETURN output holding data, then has the wallet fund, sign and broadcast it
+func (b *BitcoindBackend) SendOpReturn(data []byte, satPerKw int64) (string, string, error) {
+	var rawTx string
+	outputs := []map[string]string{{"data": hex.EncodeToString(data)}}
+	if err := b.call("createrawtransaction", &rawTx, []interface{}{}, outputs); err != nil {
+		return "", "", err
+	}
+	options := fundOptions(satPerKw)
+	var funded struct {
+		Hex string `json:"hex"`
+	}
+	if err := b.call("fundrawtransaction", &funded, rawTx, options); err != nil {
+		return "", "", err
+	}
+	return b.signAndSend(funded.Hex)
+}
+
+// SendTaprootCommitment : pays to a taproot output whose key is internalKey tweaked with commitment. The output spends the
+// confirmed, unspent outputs of this Core's previous anchors, which are signed for here rather than by the wallet, so the
+// wallet never has to import their tweaked keys. The wallet only funds the tx when they can't cover its fee, and its change
+// is folded into the commitment output, so an anchor takes no more blockspace than a change output would have anyway
+func (b *BitcoindBackend) SendTaprootCommitment(internalKey *btcec.PrivateKey, commitment []byte, previous []TaprootAnchor, satPerKw int64) (string, string, error) {
+	if satPerKw <= 0 {
+		return "", "", errors.New("taproot anchors need a fee rate to pay for the inputs they sign themselves")
+	}
+	pkScript, err := taprootScript(internalKey.PubKey(), commitment)
+	if err != nil {
+		return "", "", err
+	}
+	inputs, err := b.unspentCommitments(internalKey.PubKey(), previous)
+	if err != nil {
+		return "", "", err
+	}
+	if len(inputs) > 0 {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		var total int64
+		indices := []int{}
+		for i, input := range inputs {
+			tx.AddTxIn(&wire.TxIn{PreviousOutPoint: input.outPoint, Sequence: replaceableSequence})
+			total += input.output.Value
+			indices = append(indices, i)
+		}
+		tx.AddTxOut(wire.NewTxOut(0, pkScript))
+		if value := total - signedWeight(tx, indices)*satPerKw/1000; value >= taprootDust {
+			tx.TxOut[0].Value = value
+			return b.signCommitmentTx(tx, internalKey, inputs)
+		}
+	}
+	// the previous anchors' outputs can't pay the fee alone, so the wallet funds the tx and they're added to it afterwards
+	tx, err := b.fundCommitment(pkScript, satPerKw)
+	if err != nil {
+		return "", "", err
+	}
+	added := []commitmentInput{}
+	for _, input := range inputs {
+		if value := input.output.Value - keyPathInputWeight*satPerKw/1000; value > 0 {
+			tx.AddTxIn(&wire.TxIn{PreviousOutPoint: input.outPoint, Sequence: replaceableSequence})
+			tx.TxOut[0].Value += value
+			added = append(added, input)
+		}
+	}
+	return b.signCommitmentTx(tx, internalKey, added)
+}
+
+// BumpTaprootCommitment : replaces the taproot anchor tx txid, which pays to the output of one of known, by one paying
+// satPerKw. The extra fee comes out of the commitment output, and the tx is otherwise unchanged
+func (b *BitcoindBackend) BumpTaprootCommitment(internalKey *btcec.PrivateKey, txid string, known []TaprootAnchor, satPerKw int64) (string, string, error) {
+	scripts, err := commitmentScripts(internalKey.PubKey(), known)
+	if err != nil {
+		return "", "", err
+	}
+	var rawTx string
+	if err := b.call("getrawtransaction", &rawTx, txid); err != nil {
+		return "", "", err
+	}
+	tx, err := txFromHex(rawTx)
+	if err != nil {
+		return "", "", err
+	}
+	var fee int64
+	inputs := []commitmentInput{}
+	for _, in := range tx.TxIn {
+		// the commitment outputs an anchor spends are confirmed, so they're still unspent outside the mempool
+		out, _, err := b.txOut(in.PreviousOutPoint, false)
+		if err != nil {
+			return "", "", err
+		}
+		if out != nil {
+			if commitment, ours := scripts[hex.EncodeToString(out.PkScript)]; ours {
+				inputs = append(inputs, commitmentInput{outPoint: in.PreviousOutPoint, output: out, commitment: commitment})
+				fee += out.Value
+				continue
+			}
+		}
+		out, err = b.walletOutput(in.PreviousOutPoint)
+		if err != nil {
+			return "", "", err
+		}
+		fee += out.Value
+	}
+	anchorOut := -1
+	for i, out := range tx.TxOut {
+		if _, ours := scripts[hex.EncodeToString(out.PkScript)]; ours {
+			anchorOut = i
+		}
+		fee -= out.Value
+	}
+	if anchorOut < 0 {
+		return "", "", errors.New(fmt.Sprintf("tx %s has no taproot anchor output", txid))
+	}
+	weight := txWeight(tx)
+	// a replacement must pay for its own relay on top of the fee of the tx it replaces
+	raise := weight*satPerKw/1000 - fee
+	if minRaise := weight * incrementalRelayFee / 1000; raise < minRaise {
+		raise = minRaise
+	}
+	if tx.TxOut[anchorOut].Value-raise < taprootDust {
+		return "", "", errors.New(fmt.Sprintf("anchor output of tx %s can't pay %d sat/kw", txid, satPerKw))
+	}
+	tx.TxOut[anchorOut].Value -= raise
+	for _, in := range tx.TxIn {
+		in.SignatureScript = nil
+		in.Witness = nil
+	}
+	return b.signCommitmentTx(tx, internalKey, inputs)
+}
+
+// replaceableSequence : the sequence of the inputs anchor txs sign themselves, which signals that the tx can be replaced
+const replaceableSequence = wire.MaxTxInSequenceNum - 2
+
+// keyPathInputWeight : the weight of a taproot input spent by key path with a default sighash signature
+const keyPathInputWeight = (32+4+1+4)*4 + 1 + 1 + schnorr.SignatureSize
+
+// incrementalRelayFee : bitcoind's default rate in sat/kw that replacements must pay for themselves, on top of the fee
+// of the tx they replace
+const incrementalRelayFee = 250
+
+// commitmentInput : an output of one of this Core's taproot anchor txs, spendable by internal key tweaked with commitment
+type commitmentInput struct {
+	outPoint   wire.OutPoint
+	output     *wire.TxOut
+	commitment []byte
+}
+
+// commitmentScripts : the output scripts of anchors, mapped to the commitments internalKey is tweaked with to spend them
+func commitmentScripts(internalKey *btcec.PublicKey, anchors []TaprootAnchor) (map[string][]byte, error) {
+	scripts := make(map[string][]byte)
+	for _, anchor := range anchors {
+		commitment, err := hex.DecodeString(anchor.Commitment)
+		if err != nil {
+			return nil, err
+		}
+		script, err := taprootScript(internalKey, commitment)
+		if err != nil {
+			return nil, err
+		}
+		scripts[hex.EncodeToString(script)] = commitment
+	}
+	return scripts, nil
+}
+
+// unspentCommitments : the confirmed commitment outputs of previous that nothing, not even a tx in the mempool, spends yet.
+// Unconfirmed ones are left alone, since their tx may still be replaced
+func (b *BitcoindBackend) unspentCommitments(internalKey *btcec.PublicKey, previous []TaprootAnchor) ([]commitmentInput, error) {
+	scripts, err := commitmentScripts(internalKey, previous)
+	if err != nil {
+		return nil, err
+	}
+	inputs := []commitmentInput{}
+	seen := make(map[string]bool)
+	for _, anchor := range previous {
+		if seen[anchor.TxID] {
+			continue
+		}
+		seen[anchor.TxID] = true
+		hash, err := chainhash.NewHashFromStr(anchor.TxID)
+		if err != nil {
+			return nil, err
+		}
+		// the commitment output is an anchor tx's only output
+		outPoint := wire.OutPoint{Hash: *hash, Index: 0}
+		out, confirmations, err := b.txOut(outPoint, true)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil || confirmations < 1 {
+			continue
+		}
+		if commitment, ours := scripts[hex.EncodeToString(out.PkScript)]; ours {
+			inputs = append(inputs, commitmentInput{outPoint: outPoint, output: out, commitment: commitment})
+		}
+	}
+	return inputs, nil
+}
+
+// txOut : the unspent output at outPoint and its confirmations, or nil if it's spent or doesn't exist. Spends and outputs
+// in the mempool only count if includeMempool is set
+func (b *BitcoindBackend) txOut(outPoint wire.OutPoint, includeMempool bool) (*wire.TxOut, int64, error) {
+	var result *struct {
+		Value         float64 `json:"value"`
+		Confirmations int64   `json:"confirmations"`
+		ScriptPubKey  struct {
+			Hex string `json:"hex"`
+		} `json:"scriptPubKey"`
+	}
+	if err := b.call("gettxout", &result, outPoint.Hash.String(), outPoint.Index, includeMempool); err != nil {
+		return nil, 0, err
+	}
+	if result == nil {
+		return nil, 0, nil
+	}
+	pkScript, err := hex.DecodeString(result.ScriptPubKey.Hex)
+	if err != nil {
+		return nil, 0, err
+	}
+	return wire.NewTxOut(int64(math.Round(result.Value*1e8)), pkScript), result.Confirmations, nil
+}
+
+// walletOutput : an output of one of the wallet's txs, which gettransaction finds whether or not it's been spent
+func (b *BitcoindBackend) walletOutput(outPoint wire.OutPoint) (*wire.TxOut, error) {
+	var walletTx struct {
+		Hex string `json:"hex"`
+	}
+	if err := b.call("gettransaction", &walletTx, outPoint.Hash.String()); err != nil {
+		return nil, err
+	}
+	tx, err := txFromHex(walletTx.Hex)
+	if err != nil {
+		return nil, err
+	}
+	if int(outPoint.Index) >= len(tx.TxOut) {
+		return nil, errors.New(fmt.Sprintf("wallet tx %s has no output %d", outPoint.Hash, outPoint.Index))
+	}
+	return tx.TxOut[outPoint.Index], nil
+}
+
+// fundCommitment : has the wallet fund a tx paying to pkScript, then moves its change into the commitment output along
+// with the fee the dropped change output no longer needs. The commitment output is the tx's first and only output
+func (b *BitcoindBackend) fundCommitment(pkScript []byte, satPerKw int64) (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxOut(wire.NewTxOut(taprootDust, pkScript))
+	unfunded, err := txToHex(tx)
+	if err != nil {
+		return nil, err
+	}
+	var funded struct {
+		Hex       string `json:"hex"`
+		ChangePos int    `json:"changepos"`
+	}
+	if err := b.call("fundrawtransaction", &funded, unfunded, fundOptions(satPerKw)); err != nil {
+		return nil, err
+	}
+	fundedTx, err := txFromHex(funded.Hex)
+	if err != nil {
+		return nil, err
+	}
+	if funded.ChangePos >= 0 && funded.ChangePos < len(fundedTx.TxOut) {
+		change := fundedTx.TxOut[funded.ChangePos]
+		saved := int64(change.SerializeSize()) * satPerKw * 4 / 1000
+		fundedTx.TxOut = append(fundedTx.TxOut[:funded.ChangePos], fundedTx.TxOut[funded.ChangePos+1:]...)
+		fundedTx.TxOut[0].Value += change.Value + saved
+	}
+	return fundedTx, nil
+}
+
+// signCommitmentTx : has the wallet sign tx's wallet inputs, signs its spends of inputs by key path, and broadcasts it,
+// returning its txid and raw tx without witness data
+func (b *BitcoindBackend) signCommitmentTx(tx *wire.MsgTx, internalKey *btcec.PrivateKey, inputs []commitmentInput) (string, string, error) {
+	ours := make(map[wire.OutPoint]commitmentInput)
+	for _, input := range inputs {
+		ours[input.outPoint] = input
+	}
+	if len(tx.TxIn) > len(inputs) {
+		txHex, err := txToHex(tx)
+		if err != nil {
+			return "", "", err
+		}
+		// taproot wallet inputs can only be signed once the wallet knows every output the tx spends
+		prevTxs := []map[string]interface{}{}
+		for _, input := range inputs {
+			prevTxs = append(prevTxs, map[string]interface{}{
+				"txid":         input.outPoint.Hash.String(),
+				"vout":         input.outPoint.Index,
+				"scriptPubKey": hex.EncodeToString(input.output.PkScript),
+				"amount":       btcAmount(input.output.Value),
+			})
+		}
+		var signed struct {
+			Hex      string `json:"hex"`
+			Complete bool   `json:"complete"`
+		}
+		if err := b.call("signrawtransactionwithwallet", &signed, txHex, prevTxs); err != nil {
+			return "", "", err
+		}
+		if !signed.Complete && len(inputs) == 0 {
+			return "", "", errors.New("bitcoind wallet could not sign anchor tx")
+		}
+		if tx, err = txFromHex(signed.Hex); err != nil {
+			return "", "", err
+		}
+	}
+	if len(inputs) > 0 {
+		prevOuts := txscript.NewMultiPrevOutFetcher(nil)
+		for _, in := range tx.TxIn {
+			if input, isOurs := ours[in.PreviousOutPoint]; isOurs {
+				prevOuts.AddPrevOut(in.PreviousOutPoint, input.output)
+				continue
+			}
+			out, err := b.walletOutput(in.PreviousOutPoint)
+			if err != nil {
+				return "", "", err
+			}
+			prevOuts.AddPrevOut(in.PreviousOutPoint, out)
+		}
+		sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+		for i, in := range tx.TxIn {
+			input, isOurs := ours[in.PreviousOutPoint]
+			if !isOurs {
+				continue
+			}
+			// TweakTaprootPrivKey, which signing calls, negates the key it's given in place when its y coordinate is odd
+			keyCopy, _ := btcec.PrivKeyFromBytes(internalKey.Serialize())
+			sig, err := txscript.RawTxInTaprootSignature(tx, sigHashes, i, input.output.Value, input.output.PkScript, input.commitment, txscript.SigHashDefault, keyCopy)
+			if err != nil {
+				return "", "", err
+			}
+			tx.TxIn[i].Witness = wire.TxWitness{sig}
+		}
+	}
+	txHex, err := txToHex(tx)
+	if err != nil {
+		return "", "", err
+	}
+	var txid string
+	if err := b.call("sendrawtransaction", &txid, txHex); err != nil {
+		return "", "", err
+	}
+	return stripWitness(txHex)
+}
+
+// signedWeight : the weight tx will have once the inputs at indices are signed by key path. Its other inputs must be signed
+func signedWeight(tx *wire.MsgTx, indices []int) int64 {
+	signed := tx.Copy()
+	for _, i := range indices {
+		signed.TxIn[i].Witness = wire.TxWitness{make([]byte, schnorr.SignatureSize)}
+	}
+	return txWeight(signed)
+}
+
+// txWeight : a tx's weight, which counts its witness-free bytes four times and its witness bytes once
+func txWeight(tx *wire.MsgTx) int64 {
+	return int64(tx.SerializeSizeStripped()*3 + tx.SerializeSize())
+}
+
+// taprootScript : the output script paying to internalKey tweaked with commitment
+func taprootScript(internalKey *btcec.PublicKey, commitment []byte) ([]byte, error) {
+	outputKey := txscript.ComputeTaprootOutputKey(internalKey, commitment)
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_1).AddData(outputKey.SerializeCompressed()[1:]).Script()
+}
+
+// txToHex : a tx's hex serialization, with witness data if it has any
+func txToHex(tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// txFromHex : decodes a hex tx
+func txFromHex(txHex string) (*wire.MsgTx, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// signAndSend : has the wallet sign a funded tx and broadcasts it, returning its txid and raw tx without witness data
+func (b *BitcoindBackend) signAndSend(fundedHex string) (string, string, error) {
+	var signed struct {
+		Hex      string `json:"hex"`
+		Complete bool   `json:"complete"`
+	}
+	if err := b.call("signrawtransactionwithwallet", &signed, fundedHex); err != nil {
+		return "", "", err
+	}
+	if !signed.Complete {
+		return "", "", errors.New("bitcoind wallet could not sign anchor tx")
+	}
+	var txid string
+	if err := b.call("sendrawtransaction", &txid, signed.Hex); err != nil {
+		return "", "", err
+	}
+	return stripWitness(signed.Hex)
+}
+
+// stripWitness : the txid and witness-free serialization of a hex tx
+func stripWitness(txHex string) (string, string, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return "", "", err
+	}
+	var msgTx wire.MsgTx
+	if err := msgTx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return "", "", err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, msgTx.SerializeSizeStripped()))
+	if err := msgTx.SerializeNoWitness(buf); err != nil {
+		return "", "", err
+	}
+	return msgTx.TxHash().String(), hex.EncodeToString(buf.Bytes()), nil
+}
+
+// BumpFee : replaces txid by fee with the wallet's bumpfee, which relies on fundOptions having made it replaceable
+func (b *BitcoindBackend) BumpFee(txid string, satPerKw int64) (string, string, error) {
+	var bumped struct {
+		TxID string `json:"txid"`
+	}
+	// bumpfee takes sat/vB
+	if err := b.call("bumpfee", &bumped, txid, map[string]interface{}{"fee_rate": json.Number(strconv.FormatFloat(float64(satPerKw)*4/1000, 'f', 3, 64))}); err != nil {
+		return "", "", err
+	}
+	var replacement struct {
+		Hex string `json:"hex"`
+	}
+	if err := b.call("gettransaction", &replacement, bumped.TxID); err != nil {
+		return "", "", err
+	}
+	return stripWitness(replacement.Hex)
+}
+
+// ChildTx : the first mempool tx spending an output of txid
+func (b *BitcoindBackend) ChildTx(txid string) (string, error) {
+	var entry struct {
+		SpentBy []string `json:"spentby"`
+	}
+	if err := b.call("getmempoolentry", &entry, txid); err != nil {
+		return "", err
+	}
+	if len(entry.SpentBy) == 0 {
+		return "", nil
+	}
+	return entry.SpentBy[0], nil
+}
+
+func (b *BitcoindBackend) SendCoins(addr string, amount int64) (string, error) {
+	var txid string
+	err := b.call("sendtoaddress", &txid, addr, btcAmount(amount))
+	return txid, err
+}
+
+// TxFee : gettransaction reports the fee of the wallet's own txs as a negative BTC amount, but only if the wallet funded
+// every input. Taproot anchor txs that spend previous anchors' outputs aren't, so their fee is read from the mempool
+func (b *BitcoindBackend) TxFee(txid string) (int64, error) {
+	var tx struct {
+		Fee *float64 `json:"fee"`
+	}
+	if err := b.call("gettransaction", &tx, txid); err == nil && tx.Fee != nil {
+		return -int64(math.Round(*tx.Fee * 1e8)), nil
+	}
+	var entry struct {
+		Fees struct {
+			Base float64 `json:"base"`
+		} `json:"fees"`
+	}
+	if err := b.call("getmempoolentry", &entry, txid); err != nil {
+		return 0, err
+	}
+	return int64(math.Round(entry.Fees.Base * 1e8)), nil
+}
+
+// WalletBalance : the wallet's trusted balance, which excludes unconfirmed txs from other wallets
+func (b *BitcoindBackend) WalletBalance() (int64, error) {
+	var balance float64
+	if err := b.call("getbalance", &balance); err != nil {
+		return 0, err
+	}
+	return int64(math.Round(balance * 1e8)), nil
+}
+
+// EstimateFee : converts bitcoind's BTC/kvB estimate to sat/kw
+func (b *BitcoindBackend) EstimateFee(confTarget int64) (int64, error) {
+	var estimate struct {
+		FeeRate float64  `json:"feerate"`
+		Errors  []string `json:"errors"`
+	}
+	if err := b.call("estimatesmartfee", &estimate, confTarget); err != nil {
+		return 0, err
+	}
+	if estimate.FeeRate <= 0 {
+		return 0, errors.New(fmt.Sprintf("bitcoind has no fee estimate: %s", strings.Join(estimate.Errors, ", ")))
+	}
+	return int64(math.Round(estimate.FeeRate * 1e8 / 4)), nil
+}
+
+func (b *BitcoindBackend) BlockHeight() (int64, error) {
+	var height int64
+	err := b.call("getblockcount", &height)
+	return height, err
+}
+
+func (b *BitcoindBackend) GetBlockByHeight(height int64) (Block, error) {
+	var hash string
+	if err := b.call("getblockhash", &hash, height); err != nil {
+		return Block{}, err
+	}
+	var block struct {
+		MerkleRoot string   `json:"merkleroot"`
+		Tx         []string `json:"tx"`
+	}
+	if err := b.call("getblock", &block, hash, 1); err != nil {
+		return Block{}, err
+	}
+	return Block{Height: height, MerkleRoot: block.MerkleRoot, TxIDs: block.Tx}, nil
+}
+
+// TxBlockHeights : finds the block of each tx directly, only scanning from fromHeight to toHeight for txs that are neither in
+// the wallet, the txindex, nor have an unspent output
+func (b *BitcoindBackend) TxBlockHeights(txids []string, fromHeight int64, toHeight int64) (map[string]int64, error) {
+	heights := make(map[string]int64)
+	pending := make(map[string]bool)
+	for _, txid := range txids {
+		blockHash := b.txBlockHash(txid)
+		if blockHash == "" {
+			pending[txid] = true
+			continue
+		}
+		var header struct {
+			Height        int64 `json:"height"`
+			Confirmations int64 `json:"confirmations"`
+		}
+		if err := b.call("getblockheader", &header, blockHash, true); err != nil {
+			return heights, err
+		}
+		if header.Confirmations < 1 { // the block was reorged out
+			continue
+		}
+		heights[txid] = header.Height
+	}
+	return heights, scanBlocks(b, pending, heights, fromHeight, toHeight)
+}
+
+// txBlockHash : the hash of the block txid was mined in, or "" if bitcoind can't find it
+func (b *BitcoindBackend) txBlockHash(txid string) string {
+	var walletTx struct {
+		BlockHash string `json:"blockhash"`
+	}
+	if b.call("gettransaction", &walletTx, txid) == nil && walletTx.BlockHash != "" {
+		return walletTx.BlockHash
+	}
+	var rawTx struct {
+		BlockHash string `json:"blockhash"`
+	}
+	if b.call("getrawtransaction", &rawTx, txid, true) == nil && rawTx.BlockHash != "" {
+		return rawTx.BlockHash
+	}
+	// a merkle block, whose first 80 bytes are the header of the block containing txid
+	var txOutProof string
+	if b.call("gettxoutproof", &txOutProof, []string{txid}) != nil {
+		return ""
+	}
+	proofBytes, err := hex.DecodeString(txOutProof)
+	if err != nil {
+		return ""
+	}
+	var header wire.BlockHeader
+	if header.Deserialize(bytes.NewReader(proofBytes)) != nil {
+		return ""
+	}
+	return header.BlockHash().String()
+}
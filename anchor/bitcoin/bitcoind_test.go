@@ -0,0 +1,321 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/chainpoint/chainpoint-core/calendar"
+	"github.com/chainpoint/chainpoint-core/proof"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBitcoind : answers each JSON-RPC method with a canned result, or the result of calling a func with the request's
+// params, or a -5 error for methods without one
+func fakeBitcoind(t *testing.T, results map[string]interface{}, calls map[string][]interface{}) *BitcoindBackend {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "pass", pass)
+		assert.Equal(t, "/wallet/anchors", r.URL.Path)
+		var req rpcRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		calls[req.Method] = req.Params
+		result, exists := results[req.Method]
+		if !exists {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": nil, "error": RPCError{Code: -5, Message: "not found"}})
+			return
+		}
+		if respond, isFunc := result.(func([]interface{}) interface{}); isFunc {
+			result = respond(req.Params)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": nil})
+	}))
+	t.Cleanup(server.Close)
+	return NewBitcoindBackend(types.BitcoindConfig{RPCURL: server.URL + "/", RPCUser: "user", RPCPass: "pass", Wallet: "anchors"})
+}
+
+func TestBitcoindSendOpReturn(t *testing.T) {
+	root, _ := hex.DecodeString("c0ffee")
+	script, _ := txscript.NullDataScript(root)
+	signedTx := wire.NewMsgTx(2)
+	signedTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}, Witness: wire.TxWitness{[]byte{2, 3}}})
+	signedTx.AddTxOut(wire.NewTxOut(0, script))
+	var signed, stripped bytes.Buffer
+	assert.Nil(t, signedTx.Serialize(&signed))
+	assert.Nil(t, signedTx.SerializeNoWitness(&stripped))
+
+	calls := map[string][]interface{}{}
+	backend := fakeBitcoind(t, map[string]interface{}{
+		"createrawtransaction":         "unfunded",
+		"fundrawtransaction":           map[string]interface{}{"hex": "funded"},
+		"signrawtransactionwithwallet": map[string]interface{}{"hex": hex.EncodeToString(signed.Bytes()), "complete": true},
+		"sendrawtransaction":           signedTx.TxHash().String(),
+	}, calls)
+	txid, rawTx, err := backend.SendOpReturn(root, 2500)
+	assert.Nil(t, err)
+	assert.Equal(t, signedTx.TxHash().String(), txid)
+	assert.Equal(t, hex.EncodeToString(stripped.Bytes()), rawTx)
+	assert.Equal(t, []interface{}{[]interface{}{}, []interface{}{map[string]interface{}{"data": "c0ffee"}}}, calls["createrawtransaction"])
+	// 2500 sat/kw is 10 sat/vB
+	assert.Equal(t, []interface{}{"unfunded", map[string]interface{}{"replaceable": true, "feeRate": 0.0001}}, calls["fundrawtransaction"])
+	assert.Equal(t, []interface{}{"funded"}, calls["signrawtransactionwithwallet"])
+}
+
+func TestBitcoindBumpFee(t *testing.T) {
+	replacementTx := wire.NewMsgTx(2)
+	replacementTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}, Witness: wire.TxWitness{[]byte{2, 3}}})
+	replacementTx.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	var signed, stripped bytes.Buffer
+	assert.Nil(t, replacementTx.Serialize(&signed))
+	assert.Nil(t, replacementTx.SerializeNoWitness(&stripped))
+
+	calls := map[string][]interface{}{}
+	backend := fakeBitcoind(t, map[string]interface{}{
+		"bumpfee":        map[string]interface{}{"txid": replacementTx.TxHash().String()},
+		"gettransaction": map[string]interface{}{"hex": hex.EncodeToString(signed.Bytes())},
+	}, calls)
+	txid, rawTx, err := backend.BumpFee("stuck", 2500)
+	assert.Nil(t, err)
+	assert.Equal(t, replacementTx.TxHash().String(), txid)
+	assert.Equal(t, hex.EncodeToString(stripped.Bytes()), rawTx)
+	assert.Equal(t, []interface{}{"stuck", map[string]interface{}{"fee_rate": 10.0}}, calls["bumpfee"])
+	assert.Equal(t, []interface{}{txid}, calls["gettransaction"])
+}
+
+// editTx : decodes the hex tx in params, applies edit to it and reencodes it. Like bitcoind, txs without inputs are
+// decoded without witness data when they can't be decoded with it
+func editTx(params []interface{}, edit func(tx *wire.MsgTx)) string {
+	txBytes, _ := hex.DecodeString(params[0].(string))
+	var tx wire.MsgTx
+	if tx.Deserialize(bytes.NewReader(txBytes)) != nil {
+		tx = wire.MsgTx{}
+		tx.DeserializeNoWitness(bytes.NewReader(txBytes))
+	}
+	edit(&tx)
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestBitcoindTaprootCommitmentProof(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	internalKey := TaprootInternalKey(ecKey)
+	root, _ := hex.DecodeString("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
+	changeScript := append([]byte{0, 20}, bytes.Repeat([]byte{9}, 20)...)
+
+	calls := map[string][]interface{}{}
+	backend := fakeBitcoind(t, map[string]interface{}{
+		"fundrawtransaction": func(params []interface{}) interface{} {
+			funded := editTx(params, func(tx *wire.MsgTx) {
+				tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+				tx.AddTxOut(wire.NewTxOut(100000, changeScript))
+			})
+			return map[string]interface{}{"hex": funded, "changepos": 1}
+		},
+		"signrawtransactionwithwallet": func(params []interface{}) interface{} {
+			signed := editTx(params, func(tx *wire.MsgTx) { tx.TxIn[0].Witness = wire.TxWitness{[]byte{2, 3}} })
+			return map[string]interface{}{"hex": signed, "complete": true}
+		},
+		"sendrawtransaction": func(params []interface{}) interface{} {
+			var txid string
+			editTx(params, func(tx *wire.MsgTx) { txid = tx.TxHash().String() })
+			return txid
+		},
+	}, calls)
+	txid, rawTx, err := backend.SendTaprootCommitment(internalKey, root, nil, 2500)
+	assert.Nil(t, err)
+
+	// the change is folded into the commitment output, along with the 31 vbytes of fee it no longer needs
+	txBytes, _ := hex.DecodeString(rawTx)
+	var tx wire.MsgTx
+	assert.Nil(t, tx.DeserializeNoWitness(bytes.NewReader(txBytes)))
+	assert.Len(t, tx.TxOut, 1)
+	assert.Equal(t, int64(taprootDust+100000+310), tx.TxOut[0].Value)
+	keyHex := hex.EncodeToString(schnorr.SerializePubKey(internalKey.PubKey()))
+	script, err := anchorScript(keyHex, root)
+	assert.Nil(t, err)
+	assert.Equal(t, script, tx.TxOut[0].PkScript)
+
+	// the btc tx ops lead from the anchor root to the txid
+	btcTxState, err := calendar.GenerateAnchorBtcTxState(types.BtcTxMsg{
+		AnchorBtcAggRoot:   hex.EncodeToString(root),
+		BtcTxID:            txid,
+		BtcTxBody:          rawTx,
+		TaprootInternalKey: keyHex,
+	})
+	assert.Nil(t, err)
+	var ops types.OpsState
+	assert.Nil(t, json.Unmarshal([]byte(btcTxState.BtcTxState), &ops))
+	assert.Equal(t, proof.TapTweakOp, ops.Ops[1].Op)
+	value := root
+	for _, op := range ops.Ops {
+		value, err = proof.ApplyOp(proof.Op{Left: op.Left, Right: op.Right, Op: op.Op}, value)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, txid, proof.ExpectedAnchorValue("btc", value))
+
+	// a tx that doesn't commit to the root is an error rather than a panic
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = calendar.GenerateAnchorBtcTxState(types.BtcTxMsg{
+		AnchorBtcAggRoot:   hex.EncodeToString(root),
+		BtcTxID:            txid,
+		BtcTxBody:          rawTx,
+		TaprootInternalKey: hex.EncodeToString(schnorr.SerializePubKey(TaprootInternalKey(otherKey).PubKey())),
+	})
+	assert.NotNil(t, err)
+}
+
+func TestBitcoindTaprootCommitmentChain(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	internalKey := TaprootInternalKey(ecKey)
+	previousRoot, _ := hex.DecodeString("60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752")
+	root, _ := hex.DecodeString("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
+	previousScript, _ := taprootScript(internalKey.PubKey(), previousRoot)
+	previous := []TaprootAnchor{{TxID: chainhash.Hash{7}.String(), Commitment: hex.EncodeToString(previousRoot)}}
+
+	var sent string
+	calls := map[string][]interface{}{}
+	backend := fakeBitcoind(t, map[string]interface{}{
+		"gettxout": map[string]interface{}{"value": 0.001, "confirmations": 2, "scriptPubKey": map[string]interface{}{"hex": hex.EncodeToString(previousScript)}},
+		"getrawtransaction": func([]interface{}) interface{} {
+			return sent
+		},
+		"sendrawtransaction": func(params []interface{}) interface{} {
+			sent = params[0].(string)
+			tx, _ := txFromHex(sent)
+			return tx.TxHash().String()
+		},
+	}, calls)
+
+	// the previous anchor's output covers the fee, so the wallet isn't asked to fund or sign anything
+	txid, _, err := backend.SendTaprootCommitment(internalKey, root, previous, 2500)
+	assert.Nil(t, err)
+	_, funded := calls["fundrawtransaction"]
+	assert.False(t, funded)
+	tx, err := txFromHex(sent)
+	assert.Nil(t, err)
+	assert.Equal(t, txid, tx.TxHash().String())
+	assert.Len(t, tx.TxIn, 1)
+	assert.Equal(t, wire.OutPoint{Hash: chainhash.Hash{7}, Index: 0}, tx.TxIn[0].PreviousOutPoint)
+	assert.Len(t, tx.TxOut, 1)
+	assert.Equal(t, 100000-txWeight(tx)*2500/1000, tx.TxOut[0].Value)
+	script, _ := taprootScript(internalKey.PubKey(), root)
+	assert.Equal(t, script, tx.TxOut[0].PkScript)
+	assertKeyPathSpend(t, tx, wire.NewTxOut(100000, previousScript))
+
+	// a bump takes the extra fee out of the commitment output and signs the spend again
+	known := append(previous, TaprootAnchor{TxID: txid, Commitment: hex.EncodeToString(root)})
+	bumpedID, _, err := backend.BumpTaprootCommitment(internalKey, txid, known, 5000)
+	assert.Nil(t, err)
+	bumped, err := txFromHex(sent)
+	assert.Nil(t, err)
+	assert.Equal(t, bumpedID, bumped.TxHash().String())
+	assert.NotEqual(t, txid, bumpedID)
+	assert.Equal(t, 100000-txWeight(bumped)*5000/1000, bumped.TxOut[0].Value)
+	assert.Equal(t, []interface{}{chainhash.Hash{7}.String(), 0.0, false}, calls["gettxout"])
+	assertKeyPathSpend(t, bumped, wire.NewTxOut(100000, previousScript))
+}
+
+// assertKeyPathSpend : checks that tx's only input validly spends prevOut
+func assertKeyPathSpend(t *testing.T, tx *wire.MsgTx, prevOut *wire.TxOut) {
+	prevOuts := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+	engine, err := txscript.NewEngine(prevOut.PkScript, tx, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, prevOuts), prevOut.Value, prevOuts)
+	assert.Nil(t, err)
+	assert.Nil(t, engine.Execute())
+}
+
+func TestBitcoindTxBlockHeights(t *testing.T) {
+	header := wire.BlockHeader{Version: 4, Bits: 0x207fffff, Nonce: 7}
+	var proof bytes.Buffer
+	assert.Nil(t, header.Serialize(&proof))
+	proof.Write([]byte{1, 0, 0, 0}) // the merkle block's tx count and hashes follow the header
+
+	calls := map[string][]interface{}{}
+	backend := fakeBitcoind(t, map[string]interface{}{
+		"gettransaction":   map[string]interface{}{"blockhash": ""},
+		"gettxoutproof":    hex.EncodeToString(proof.Bytes()),
+		"getblockheader":   map[string]interface{}{"height": 120, "confirmations": 3},
+		"getblockhash":     "hash",
+		"getblock":         map[string]interface{}{"merkleroot": "root", "tx": []string{"coinbase"}},
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.0002, "blocks": 2},
+	}, calls)
+	heights, err := backend.TxBlockHeights([]string{"anchor"}, 118, 120)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"anchor": 120}, heights)
+	assert.Equal(t, []interface{}{header.BlockHash().String(), true}, calls["getblockheader"])
+	_, scanned := calls["getblock"]
+	assert.False(t, scanned, "txs found through gettxoutproof should not be searched for block by block")
+
+	// 0.0002 BTC/kvB is 20 sat/vB
+	fee, err := backend.EstimateFee(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{2.0}, calls["estimatesmartfee"])
+	assert.Equal(t, int64(5000), fee)
+}
+
+func TestBitcoindRegtestAnchor(t *testing.T) {
+	url := os.Getenv("BITCOIND_RPC_URL")
+	if url == "" {
+		t.Skip("set BITCOIND_RPC_URL, BITCOIND_RPC_USER, BITCOIND_RPC_PASS and BITCOIND_WALLET to run against a regtest bitcoind")
+	}
+	backend := NewBitcoindBackend(types.BitcoindConfig{
+		RPCURL:  url,
+		RPCUser: os.Getenv("BITCOIND_RPC_USER"),
+		RPCPass: os.Getenv("BITCOIND_RPC_PASS"),
+		Wallet:  os.Getenv("BITCOIND_WALLET"),
+	})
+	var addr string
+	assert.Nil(t, backend.call("getnewaddress", &addr))
+	assert.Nil(t, backend.call("generatetoaddress", nil, 101, addr))
+
+	root, _ := hex.DecodeString("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
+	txid, _, err := backend.SendOpReturn(root, 2500)
+	assert.Nil(t, err)
+	assert.Nil(t, backend.call("generatetoaddress", nil, 1, addr))
+	height, err := backend.BlockHeight()
+	assert.Nil(t, err)
+
+	heights, err := backend.TxBlockHeights([]string{txid}, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, height, heights[txid])
+	block, err := backend.GetBlockByHeight(height)
+	assert.Nil(t, err)
+	_, txIndex, err := blockTree(block, txid)
+	assert.Nil(t, err)
+	assert.Equal(t, txid, block.TxIDs[txIndex])
+
+	// the next taproot commitment spends the previous one's output, which the wallet never had to import a key for
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	internalKey := TaprootInternalKey(ecKey)
+	txid, _, err = backend.SendTaprootCommitment(internalKey, root, nil, 2500)
+	assert.Nil(t, err)
+	assert.Nil(t, backend.call("generatetoaddress", nil, 1, addr))
+	previous := []TaprootAnchor{{TxID: txid, Commitment: hex.EncodeToString(root)}}
+	nextRoot, _ := hex.DecodeString("60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752")
+	nextID, _, err := backend.SendTaprootCommitment(internalKey, nextRoot, previous, 2500)
+	assert.Nil(t, err)
+	bumpedID, _, err := backend.BumpTaprootCommitment(internalKey, nextID, append(previous, TaprootAnchor{TxID: nextID, Commitment: hex.EncodeToString(nextRoot)}), 5000)
+	assert.Nil(t, err)
+	assert.Nil(t, backend.call("generatetoaddress", nil, 1, addr))
+	heights, err = backend.TxBlockHeights([]string{bumpedID}, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, height+2, heights[bumpedID])
+	previousHash, _ := chainhash.NewHashFromStr(txid)
+	spent, _, err := backend.txOut(wire.OutPoint{Hash: *previousHash, Index: 0}, false)
+	assert.Nil(t, err)
+	assert.Nil(t, spent)
+}
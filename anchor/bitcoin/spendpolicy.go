@@ -0,0 +1,213 @@
+package bitcoin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/types"
+)
+
+const SPEND_RECORDS_KEY = "BTC_Spend:Records"
+
+// the windows the daily and weekly budgets are spent over
+const (
+	dailyWindow  = 24 * time.Hour
+	weeklyWindow = 7 * dailyWindow
+)
+
+// anchorTxWeight : the weight of a typical anchor tx, with one segwit input, the anchor output and a change output. Anchor
+// fees are budgeted at this weight, since the backends pay a fee rate rather than a fee
+const anchorTxWeight = 612
+
+// SpendRecord : an outflow from the anchoring wallet, counted against the spend budgets for a week
+type SpendRecord struct {
+	Time   int64  `json:"time"`
+	Kind   string `json:"kind"` // anchor, bump or reward
+	TxID   string `json:"txid"`
+	Amount int64  `json:"amount"`
+}
+
+// SpendPolicy : enforces SpendPolicyConfig on anchor fees and anchor rewards. Spends are recorded in the cache, so budgets
+// survive restarts
+type SpendPolicy struct {
+	config      types.SpendPolicyConfig
+	chain       ChainBackend
+	cache       *level.KVStore
+	mutex       sync.Mutex
+	refusals    int64
+	lastRefusal string
+	localRate   int64 // this Core's own fee estimate, anchored at while the Calendar has no recent FEE tx
+}
+
+// NewSpendPolicy : creates a policy limiting what chain's wallet spends
+func NewSpendPolicy(config types.SpendPolicyConfig, chain ChainBackend, cache *level.KVStore) *SpendPolicy {
+	return &SpendPolicy{config: config, chain: chain, cache: cache}
+}
+
+// SetLocalRate : has anchors pay this Core's own fee estimate in place of the Calendar's rate. 0 goes back to the Calendar's rate.
+// Only this Core's anchoring uses it; the Calendar's rate stays the one hashes are priced at
+func (p *SpendPolicy) SetLocalRate(satPerKw int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.localRate = satPerKw
+}
+
+// Rate : the rate this Core anchors at, which is its local estimate if one is set and otherwise the Calendar's rate satPerKw
+func (p *SpendPolicy) Rate(satPerKw int64) int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.localRate > 0 {
+		return p.localRate
+	}
+	return satPerKw
+}
+
+// AnchorFee : the fee in satoshis of an anchor tx paying satPerKw
+func AnchorFee(satPerKw int64) int64 {
+	return satPerKw * anchorTxWeight / 1000
+}
+
+// AnchorRate : the fee rate an anchor tx may pay given the Calendar's rate satPerKw. Rates whose fee exceeds the max anchor
+// fee are refused, or capped if CapAnchorFee is set. Without a Calendar rate the anchor is refused, rather than leaving the
+// backend to pick a rate the max anchor fee and budgets know nothing about
+func (p *SpendPolicy) AnchorRate(satPerKw int64) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if satPerKw <= 0 {
+		return 0, p.refuse("the Calendar has no bitcoin fee rate to anchor at")
+	}
+	if p.config.MaxAnchorFee > 0 && AnchorFee(satPerKw) > p.config.MaxAnchorFee {
+		if !p.config.CapAnchorFee {
+			return 0, p.refuse(fmt.Sprintf("anchor fee of %d sats exceeds the max anchor fee of %d", AnchorFee(satPerKw), p.config.MaxAnchorFee))
+		}
+		return p.config.MaxAnchorFee * 1000 / anchorTxWeight, nil
+	}
+	return satPerKw, nil
+}
+
+// Spend : calls send if the estimated amount fits in the budgets and leaves the reserve in the wallet. If send succeeds,
+// what it reports having actually spent is recorded against the budgets, since fees paid at a rate only match their
+// estimate at the estimated weight. The policy stays locked throughout, so concurrent spends can't share the same
+// remaining budget
+func (p *SpendPolicy) Spend(kind string, estimate int64, send func() (string, int64, error)) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err := p.authorize(estimate); err != nil {
+		return err
+	}
+	txid, spent, err := send()
+	if err != nil {
+		return err
+	}
+	return p.record(kind, txid, spent)
+}
+
+// Correct : records the difference between what a spend was charged and what it turned out to cost, for spends whose cost
+// is only known once the backend has broadcast their tx
+func (p *SpendPolicy) Correct(kind string, txid string, delta int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if delta == 0 {
+		return nil
+	}
+	return p.record(kind, txid, delta)
+}
+
+func (p *SpendPolicy) authorize(amount int64) error {
+	daily, weekly, err := p.spent(time.Now())
+	if err != nil {
+		return err
+	}
+	if p.config.DailyBudget > 0 && daily+amount > p.config.DailyBudget {
+		return p.refuse(fmt.Sprintf("spending %d sats would exceed the daily budget of %d, of which %d is spent", amount, p.config.DailyBudget, daily))
+	}
+	if p.config.WeeklyBudget > 0 && weekly+amount > p.config.WeeklyBudget {
+		return p.refuse(fmt.Sprintf("spending %d sats would exceed the weekly budget of %d, of which %d is spent", amount, p.config.WeeklyBudget, weekly))
+	}
+	if p.config.MinReserve > 0 {
+		balance, err := p.chain.WalletBalance()
+		if err != nil {
+			return err
+		}
+		if balance-amount < p.config.MinReserve {
+			return p.refuse(fmt.Sprintf("spending %d sats would leave %d, below the reserve of %d", amount, balance-amount, p.config.MinReserve))
+		}
+	}
+	return nil
+}
+
+func (p *SpendPolicy) refuse(reason string) error {
+	p.refusals++
+	p.lastRefusal = reason
+	return errors.New("spend policy: " + reason)
+}
+
+// record : counts a spend against the budgets, dropping records that have aged out of them
+func (p *SpendPolicy) record(kind string, txid string, amount int64) error {
+	now := time.Now()
+	records, err := p.records()
+	if err != nil {
+		return err
+	}
+	kept := []string{}
+	for _, record := range records {
+		var spend SpendRecord
+		if json.Unmarshal([]byte(record), &spend) == nil && now.Sub(time.Unix(spend.Time, 0)) < weeklyWindow {
+			kept = append(kept, record)
+		}
+	}
+	recordJSON, err := json.Marshal(SpendRecord{Time: now.Unix(), Kind: kind, TxID: txid, Amount: amount})
+	if err != nil {
+		return err
+	}
+	return p.cache.SetArray(SPEND_RECORDS_KEY, append(kept, string(recordJSON)))
+}
+
+func (p *SpendPolicy) records() ([]string, error) {
+	return p.cache.GetArray(SPEND_RECORDS_KEY)
+}
+
+// spent : the satoshis spent in the 24 hours and 7 days before now
+func (p *SpendPolicy) spent(now time.Time) (int64, int64, error) {
+	records, err := p.records()
+	if err != nil {
+		return 0, 0, err
+	}
+	var daily, weekly int64
+	for _, record := range records {
+		var spend SpendRecord
+		if json.Unmarshal([]byte(record), &spend) != nil {
+			continue
+		}
+		age := now.Sub(time.Unix(spend.Time, 0))
+		if age < dailyWindow {
+			daily += spend.Amount
+		}
+		if age < weeklyWindow {
+			weekly += spend.Amount
+		}
+	}
+	return daily, weekly, nil
+}
+
+// Status : the policy's limits and what has been spent against them
+func (p *SpendPolicy) Status() types.SpendStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	daily, weekly, _ := p.spent(time.Now())
+	return types.SpendStatus{
+		MaxAnchorFee: p.config.MaxAnchorFee,
+		CapAnchorFee: p.config.CapAnchorFee,
+		DailyBudget:  p.config.DailyBudget,
+		DailySpent:   daily,
+		WeeklyBudget: p.config.WeeklyBudget,
+		WeeklySpent:  weekly,
+		MinReserve:   p.config.MinReserve,
+		Refusals:     p.refusals,
+		LastRefusal:  p.lastRefusal,
+	}
+}
@@ -0,0 +1,82 @@
+package bitcoin
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/ledger"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestSpendPolicy(t *testing.T) {
+	db := dbm.NewDB("spend", dbm.MemDBBackend, "")
+	cache := level.NewKVStore(&db, log.NewNopLogger())
+	chain := fakeBitcoind(t, map[string]interface{}{"getbalance": 0.001}, map[string][]interface{}{})
+	config := types.SpendPolicyConfig{MaxAnchorFee: 3000, DailyBudget: 5000, MinReserve: 97500}
+	policy := NewSpendPolicy(config, chain, cache)
+
+	// 10000 sat/kw is 6120 sats for an anchor tx, over the max anchor fee
+	_, err := policy.AnchorRate(10000)
+	assert.NotNil(t, err)
+	policy.config.CapAnchorFee = true
+	rate, err := policy.AnchorRate(10000)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4901), rate)
+	assert.LessOrEqual(t, AnchorFee(rate), int64(3000))
+
+	_, err = policy.AnchorRate(0)
+	assert.NotNil(t, err, "anchors aren't left to pay whatever rate the backend picks")
+
+	// the fee actually paid is what counts against the budgets, not the estimate
+	send := func() (string, int64, error) { return "txid", 2100, nil }
+	assert.Nil(t, policy.Spend("anchor", 2000, send))
+	assert.NotNil(t, policy.Spend("anchor", 3000, send), "the daily budget only has 2900 sats left")
+	assert.NotNil(t, policy.Spend("reward", 2600, send), "the wallet's 100000 sats can't go below the reserve")
+	assert.NotNil(t, policy.Spend("anchor", 1000, func() (string, int64, error) { return "", 0, errors.New("broadcast failed") }))
+
+	status := policy.Status()
+	assert.Equal(t, int64(2100), status.DailySpent)
+	assert.Equal(t, int64(2100), status.WeeklySpent)
+	assert.Equal(t, int64(4), status.Refusals)
+}
+
+func TestMonitorChildFees(t *testing.T) {
+	db := dbm.NewDB("children", dbm.MemDBBackend, "")
+	cache := level.NewKVStore(&db, log.NewNopLogger())
+	chain := fakeBitcoind(t, map[string]interface{}{
+		"getmempoolentry": map[string]interface{}{"spentby": []string{"child"}},
+		"gettransaction":  map[string]interface{}{"fee": -0.00003},
+	}, map[string][]interface{}{})
+	policy := NewSpendPolicy(types.SpendPolicyConfig{DailyBudget: 10000}, chain, cache)
+	txLedger := ledger.NewLedger(dbm.NewMemDB())
+	app := &AnchorBTC{Cache: cache, Chain: chain, Spend: policy, Ledger: txLedger, logger: log.NewNopLogger()}
+	confirmedJSON, _ := json.Marshal(types.TxID{TxID: "parent", AnchorBtcAggRoot: "root"})
+	assert.Nil(t, cache.Append(CONFIRMED_BTC_TX_IDS_KEY, string(confirmedJSON)))
+
+	// a bump paid by a child is charged at its estimate until the wallet has broadcast the child
+	assert.Nil(t, policy.Spend("bump", 1000, func() (string, int64, error) { return "parent", 1000, nil }))
+	assert.Nil(t, app.addChildEstimate(types.BtcTxMsg{BtcTxID: "parent", AnchorBtcAggRoot: "root"}, 1000))
+	app.MonitorChildFees()
+	app.MonitorChildFees()
+
+	assert.Equal(t, int64(3000), policy.Status().DailySpent)
+	entries, err := txLedger.Entries(time.Time{}, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1, "the child is only recorded once")
+	assert.Equal(t, ledger.Entry{Time: entries[0].Time, Kind: ledger.FeeBump, Fee: 3000, TxID: "child", Detail: "parent"}, entries[0])
+}
+
+func TestLocalRate(t *testing.T) {
+	policy := NewSpendPolicy(types.SpendPolicyConfig{}, nil, nil)
+	assert.Equal(t, int64(2000), policy.Rate(2000))
+	policy.SetLocalRate(3000)
+	assert.Equal(t, int64(3000), policy.Rate(2000), "anchors pay the local estimate while the Calendar's rate is stale")
+	policy.SetLocalRate(0)
+	assert.Equal(t, int64(2000), policy.Rate(2000))
+}
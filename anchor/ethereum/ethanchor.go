@@ -0,0 +1,532 @@
+package ethereum
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/anchor"
+	"github.com/chainpoint/chainpoint-core/calendar"
+	"github.com/chainpoint/chainpoint-core/database"
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/events"
+	"github.com/chainpoint/chainpoint-core/leaderelection"
+	"github.com/chainpoint/chainpoint-core/proof"
+	"github.com/chainpoint/chainpoint-core/tendermintrpc"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/util"
+	"github.com/chainpoint/chainpoint-core/webhook"
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// AnchorName : names the engine in the anchor registry and keys its cursor in AnchorState.Anchors
+const AnchorName = "eth"
+
+const CONFIRMED_ETH_TX_IDS_KEY = "ETH_Mon:ConfirmedETHTxIds"
+const CHECK_ETH_TX_IDS_KEY = "ETH_Mon:CheckNewETHTxIds"
+
+// ethTimeout : deadline for a single json-rpc call to the evm node
+const ethTimeout = 30 * time.Second
+
+// ErrAnchorTxFailed : the anchor tx was mined but reverted
+var ErrAnchorTxFailed = errors.New("eth anchor tx failed")
+
+// AnchorETH : anchors the same CAL range root as the bitcoin engine into an evm chain, as the calldata of a tx the
+// anchoring core sends to itself. ETH-A and ETH-C txs carry the BtcTxMsg and BtcMonMsg messages used by BTC-A and BTC-C,
+// with the Btc tx fields holding the evm tx hash and raw tx, and BtcHeadRoot holding the tx hash the anchor attests to.
+type AnchorETH struct {
+	state         *types.AnchorState
+	cursor        *types.AnchorCursor
+	config        types.AnchorConfig
+	tendermintRpc *tendermintrpc.RPC
+	Cache         *level.KVStore
+	Db            database.ChainpointDatabase
+	Client        EthClient
+	privateKey    *ecdsa.PrivateKey
+	chainIDMutex  sync.Mutex
+	chainID       *big.Int
+	headHeight    int64
+	logger        log.Logger
+	webhooks      *webhook.Notifier
+	events        *events.Broker
+}
+
+func NewETHAnchorEngine(state *types.AnchorState, config types.AnchorConfig, tendermintRpc *tendermintrpc.RPC,
+	database *database.ChainpointDatabase, cache *level.KVStore, logger log.Logger, webhooks *webhook.Notifier, broker *events.Broker) (*AnchorETH, error) {
+	client, err := ethclient.Dial(config.EthConfig.EthereumURL)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(config.EthConfig.EthPrivateKey, "0x"))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid eth_private_key: %s", err.Error()))
+	}
+	return &AnchorETH{
+		state:         state,
+		cursor:        state.Cursor(AnchorName),
+		config:        config,
+		tendermintRpc: tendermintRpc,
+		Cache:         cache,
+		Db:            *database,
+		Client:        client,
+		privateKey:    privateKey,
+		logger:        logger,
+		webhooks:      webhooks,
+		events:        broker,
+	}, nil
+}
+
+func (app *AnchorETH) GetTreeFromCalRange(startTxRange int64, endTxRange int64) (types.BtcAgg, error) {
+	txLeaves, err := app.tendermintRpc.GetCalTxRange(startTxRange, endTxRange)
+	app.logger.Info(fmt.Sprintf("Retrieved %d CAL leaves from ranges %d to %d for ETH anchoring", len(txLeaves), startTxRange, endTxRange))
+	if app.LogError(err) != nil {
+		return types.BtcAgg{}, err
+	}
+	return calendar.AggregateAnchorTx(txLeaves), nil
+}
+
+// AnchorToChain : aggregates the CAL txs in range and, if elected, sends their root to the evm chain and announces it with ETH-A
+func (app *AnchorETH) AnchorToChain(startTxRange int64, endTxRange int64) error {
+	if startTxRange == 0 && app.cursor.LatestAnchorTxInt == 0 {
+		return errors.New("eth cursor is seeded by the next BTC-A before the first evm anchor")
+	}
+	iAmLeader, leaderIDs := leaderelection.ElectChainContributorAsLeader(1, []string{}, *app.state)
+	if len(leaderIDs) == 0 {
+		return errors.New("Leader election error")
+	}
+	treeData, err := app.GetTreeFromCalRange(startTxRange, endTxRange)
+	if err != nil {
+		return err
+	}
+	if treeData.AnchorBtcAggRoot == "" {
+		return errors.New("no transactions to aggregate")
+	}
+	app.logger.Info(fmt.Sprintf("ETH anchoring tx ranges %d to %d at Height %d for aggroot: %s, leaders: %v", startTxRange, endTxRange, app.state.Height, treeData.AnchorBtcAggRoot, leaderIDs))
+	if iAmLeader {
+		etha, err := app.SendEthTx(treeData, app.state.Height, startTxRange, endTxRange)
+		if app.LogError(err) == nil {
+			_, err = app.tendermintRpc.BroadcastTx("ETH-A", string(etha), 2, time.Now().Unix(), app.state.ID, app.config.ECPrivateKey)
+			app.LogError(err)
+		}
+	}
+
+	// begin monitoring for anchor
+	failedAnchorCheck := types.AnchorRange{
+		AnchorBtcAggRoot: treeData.AnchorBtcAggRoot,
+		CalBlockHeight:   app.state.Height,
+		BeginCalTxInt:    startTxRange,
+		EndCalTxInt:      endTxRange,
+		AmLeader:         iAmLeader,
+	}
+	failedAnchorJSON, _ := json.Marshal(failedAnchorCheck)
+	if err := app.Cache.Append(CHECK_ETH_TX_IDS_KEY, string(failedAnchorJSON)); app.LogError(err) != nil {
+		return err
+	}
+	// a retried range must not move the cursor back
+	if endTxRange > app.cursor.BeginCalTxInt {
+		app.cursor.BeginCalTxInt = endTxRange
+		app.cursor.EndCalTxInt = endTxRange
+	}
+	app.cursor.LatestAnchorHeight = app.state.Height // So no one will try to re-anchor while the eth tx is pending
+	return nil
+}
+
+// SendEthTx : sends the anchor tx and returns the ETH-A message announcing it
+func (app *AnchorETH) SendEthTx(anchorDataObj types.BtcAgg, height int64, start int64, end int64) ([]byte, error) {
+	root, err := hex.DecodeString(anchorDataObj.AnchorBtcAggRoot)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := app.SignAnchorTx(root)
+	if err != nil {
+		return nil, err
+	}
+	body, err := EncodeAnchorTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ethTimeout)
+	defer cancel()
+	if err := app.Client.SendTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	etha := types.BtcTxMsg{
+		AnchorBtcAggID:   anchorDataObj.AnchorBtcAggID,
+		AnchorBtcAggRoot: anchorDataObj.AnchorBtcAggRoot,
+		BtcTxID:          TxID(tx),
+		BtcTxBody:        body,
+		CalBlockHeight:   height,
+		BeginCalTxInt:    start,
+		EndCalTxInt:      end,
+	}
+	app.logger.Info(fmt.Sprintf("Sending ETH-A: %#v", etha))
+	return json.Marshal(etha)
+}
+
+// SignAnchorTx : signs a tx from the anchoring account to itself with root as its calldata
+func (app *AnchorETH) SignAnchorTx(root []byte) (*ethtypes.Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ethTimeout)
+	defer cancel()
+	chainID, err := app.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	from := crypto.PubkeyToAddress(app.privateKey.PublicKey)
+	nonce, err := app.Client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := app.Client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gas, err := app.Client.EstimateGas(ctx, goethereum.CallMsg{From: from, To: &from, Data: root})
+	if err != nil {
+		return nil, err
+	}
+	tx := ethtypes.NewTransaction(nonce, from, big.NewInt(0), gas, gasPrice, root)
+	return ethtypes.SignTx(tx, ethtypes.NewEIP155Signer(chainID), app.privateKey)
+}
+
+// ChainID : the evm chain id, fetched once
+func (app *AnchorETH) ChainID(ctx context.Context) (*big.Int, error) {
+	app.chainIDMutex.Lock()
+	defer app.chainIDMutex.Unlock()
+	if app.chainID == nil {
+		chainID, err := app.Client.ChainID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		app.chainID = chainID
+	}
+	return app.chainID, nil
+}
+
+// CheckAnchor : confirms an ETH-A message's raw tx hashes to its tx id and carries its root as calldata
+func (app *AnchorETH) CheckAnchor(etha types.BtcTxMsg) error {
+	return CheckAnchorTx(etha)
+}
+
+// CheckAnchorTx : checks an ETH-A message without a connection to the evm chain, so cores not anchoring to it can validate ETH-A txs
+func CheckAnchorTx(etha types.BtcTxMsg) error {
+	tx, err := DecodeAnchorTx(etha.BtcTxBody)
+	if err != nil {
+		return err
+	}
+	if TxID(tx) != strings.ToLower(etha.BtcTxID) {
+		return errors.New(fmt.Sprintf("unable to verify ETH-A: raw tx hashes to %s, not %s", TxID(tx), etha.BtcTxID))
+	}
+	if hex.EncodeToString(tx.Data()) != strings.ToLower(etha.AnchorBtcAggRoot) {
+		return errors.New(fmt.Sprintf("unable to verify ETH-A: tx %s does not anchor %s", etha.BtcTxID, etha.AnchorBtcAggRoot))
+	}
+	return nil
+}
+
+// BeginTxMonitor : Consumes an ETH-A message to initiate monitoring on all nodes
+func (app *AnchorETH) BeginTxMonitor(msgBytes []byte) error {
+	var etha types.BtcTxMsg
+	if err := json.Unmarshal(msgBytes, &etha); err != nil {
+		return app.LogError(err)
+	}
+	if err := app.Cache.Append(CONFIRMED_ETH_TX_IDS_KEY, string(msgBytes)); err != nil {
+		return app.LogError(err)
+	}
+	// end monitoring for failed anchor
+	return app.FindAndRemoveEthCheck(etha.AnchorBtcAggRoot)
+}
+
+// ConfirmAnchor : issues an ETH-C tx for a confirmed anchor tx and adds eth branches to the proofs it anchors
+func (app *AnchorETH) ConfirmAnchor(ethMonObj types.BtcMonMsg) error {
+	app.logger.Info(fmt.Sprintf("Creating ETH-C for %s", ethMonObj.BtcTxID))
+	var hash []byte
+	anchoringCoreID, _ := app.tendermintRpc.GetAnchoringCore(fmt.Sprintf("ETH-A.ETHTX='%s'", ethMonObj.BtcTxID))
+	// the anchoring Core doesn't confirm its own anchor, unless testing with a single validator. It's named in the ETH-C either way
+	excludedCoreIDs := []string{anchoringCoreID}
+	if app.config.ElectionMode == "test" {
+		excludedCoreIDs = []string{}
+	}
+	deadline := time.Now().Add(time.Duration(5) * time.Minute)
+	for !time.Now().After(deadline) {
+		// only start ETH-C leader election process if someone else hasn't
+		if ethMonObj.BtcTxID != app.cursor.LatestConfirmed {
+			amLeader, _ := leaderelection.ElectValidatorAsLeader(1, excludedCoreIDs, *app.state, app.config)
+			if amLeader {
+				ethc, _ := json.Marshal(ethMonObj)
+				result, err := app.tendermintRpc.BroadcastTxWithMeta("ETH-C", string(ethc), 3, time.Now().Unix(), app.state.ID, anchoringCoreID, app.config.ECPrivateKey)
+				app.LogError(err)
+				app.logger.Info(fmt.Sprintf("ETH-C confirmation Hash: %v", result.Hash))
+			}
+		}
+		time.Sleep(70 * time.Second) // wait until next block to query for eth-c
+		hash, _ = app.tendermintRpc.GetConfirmationHeight(fmt.Sprintf("ETH-C.ETHCTX='%s'", ethMonObj.BtcTxID), "ETHCBH")
+		if len(hash) > 0 {
+			break
+		}
+		app.logger.Info(fmt.Sprintf("Restarting confirmation process for %s", ethMonObj.BtcTxID))
+	}
+	if len(hash) == 0 {
+		return app.LogError(errors.New(fmt.Sprintf("ETH-C for %s not found", ethMonObj.BtcTxID)))
+	}
+	etha, err := app.tendermintRpc.GetAnchorTx(fmt.Sprintf("ETH-A.ETHTX='%s'", ethMonObj.BtcTxID))
+	if app.LogError(err) != nil {
+		return err
+	}
+	proofIds, err := app.GenerateEthBatch(etha, ethMonObj, hash)
+	if app.LogError(err) != nil {
+		return err
+	}
+	if len(proofIds) > 0 {
+		app.events.Publish(events.Event{
+			Type:     events.EthC,
+			ProofIDs: proofIds,
+			EthTxID:  ethMonObj.BtcTxID,
+			EthBlock: ethMonObj.BtcHeadHeight,
+		})
+	}
+	return nil
+}
+
+// GenerateEthBatch : adds an eth branch to the stored proof of every hash in the anchored CAL range
+func (app *AnchorETH) GenerateEthBatch(etha types.BtcTxMsg, ethMonObj types.BtcMonMsg, ethcHash []byte) ([]string, error) {
+	ethAgg, txOps, proofType, err := app.anchorTree(etha)
+	if err != nil {
+		return nil, err
+	}
+	anchorObj := anchorObject(app.config.CoreURI, ethcHash, ethMonObj.BtcHeadHeight)
+	proofIds := []string{}
+	proofs := []types.ProofState{}
+	for _, calProof := range ethAgg.ProofData {
+		calProofIds, err := app.Db.GetProofIdsByCalIds([]string{calProof.CalID})
+		if app.LogError(err) != nil {
+			continue
+		}
+		stored, err := app.Db.GetProofsByProofIds(calProofIds)
+		if app.LogError(err) != nil {
+			continue
+		}
+		for _, id := range calProofIds {
+			proofState, exists := stored[id]
+			if !exists {
+				continue
+			}
+			ethProof := proof.Proof()
+			if app.LogError(json.Unmarshal([]byte(proofState.Proof), &ethProof)) != nil {
+				continue
+			}
+			ethProof.AddEthBranch(calProof.Proof, txOps, anchorObj, proofType)
+			proofBytes, err := json.Marshal(ethProof)
+			if app.LogError(err) != nil {
+				continue
+			}
+			proofIds = append(proofIds, id)
+			proofs = append(proofs, types.ProofState{ProofID: id, Proof: string(proofBytes)})
+		}
+	}
+	proofs, err = anchor.StoreProofs(app.Db, AnchorName, proofs)
+	if app.LogError(err) != nil {
+		return nil, err
+	}
+	app.webhooks.Enqueue(proofs, webhook.StageEth)
+	return proofIds, nil
+}
+
+// ConstructProof : rebuilds the eth branch for a CAL tx from the Calendar
+func (app *AnchorETH) ConstructProof(txid string) (proof.P, error) {
+	etha, err := app.tendermintRpc.GetAnchorForCalTx("ETH-A", txid)
+	if app.LogError(err) != nil {
+		return proof.Proof(), err
+	}
+	ethcHash, blockNumber := app.tendermintRpc.GetConfirmationHeight(fmt.Sprintf("ETH-C.ETHCTX='%s'", etha.BtcTxID), "ETHCBH")
+	if blockNumber == 0 {
+		return proof.Proof(), errors.New(fmt.Sprintf("eth anchor %s is not yet confirmed", etha.BtcTxID))
+	}
+	ethAgg, txOps, proofType, err := app.anchorTree(etha)
+	if err != nil {
+		return proof.Proof(), err
+	}
+	for _, calProof := range ethAgg.ProofData {
+		if strings.EqualFold(calProof.CalID, txid) {
+			ethProof := proof.Proof()
+			ethProof.AddEthBranch(calProof.Proof, txOps, anchorObject(app.config.CoreURI, ethcHash, blockNumber), proofType)
+			return ethProof, nil
+		}
+	}
+	return proof.Proof(), errors.New(fmt.Sprintf("cal tx %s not found in eth anchor %s", txid, etha.BtcTxID))
+}
+
+// anchorTree : recomputes the anchored tree of an ETH-A tx, along with the ops from its root to the tx hash
+func (app *AnchorETH) anchorTree(etha types.BtcTxMsg) (types.BtcAgg, []types.ProofLineItem, string, error) {
+	tx, err := DecodeAnchorTx(etha.BtcTxBody)
+	if app.LogError(err) != nil {
+		return types.BtcAgg{}, nil, "", err
+	}
+	txOps, err := AnchorTxOps(etha.BtcTxBody, etha.AnchorBtcAggRoot)
+	if app.LogError(err) != nil {
+		return types.BtcAgg{}, nil, "", err
+	}
+	ethAgg, err := app.GetTreeFromCalRange(etha.BeginCalTxInt, etha.EndCalTxInt)
+	if err != nil {
+		return types.BtcAgg{}, nil, "", err
+	}
+	if ethAgg.AnchorBtcAggRoot != etha.AnchorBtcAggRoot {
+		app.logger.Info(fmt.Sprintf("ETH-A TreeData calculation failure for aggroot: %s, local treeData result was %s", etha.AnchorBtcAggRoot, ethAgg.AnchorBtcAggRoot))
+		return types.BtcAgg{}, nil, "", errors.New("ETH anchoring failure, AggRoot mismatch")
+	}
+	return ethAgg, txOps, ProofType(tx), nil
+}
+
+// anchorObject : the eth anchor, identified by block number, with a uri to the ETH-C tx recording the anchoring tx hash
+func anchorObject(uri string, ethcHash []byte, blockNumber int64) types.AnchorObj {
+	return types.AnchorObj{
+		AnchorID: strconv.FormatInt(blockNumber, 10),
+		Uris:     []string{strings.ToLower(fmt.Sprintf("%s/calendar/%x/data", uri, ethcHash))},
+	}
+}
+
+// AnchorReward : anchoring rewards are paid by the bitcoin engine
+func (app *AnchorETH) AnchorReward(CoreID string) error {
+	return nil
+}
+
+// BlockSyncMonitor : tracks the head of the evm chain
+func (app *AnchorETH) BlockSyncMonitor() {
+	ctx, cancel := context.WithTimeout(context.Background(), ethTimeout)
+	defer cancel()
+	head, err := app.Client.HeaderByNumber(ctx, nil)
+	if app.LogError(err) != nil {
+		return
+	}
+	if previous := atomic.SwapInt64(&app.headHeight, head.Number.Int64()); previous != head.Number.Int64() {
+		app.logger.Info(fmt.Sprintf("New ETH Block %d", head.Number.Int64()))
+	}
+}
+
+// Confirmation : the ETH-C message for an anchor tx, once it is buried under the configured number of blocks
+func (app *AnchorETH) Confirmation(etha types.BtcTxMsg) (types.BtcMonMsg, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ethTimeout)
+	defer cancel()
+	receipt, err := app.Client.TransactionReceipt(ctx, common.HexToHash(etha.BtcTxID))
+	if err == goethereum.NotFound {
+		return types.BtcMonMsg{}, false, nil
+	}
+	if err != nil {
+		return types.BtcMonMsg{}, false, err
+	}
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		return types.BtcMonMsg{}, false, ErrAnchorTxFailed
+	}
+	blockNumber := receipt.BlockNumber.Int64()
+	if atomic.LoadInt64(&app.headHeight)-blockNumber+1 < app.config.EthConfig.Confirmations {
+		return types.BtcMonMsg{}, false, nil
+	}
+	return types.BtcMonMsg{
+		BtcTxID:       etha.BtcTxID,
+		BtcHeadHeight: blockNumber,
+		BtcHeadRoot:   etha.BtcTxID,
+	}, true, nil
+}
+
+// MonitorConfirmedTx : Begins anchor confirmation once an anchor tx has enough confirmations
+func (app *AnchorETH) MonitorConfirmedTx() {
+	results, err := app.Cache.GetArray(CONFIRMED_ETH_TX_IDS_KEY)
+	if app.LogError(err) != nil {
+		return
+	}
+	for _, s := range results {
+		var etha types.BtcTxMsg
+		if app.LogError(json.Unmarshal([]byte(s), &etha)) != nil {
+			app.LogError(app.Cache.Del(CONFIRMED_ETH_TX_IDS_KEY, s))
+			continue
+		}
+		ethMonObj, confirmed, err := app.Confirmation(etha)
+		if err == ErrAnchorTxFailed {
+			app.logger.Info(fmt.Sprintf("eth tx %s failed, re-anchoring cal range %d to %d", etha.BtcTxID, etha.BeginCalTxInt, etha.EndCalTxInt))
+			app.LogError(app.Cache.Del(CONFIRMED_ETH_TX_IDS_KEY, s))
+			app.retryAnchor(etha.BeginCalTxInt, etha.EndCalTxInt)
+			continue
+		}
+		if app.LogError(err) != nil || !confirmed {
+			continue
+		}
+		if app.LogError(app.Cache.Del(CONFIRMED_ETH_TX_IDS_KEY, s)) != nil {
+			continue
+		}
+		go app.ConfirmAnchor(ethMonObj)
+	}
+}
+
+// MonitorFailedAnchor : ensures an ETH-A is seen for every anchored range within 10 Calendar blocks
+func (app *AnchorETH) MonitorFailedAnchor() {
+	checkResults, err := app.Cache.GetArray(CHECK_ETH_TX_IDS_KEY)
+	if app.LogError(err) != nil {
+		return
+	}
+	for _, s := range checkResults {
+		var anchorRange types.AnchorRange
+		if app.LogError(json.Unmarshal([]byte(s), &anchorRange)) != nil {
+			continue
+		}
+		if app.state.Height-anchorRange.CalBlockHeight > 10 {
+			app.logger.Info("ETH anchoring timeout while waiting for ETH-A", "AnchorBtcAggRoot", anchorRange.AnchorBtcAggRoot)
+			app.LogError(app.Cache.Del(CHECK_ETH_TX_IDS_KEY, s))
+			app.retryAnchor(anchorRange.BeginCalTxInt, anchorRange.EndCalTxInt)
+		}
+	}
+}
+
+// retryAnchor : re-anchors just a failed range if later anchors have moved past it, else restarts the anchor epoch from it
+func (app *AnchorETH) retryAnchor(startTxRange int64, endTxRange int64) {
+	if app.cursor.BeginCalTxInt > endTxRange {
+		go app.AnchorToChain(startTxRange, endTxRange)
+	} else {
+		app.ResetAnchor(startTxRange)
+	}
+}
+
+// ResetAnchor ensures that eth anchoring will begin again in the next block
+func (app *AnchorETH) ResetAnchor(startTxRange int64) {
+	app.logger.Info(fmt.Sprintf("ETH anchoring failure, restarting anchor epoch from tx %d", startTxRange))
+	app.cursor.BeginCalTxInt = startTxRange
+	app.cursor.LatestAnchorHeight = -1
+}
+
+// FindAndRemoveEthCheck : stop checking for the ETH-A of a root once it has been seen
+func (app *AnchorETH) FindAndRemoveEthCheck(aggRoot string) error {
+	checkResults, err := app.Cache.GetArray(CHECK_ETH_TX_IDS_KEY)
+	if app.LogError(err) != nil {
+		return err
+	}
+	for _, s := range checkResults {
+		var anchorRange types.AnchorRange
+		if app.LogError(json.Unmarshal([]byte(s), &anchorRange)) != nil {
+			continue
+		}
+		if anchorRange.AnchorBtcAggRoot == aggRoot {
+			if err := app.Cache.Del(CHECK_ETH_TX_IDS_KEY, s); app.LogError(err) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (app *AnchorETH) LogError(err error) error {
+	if err != nil {
+		app.logger.Error(fmt.Sprintf("Error in %s: %s", util.GetCurrentFuncName(2), err.Error()))
+	}
+	return err
+}
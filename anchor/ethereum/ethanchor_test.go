@@ -0,0 +1,231 @@
+package ethereum
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/proof"
+	"github.com/chainpoint/chainpoint-core/types"
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// devKey : the first prefunded anvil account, funded from the dev account when testing against geth --dev
+const devKey = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+type fakeClient struct {
+	sent     []*ethtypes.Transaction
+	receipts map[common.Hash]*ethtypes.Receipt
+	head     int64
+}
+
+func (c *fakeClient) ChainID(ctx context.Context) (*big.Int, error) { return big.NewInt(1337), nil }
+func (c *fakeClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return uint64(len(c.sent)), nil
+}
+func (c *fakeClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1e9), nil
+}
+func (c *fakeClient) EstimateGas(ctx context.Context, msg goethereum.CallMsg) (uint64, error) {
+	return 21000 + 16*uint64(len(msg.Data)), nil
+}
+func (c *fakeClient) SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error {
+	c.sent = append(c.sent, tx)
+	return nil
+}
+func (c *fakeClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error) {
+	if receipt, exists := c.receipts[txHash]; exists {
+		return receipt, nil
+	}
+	return nil, goethereum.NotFound
+}
+func (c *fakeClient) HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error) {
+	return &ethtypes.Header{Number: big.NewInt(c.head)}, nil
+}
+
+func testEngine(t *testing.T, client EthClient, confirmations int64) *AnchorETH {
+	privateKey, err := crypto.HexToECDSA(devKey)
+	assert.Nil(t, err)
+	state := &types.AnchorState{}
+	return &AnchorETH{
+		state:      state,
+		cursor:     state.AddCursor(AnchorName),
+		config:     types.AnchorConfig{EthConfig: types.EthConfig{Confirmations: confirmations}},
+		Client:     client,
+		privateKey: privateKey,
+		logger:     log.NewNopLogger(),
+	}
+}
+
+func randomRoot() string {
+	root := make([]byte, 32)
+	rand.Read(root)
+	return hex.EncodeToString(root)
+}
+
+// assertProvesTxHash : replays an eth branch from the anchored root and checks it ends at the tx hash
+func assertProvesTxHash(t *testing.T, etha types.BtcTxMsg) {
+	tx, err := DecodeAnchorTx(etha.BtcTxBody)
+	assert.Nil(t, err)
+	txOps, err := AnchorTxOps(etha.BtcTxBody, etha.AnchorBtcAggRoot)
+	assert.Nil(t, err)
+	p := proof.Proof()
+	p["hash"] = etha.AnchorBtcAggRoot
+	p.AddEthBranch(nil, txOps, types.AnchorObj{AnchorID: "1"}, ProofType(tx))
+	verdicts, err := proof.Verify(p, nil)
+	assert.Nil(t, err)
+	assert.Len(t, verdicts, 1)
+	assert.Equal(t, "eth_anchor_branch", verdicts[0].Label)
+	assert.Equal(t, etha.BtcTxID, verdicts[0].ExpectedValue)
+}
+
+func TestSendEthTxAnchorsRoot(t *testing.T) {
+	client := &fakeClient{}
+	engine := testEngine(t, client, 12)
+	root := randomRoot()
+	ethaBytes, err := engine.SendEthTx(types.BtcAgg{AnchorBtcAggID: "id", AnchorBtcAggRoot: root}, 7, 10, 20)
+	assert.Nil(t, err)
+	etha := types.BtcTxMsg{}
+	assert.Nil(t, json.Unmarshal(ethaBytes, &etha))
+
+	assert.Len(t, client.sent, 1)
+	assert.Equal(t, TxID(client.sent[0]), etha.BtcTxID)
+	assert.Equal(t, root, hex.EncodeToString(client.sent[0].Data()))
+	assert.Equal(t, crypto.PubkeyToAddress(engine.privateKey.PublicKey), *client.sent[0].To())
+	assert.Equal(t, "teth", ProofType(client.sent[0]))
+	assert.Equal(t, int64(10), etha.BeginCalTxInt)
+	assert.Equal(t, int64(20), etha.EndCalTxInt)
+	assert.Nil(t, engine.CheckAnchor(etha))
+	assertProvesTxHash(t, etha)
+}
+
+func TestCheckAnchorTxRejectsMismatches(t *testing.T) {
+	engine := testEngine(t, &fakeClient{}, 12)
+	ethaBytes, err := engine.SendEthTx(types.BtcAgg{AnchorBtcAggRoot: randomRoot()}, 1, 0, 1)
+	assert.Nil(t, err)
+	etha := types.BtcTxMsg{}
+	assert.Nil(t, json.Unmarshal(ethaBytes, &etha))
+
+	wrongRoot := etha
+	wrongRoot.AnchorBtcAggRoot = randomRoot()
+	assert.Contains(t, CheckAnchorTx(wrongRoot).Error(), "does not anchor")
+
+	wrongID := etha
+	wrongID.BtcTxID = randomRoot()
+	assert.Contains(t, CheckAnchorTx(wrongID).Error(), "raw tx hashes to")
+
+	wrongBody := etha
+	wrongBody.BtcTxBody = "zz"
+	assert.NotNil(t, CheckAnchorTx(wrongBody))
+}
+
+func TestAnchorTxOpsSkipsUnalignedMatches(t *testing.T) {
+	ops, err := AnchorTxOps("1ab0ab00", "ab")
+	assert.Nil(t, err)
+	assert.Equal(t, []types.ProofLineItem{{Left: "1ab0"}, {Right: "00"}, {Op: "keccak-256"}}, ops)
+	_, err = AnchorTxOps("1ab0", "ab")
+	assert.NotNil(t, err)
+}
+
+func TestConfirmationWaitsForConfirmations(t *testing.T) {
+	client := &fakeClient{receipts: map[common.Hash]*ethtypes.Receipt{}}
+	engine := testEngine(t, client, 12)
+	etha := types.BtcTxMsg{BtcTxID: randomRoot()}
+
+	_, confirmed, err := engine.Confirmation(etha)
+	assert.Nil(t, err)
+	assert.False(t, confirmed)
+
+	client.receipts[common.HexToHash(etha.BtcTxID)] = &ethtypes.Receipt{Status: ethtypes.ReceiptStatusSuccessful, BlockNumber: big.NewInt(100)}
+	client.head = 110
+	engine.BlockSyncMonitor()
+	_, confirmed, err = engine.Confirmation(etha)
+	assert.Nil(t, err)
+	assert.False(t, confirmed)
+
+	client.head = 111
+	engine.BlockSyncMonitor()
+	ethc, confirmed, err := engine.Confirmation(etha)
+	assert.Nil(t, err)
+	assert.True(t, confirmed)
+	assert.Equal(t, types.BtcMonMsg{BtcTxID: etha.BtcTxID, BtcHeadHeight: 100, BtcHeadRoot: etha.BtcTxID}, ethc)
+
+	client.receipts[common.HexToHash(etha.BtcTxID)].Status = ethtypes.ReceiptStatusFailed
+	_, _, err = engine.Confirmation(etha)
+	assert.Equal(t, ErrAnchorTxFailed, err)
+}
+
+// TestDevChainAnchor : anchors to a local dev chain, e.g. `anvil` or `geth --dev --http`, when ETH_DEV_URL is set
+func TestDevChainAnchor(t *testing.T) {
+	url := os.Getenv("ETH_DEV_URL")
+	if url == "" {
+		t.Skip("ETH_DEV_URL not set")
+	}
+	rpcClient, err := rpc.Dial(url)
+	assert.Nil(t, err)
+	engine := testEngine(t, ethclient.NewClient(rpcClient), 1)
+	fundDevAccount(t, rpcClient, crypto.PubkeyToAddress(engine.privateKey.PublicKey))
+
+	ethaBytes, err := engine.SendEthTx(types.BtcAgg{AnchorBtcAggRoot: randomRoot()}, 1, 0, 1)
+	assert.Nil(t, err)
+	etha := types.BtcTxMsg{}
+	assert.Nil(t, json.Unmarshal(ethaBytes, &etha))
+	assert.Nil(t, CheckAnchorTx(etha))
+	assertProvesTxHash(t, etha)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		engine.BlockSyncMonitor()
+		ethc, confirmed, err := engine.Confirmation(etha)
+		assert.Nil(t, err)
+		if confirmed {
+			assert.Equal(t, etha.BtcTxID, ethc.BtcHeadRoot)
+			assert.True(t, ethc.BtcHeadHeight > 0)
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatal("anchor tx was not mined")
+}
+
+// fundDevAccount : sends ether from the node's unlocked dev account when the anchoring account has none, as with geth --dev
+func fundDevAccount(t *testing.T, rpcClient *rpc.Client, account common.Address) {
+	ctx := context.Background()
+	balance, err := ethclient.NewClient(rpcClient).BalanceAt(ctx, account, nil)
+	assert.Nil(t, err)
+	if balance.Sign() > 0 {
+		return
+	}
+	var accounts []common.Address
+	assert.Nil(t, rpcClient.CallContext(ctx, &accounts, "eth_accounts"))
+	if len(accounts) == 0 {
+		t.Fatal("dev chain has no unlocked account to fund the anchoring account from")
+	}
+	var txHash common.Hash
+	value := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	assert.Nil(t, rpcClient.CallContext(ctx, &txHash, "eth_sendTransaction", map[string]interface{}{
+		"from":  accounts[0],
+		"to":    account,
+		"value": (*hexutil.Big)(value),
+	}))
+	for i := 0; i < 60; i++ {
+		if balance, err := ethclient.NewClient(rpcClient).BalanceAt(ctx, account, nil); err == nil && balance.Sign() > 0 {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatal("anchoring account was not funded")
+}
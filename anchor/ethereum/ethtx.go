@@ -0,0 +1,82 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	goethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// EthClient : the json-rpc calls the engine makes, satisfied by *ethclient.Client
+type EthClient interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, msg goethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error)
+}
+
+// TxID : the tx hash as lowercase hex without a 0x prefix, the form used in ETH-A/ETH-C txs and proofs
+func TxID(tx *ethtypes.Transaction) string {
+	return hex.EncodeToString(tx.Hash().Bytes())
+}
+
+// EncodeAnchorTx : the hex encoded raw signed tx, whose keccak-256 hash is the tx hash
+func EncodeAnchorTx(tx *ethtypes.Transaction) (string, error) {
+	raw, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// DecodeAnchorTx : decodes a tx encoded by EncodeAnchorTx
+func DecodeAnchorTx(body string) (*ethtypes.Transaction, error) {
+	raw, err := hex.DecodeString(body)
+	if err != nil {
+		return nil, err
+	}
+	tx := new(ethtypes.Transaction)
+	if err := rlp.DecodeBytes(raw, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// AnchorTxOps : the ops leading from an anchored root to the hash of the raw tx carrying it as calldata
+func AnchorTxOps(body string, root string) ([]types.ProofLineItem, error) {
+	body, root = strings.ToLower(body), strings.ToLower(root)
+	for offset := 0; ; {
+		i := strings.Index(body[offset:], root)
+		if i < 0 || root == "" {
+			return nil, errors.New(fmt.Sprintf("root %s not found in eth tx", root))
+		}
+		// only a match on a byte boundary is the root itself
+		if (offset+i)%2 == 0 {
+			return []types.ProofLineItem{
+				{Left: body[:offset+i]},
+				{Right: body[offset+i+len(root):]},
+				{Op: "keccak-256"},
+			}, nil
+		}
+		offset += i + 1
+	}
+}
+
+// ProofType : the anchor type of a tx, eth for ethereum mainnet and teth for any other evm chain
+func ProofType(tx *ethtypes.Transaction) string {
+	if tx.ChainId().Cmp(big.NewInt(1)) == 0 {
+		return "eth"
+	}
+	return "teth"
+}
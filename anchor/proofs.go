@@ -0,0 +1,70 @@
+package anchor
+
+import (
+	"encoding/json"
+
+	"github.com/chainpoint/chainpoint-core/database"
+	"github.com/chainpoint/chainpoint-core/proof"
+	"github.com/chainpoint/chainpoint-core/types"
+)
+
+// StoreProofs : stores the proofs built by an engine other than btc apart from the Core's proofs, so that engines never
+// overwrite each other's branches. Returns the proofs merged with the Core's stored proofs, as ProofHandler would serve them
+func StoreProofs(db database.ChainpointDatabase, anchorName string, proofs []types.ProofState) ([]types.ProofState, error) {
+	if err := db.BulkInsertAnchorProofs(anchorName, proofs); err != nil {
+		return nil, err
+	}
+	proofIds := make([]string, 0, len(proofs))
+	for _, proofState := range proofs {
+		proofIds = append(proofIds, proofState.ProofID)
+	}
+	stored, err := db.GetProofsByProofIds(proofIds)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := MergeProofs(db, stored)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]types.ProofState, 0, len(proofs))
+	for _, proofState := range proofs {
+		if mergedState, exists := merged[proofState.ProofID]; exists {
+			proofState = mergedState
+		}
+		results = append(results, proofState)
+	}
+	return results, nil
+}
+
+// MergeProofs : adds the anchor branches stored by every engine other than btc to the Core's proofs
+func MergeProofs(db database.ChainpointDatabase, proofs map[string]types.ProofState) (map[string]types.ProofState, error) {
+	proofIds := make([]string, 0, len(proofs))
+	for id := range proofs {
+		proofIds = append(proofIds, id)
+	}
+	anchorProofs, err := db.GetAnchorProofsByProofIds(proofIds)
+	if err != nil {
+		return proofs, err
+	}
+	merged := make(map[string]types.ProofState, len(proofs))
+	for id, proofState := range proofs {
+		merged[id] = proofState
+		if len(anchorProofs[id]) == 0 {
+			continue
+		}
+		base := proof.Proof()
+		if json.Unmarshal([]byte(proofState.Proof), &base) != nil {
+			continue
+		}
+		for _, anchorState := range anchorProofs[id] {
+			anchorProof := proof.Proof()
+			if json.Unmarshal([]byte(anchorState.Proof), &anchorProof) == nil {
+				base.MergeAnchorBranches(anchorProof)
+			}
+		}
+		if proofBytes, err := json.Marshal(base); err == nil {
+			merged[id] = types.ProofState{ProofID: id, Proof: string(proofBytes)}
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,68 @@
+package anchor
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/proof"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func proofWithAnchors(labels ...string) types.ProofState {
+	anchorBranches := []proof.P{}
+	for _, label := range labels {
+		anchorBranches = append(anchorBranches, proof.P{"label": label, "ops": []interface{}{}})
+	}
+	calBranch := proof.P{"label": "cal_anchor_branch", "ops": []interface{}{}}
+	if len(anchorBranches) > 0 {
+		calBranch["branches"] = anchorBranches
+	}
+	proofBytes, _ := json.Marshal(proof.P{"proof_id": "p1", "branches": []proof.P{calBranch}})
+	return types.ProofState{ProofID: "p1", Proof: string(proofBytes)}
+}
+
+func anchorLabels(t *testing.T, proofState types.ProofState) []interface{} {
+	p := proof.Proof()
+	assert.Nil(t, json.Unmarshal([]byte(proofState.Proof), &p))
+	labels := []interface{}{}
+	for _, branch := range p.AnchorBranches() {
+		labels = append(labels, branch["label"])
+	}
+	return labels
+}
+
+func TestEngineProofsSurviveBtcProofGeneration(t *testing.T) {
+	var memDb dbm.DB = dbm.NewMemDB()
+	db := level.NewDB(level.NewKVStore(&memDb, log.NewNopLogger()), types.RetentionPolicy{})
+	assert.Nil(t, db.BulkInsertProofs([]types.ProofState{proofWithAnchors()}))
+
+	// the eth anchor confirms first, and is delivered merged with the cal proof
+	stored, err := StoreProofs(db, "eth", []types.ProofState{proofWithAnchors("eth_anchor_branch")})
+	assert.Nil(t, err)
+	assert.Len(t, stored, 1)
+	assert.Equal(t, []interface{}{"eth_anchor_branch"}, anchorLabels(t, stored[0]))
+
+	// btc proof generation then replaces the Core's proof without knowing about eth
+	assert.Nil(t, db.BulkInsertProofs([]types.ProofState{proofWithAnchors("btc_anchor_branch")}))
+	proofs, err := db.GetProofsByProofIds([]string{"p1"})
+	assert.Nil(t, err)
+	merged, err := MergeProofs(db, proofs)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"btc_anchor_branch", "eth_anchor_branch"}, anchorLabels(t, merged["p1"]))
+}
+
+func TestRegistryReplacesEnginesInPlace(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("btc", 60, false, nil)
+	registry.Register("eth", 10, true, nil)
+	registry.Register("btc", 30, true, nil)
+	engines := registry.Engines()
+	assert.Len(t, engines, 2)
+	assert.Equal(t, Registration{Name: "btc", Interval: 30, Active: true}, engines[0])
+	assert.Equal(t, "eth", engines[1].Name)
+	assert.Nil(t, registry.Get("ltc"))
+}
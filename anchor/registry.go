@@ -0,0 +1,46 @@
+package anchor
+
+// Registration : an anchor engine and how often it anchors the Calendar
+type Registration struct {
+	Name     string // the chain anchored to, which also keys the engine's cursor in AnchorState.Anchors
+	Interval int64  // Calendar blocks between anchors
+	Active   bool   // false if this Core only follows the chain, without anchoring or monitoring anchors
+	AnchorEngine
+}
+
+// Registry : the anchor engines run by a Core, in the order they were registered
+type Registry struct {
+	engines []Registration
+}
+
+// NewRegistry : creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{engines: []Registration{}}
+}
+
+// Register : adds an engine, replacing any engine already registered under the same name
+func (r *Registry) Register(name string, interval int64, active bool, engine AnchorEngine) {
+	registration := Registration{Name: name, Interval: interval, Active: active, AnchorEngine: engine}
+	for i, existing := range r.engines {
+		if existing.Name == name {
+			r.engines[i] = registration
+			return
+		}
+	}
+	r.engines = append(r.engines, registration)
+}
+
+// Get : the engine registered under name, or nil
+func (r *Registry) Get(name string) AnchorEngine {
+	for _, registration := range r.engines {
+		if registration.Name == name {
+			return registration.AnchorEngine
+		}
+	}
+	return nil
+}
+
+// Engines : every registered engine
+func (r *Registry) Engines() []Registration {
+	return r.engines
+}
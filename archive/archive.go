@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/util"
+)
+
+// proofDir : directory inside the archive holding one <proof_id>.json file per proof
+const proofDir = "proofs/"
+
+const manifestName = "manifest.json"
+
+// maxProofIds : proof ids requested from the database at a time
+const maxProofIds = 250
+
+// importBatchSize : proofs handed to ImportProofs at a time
+const importBatchSize = 500
+
+// Manifest : summary written as the last entry of an archive
+type Manifest struct {
+	Created    string   `json:"created"`
+	BtcTxIds   []string `json:"btctx_ids"`
+	ProofCount int      `json:"proof_count"`
+}
+
+// Export : streams every btc-anchored proof belonging to the given bitcoin transactions to w as a gzipped tar
+func Export(db database.ChainpointDatabase, btcTxIds []string, w io.Writer) (Manifest, error) {
+	manifest := Manifest{
+		Created:  time.Now().UTC().Format(time.RFC3339),
+		BtcTxIds: btcTxIds,
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, btcTxId := range btcTxIds {
+		proofIds, err := db.GetProofIdsByBtcTxId(btcTxId)
+		if err != nil {
+			return manifest, err
+		}
+		proofIds = util.UniquifyStrings(proofIds)
+		for start := 0; start < len(proofIds); start += maxProofIds {
+			end := start + maxProofIds
+			if end > len(proofIds) {
+				end = len(proofIds)
+			}
+			proofs, err := db.GetProofsByProofIds(proofIds[start:end])
+			if err != nil {
+				return manifest, err
+			}
+			for _, id := range proofIds[start:end] {
+				proof, exists := proofs[id]
+				if !exists || !strings.Contains(proof.Proof, "btc_anchor_branch") {
+					continue
+				}
+				if err := writeEntry(tw, proofDir+id+".json", []byte(proof.Proof)); err != nil {
+					return manifest, err
+				}
+				manifest.ProofCount++
+			}
+		}
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeEntry(tw, manifestName, manifestBytes); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// Import : restores the proofs in an archive produced by Export, returning the number of proofs read. Restored proofs are
+// not pruned again after the proof TTL
+func Import(db database.ChainpointDatabase, r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	batch := []types.ProofState{}
+	count := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasPrefix(header.Name, proofDir) || path.Ext(header.Name) != ".json" {
+			continue
+		}
+		proofBytes, err := io.ReadAll(tr)
+		if err != nil {
+			return count, err
+		}
+		if !json.Valid(proofBytes) {
+			return count, errors.New("invalid proof in archive: " + header.Name)
+		}
+		proofId := strings.TrimSuffix(path.Base(header.Name), ".json")
+		batch = append(batch, types.ProofState{ProofID: proofId, Proof: string(proofBytes)})
+		if len(batch) >= importBatchSize {
+			if err := db.ImportProofs(batch); err != nil {
+				return count, err
+			}
+			count += len(batch)
+			batch = []types.ProofState{}
+		}
+	}
+	if len(batch) > 0 {
+		if err := db.ImportProofs(batch); err != nil {
+			return count, err
+		}
+		count += len(batch)
+	}
+	return count, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func newTestDB() *level.Chainpoint_DB {
+	var memDb dbm.DB = dbm.NewMemDB()
+	return level.NewDB(level.NewKVStore(&memDb, log.NewNopLogger()), types.RetentionPolicy{})
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	source := newTestDB()
+	assert.Nil(source.BulkInsertBtcTxState([]types.AnchorBtcTxState{{AnchorBtcAggId: "btcagg", BtcTxId: "btctx"}}))
+	assert.Nil(source.BulkInsertBtcAggState([]types.AnchorBtcAggState{{CalId: "cal", AnchorBtcAggId: "btcagg"}}))
+	assert.Nil(source.BulkInsertCalState([]types.CalStateObject{{AggID: "agg", CalId: "cal"}}))
+	assert.Nil(source.BulkInsertAggState([]types.AggState{{ProofID: "anchored", AggID: "agg"}, {ProofID: "pending", AggID: "agg"}}))
+	anchored := `{"proof_id":"anchored","branches":[{"label":"cal_anchor_branch","branches":[{"label":"btc_anchor_branch"}]}]}`
+	assert.Nil(source.BulkInsertProofs([]types.ProofState{
+		{ProofID: "anchored", Proof: anchored},
+		{ProofID: "pending", Proof: `{"proof_id":"pending","branches":[{"label":"cal_anchor_branch"}]}`},
+	}))
+
+	var buf bytes.Buffer
+	manifest, err := Export(source, []string{"btctx"}, &buf)
+	assert.Nil(err)
+	assert.Equal(1, manifest.ProofCount)
+
+	destination := newTestDB()
+	count, err := Import(destination, &buf)
+	assert.Nil(err)
+	assert.Equal(1, count)
+	proofs, err := destination.GetProofsByProofIds([]string{"anchored"})
+	assert.Nil(err)
+	assert.Equal(anchored, proofs["anchored"].Proof)
+}
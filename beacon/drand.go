@@ -1,20 +1,58 @@
 package beacon
 
 import (
-	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/drand/drand/key"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
-	"github.com/drand/drand/client"
-	"github.com/drand/drand/client/grpc"
+	"github.com/drand/drand/chain"
+	"github.com/drand/drand/key"
+	"github.com/drand/kyber"
 )
 
+// LeagueOfEntropyPublicKey : the distributed public key of the League of Entropy mainnet chain served at /public/latest
+const LeagueOfEntropyPublicKey = "868f005eb8e6e4ca0a47c8a77ceaa5309a47978a7c71bc5cce96366b5d7a569937c529eeda66c7293784a9402801af31"
+
+// LeagueOfEntropyChainHash : the chain hash of the League of Entropy mainnet chain, which identifies it at any drand api
+const LeagueOfEntropyChainHash = "8990e7a9aaed2ffed73dbd7092123d6f289930540d7651336225dc172e51b2ce"
+
+// Network : the drand chain whose rounds a Calendar accepts. Every Core verifies DRAND txs against the same Network, set in
+// genesis, so they all reach the same verdict whatever beacons they poll
+type Network struct {
+	PublicKey string `json:"public_key"`
+	ChainHash string `json:"chain_hash"`
+}
+
+// LeagueOfEntropy : the League of Entropy mainnet chain, used by Calendars whose genesis names no other
+var LeagueOfEntropy = Network{PublicKey: LeagueOfEntropyPublicKey, ChainHash: LeagueOfEntropyChainHash}
+
+// Verify : checks that round was signed by the network's group
+func (n Network) Verify(round Round) error {
+	publicKey, err := ParsePublicKey(n.PublicKey)
+	if err != nil {
+		return err
+	}
+	return round.Verify(publicKey)
+}
+
+// Beacons : drand http apis at urls, pinned to the network's public key
+func (n Network) Beacons(urls []string) ([]Beacon, error) {
+	publicKey, err := ParsePublicKey(n.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	beacons := make([]Beacon, 0, len(urls))
+	for _, beaconURL := range urls {
+		beacons = append(beacons, NewHTTPBeacon(beaconURL, publicKey))
+	}
+	return beacons, nil
+}
+
+// Round : a drand beacon round as returned by the drand HTTP api
 type Round struct {
 	Round             uint64 `json:"round"`
 	Randomness        string `json:"randomness"`
@@ -22,78 +60,112 @@ type Round struct {
 	PreviousSignature string `json:"previous_signature"`
 }
 
-func GetCloudflareRandomness() (uint64, string, error) {
-	var httpClient = &http.Client{Timeout: 10 * time.Second}
-	resp, err := httpClient.Get("https://drand.cloudflare.com/public/latest")
+// Beacon : a source of public randomness. Rounds are verified before they are returned
+type Beacon interface {
+	LatestRound() (Round, error)
+	// Verify checks that a round was signed by the beacon's group
+	Verify(round Round) error
+	// String identifies the beacon in proofs, e.g. by url
+	String() string
+}
+
+// Verify : checks the round's BLS signature against the group public key, and that its randomness is derived from the signature
+func (r Round) Verify(publicKey kyber.Point) error {
+	signature, err := hex.DecodeString(r.Signature)
 	if err != nil {
-		return 0, "", err
+		return fmt.Errorf("drand: round %d has a malformed signature: %s", r.Round, err)
 	}
-	defer resp.Body.Close()
-	round := Round{}
-	err = json.NewDecoder(resp.Body).Decode(&round)
+	previousSignature, err := hex.DecodeString(r.PreviousSignature)
 	if err != nil {
-		return 0, "", err
+		return fmt.Errorf("drand: round %d has a malformed previous signature: %s", r.Round, err)
+	}
+	if err := chain.Verify(publicKey, previousSignature, signature, r.Round); err != nil {
+		return fmt.Errorf("drand: round %d failed signature verification: %s", r.Round, err)
 	}
-	return round.Round, round.Randomness, nil
+	if hex.EncodeToString(chain.RandomnessFromSignature(signature)) != strings.ToLower(r.Randomness) {
+		return fmt.Errorf("drand: round %d randomness does not match its signature", r.Round)
+	}
+	return nil
 }
 
-func GetPublicRandomness() (uint64, string, error) {
-	certPath := ""
-	ids, err := getNodes()
-	if err != nil {
-		return 0, "", nil
+// HTTPBeacon : a drand HTTP api such as https://api.drand.sh, verified against a pinned group public key
+type HTTPBeacon struct {
+	URL       string
+	PublicKey kyber.Point
+	Client    *http.Client
+}
+
+// NewHTTPBeacon : creates a beacon for the drand api at beaconURL
+func NewHTTPBeacon(beaconURL string, publicKey kyber.Point) *HTTPBeacon {
+	return &HTTPBeacon{
+		URL:       strings.TrimRight(beaconURL, "/"),
+		PublicKey: publicKey,
+		Client:    &http.Client{Timeout: 10 * time.Second},
 	}
-	group, err := getGroup()
+}
+
+// LatestRound : fetches and verifies the latest round
+func (b *HTTPBeacon) LatestRound() (Round, error) {
+	resp, err := b.Client.Get(b.URL + "/public/latest")
 	if err != nil {
-		return 0, "", nil
+		return Round{}, err
 	}
-	if group.PublicKey == nil {
-		return 0, "", errors.New("drand: group file must contain the distributed public key")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Round{}, fmt.Errorf("drand: %s responded with status %d", b.URL, resp.StatusCode)
+	}
+	round := Round{}
+	if err := json.NewDecoder(resp.Body).Decode(&round); err != nil {
+		return Round{}, err
 	}
+	if err := b.Verify(round); err != nil {
+		return Round{}, fmt.Errorf("%s (from %s)", err, b.URL)
+	}
+	return round, nil
+}
 
-	var resp client.Result
-	var foundCorrect bool
-	for _, id := range ids {
-		cli, err := grpc.New(id.Addr, certPath, !id.TLS)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "drand: could not connect to %s: %s", id.Addr, err)
-			break
-		}
+// Verify : checks round against the pinned group public key
+func (b *HTTPBeacon) Verify(round Round) error {
+	return round.Verify(b.PublicKey)
+}
 
-		resp, err = cli.Get(context.Background(), 0)
+func (b *HTTPBeacon) String() string {
+	return b.URL
+}
 
+// LatestRound : tries each beacon in order, returning the first verified round and the beacon it came from
+func LatestRound(beacons []Beacon) (Round, Beacon, error) {
+	err := errors.New("drand: no beacons configured")
+	for _, b := range beacons {
+		var round Round
+		round, err = b.LatestRound()
 		if err == nil {
-			foundCorrect = true
-			break
+			return round, b, nil
 		}
-		fmt.Fprintf(os.Stderr, "drand: could not get public randomness from %s: %s", id.Addr, err)
 	}
-	if !foundCorrect {
-		return 0, "", errors.New("drand: could not verify randomness")
-	}
-	return resp.Round(), hex.EncodeToString(resp.Randomness()), nil
+	return Round{}, nil, err
 }
 
-func getNodes() ([]*key.Node, error) {
-	group, err := getGroup()
-	if err != nil {
-		return nil, err
-	}
-	var ids []*key.Node
-	gids := group.Nodes
-	ids = gids
-	if len(ids) == 0 {
-		return nil, errors.New("no nodes specified with --nodes are in the group file")
+// VerifyRound : accepts a round verified by any of the beacons
+func VerifyRound(beacons []Beacon, round Round) error {
+	err := errors.New("drand: no beacons configured")
+	for _, b := range beacons {
+		if err = b.Verify(round); err == nil {
+			return nil
+		}
 	}
-	return ids, nil
+	return err
 }
 
-//TODO: load group path dynamically
-func getGroup() (*key.Group, error) {
-	g := &key.Group{}
-	groupPath := "/go/src/github.com/chainpoint/chainpoint-core/group.toml"
-	if err := key.Load(groupPath, g); err != nil {
-		return nil, fmt.Errorf("drand: error loading group file: %s", err)
+// ParsePublicKey : decodes a hex encoded drand group public key
+func ParsePublicKey(hexKey string) (kyber.Point, error) {
+	keyBytes, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("drand: malformed public key: %s", err)
+	}
+	publicKey := key.KeyGroup.Point()
+	if err := publicKey.UnmarshalBinary(keyBytes); err != nil {
+		return nil, fmt.Errorf("drand: invalid public key: %s", err)
 	}
-	return g, nil
+	return publicKey, nil
 }
@@ -0,0 +1,75 @@
+package beacon
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBeaconRoundsVerify(t *testing.T) {
+	local := NewLocalBeacon()
+	publicKey, err := ParsePublicKey(local.PublicKeyHex())
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		round, err := local.LatestRound()
+		assert.Nil(t, err)
+		assert.Nil(t, round.Verify(publicKey))
+	}
+}
+
+func TestVerifyRejectsTamperedRounds(t *testing.T) {
+	local := NewLocalBeacon()
+	round, _ := local.LatestRound()
+
+	tampered := round
+	tampered.Round++
+	assert.NotNil(t, tampered.Verify(local.PublicKey))
+
+	tampered = round
+	tampered.Randomness = "00" + round.Randomness[2:]
+	assert.NotNil(t, tampered.Verify(local.PublicKey))
+
+	assert.NotNil(t, round.Verify(NewLocalBeacon().PublicKey))
+	assert.Nil(t, VerifyRound([]Beacon{NewLocalBeacon(), local}, round))
+	assert.NotNil(t, VerifyRound([]Beacon{NewLocalBeacon()}, round))
+}
+
+func TestLatestRoundFailsOverToVerifiedBeacon(t *testing.T) {
+	impostor := httptest.NewServer(NewLocalBeacon())
+	defer impostor.Close()
+	local := NewLocalBeacon()
+	trusted := httptest.NewServer(local)
+	defer trusted.Close()
+
+	beacons := []Beacon{NewHTTPBeacon(impostor.URL, local.PublicKey), NewHTTPBeacon(trusted.URL+"/", local.PublicKey)}
+	round, from, err := LatestRound(beacons)
+	assert.Nil(t, err)
+	assert.Equal(t, trusted.URL, from.String())
+	assert.Equal(t, uint64(1), round.Round)
+
+	_, _, err = LatestRound(beacons[:1])
+	assert.Contains(t, err.Error(), "failed signature verification")
+}
+
+func TestNetworkVerifiesItsRounds(t *testing.T) {
+	local := NewLocalBeacon()
+	round, _ := local.LatestRound()
+	network := Network{PublicKey: local.PublicKeyHex()}
+	assert.Nil(t, network.Verify(round))
+	assert.NotNil(t, LeagueOfEntropy.Verify(round))
+
+	server := httptest.NewServer(local)
+	defer server.Close()
+	beacons, err := network.Beacons([]string{server.URL})
+	assert.Nil(t, err)
+	_, _, err = LatestRound(beacons)
+	assert.Nil(t, err)
+}
+
+func TestParsePublicKeyAcceptsLeagueOfEntropyKey(t *testing.T) {
+	_, err := ParsePublicKey(LeagueOfEntropyPublicKey)
+	assert.Nil(t, err)
+	_, err = ParsePublicKey("abcd")
+	assert.NotNil(t, err)
+}
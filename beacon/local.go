@@ -0,0 +1,81 @@
+package beacon
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/drand/drand/chain"
+	"github.com/drand/drand/key"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/util/random"
+)
+
+// LocalBeacon : a chained beacon signing its own rounds the way a drand group does, standing in for drand in tests.
+// It can be used directly as a Beacon, or served over HTTP and pinned with its PublicKeyHex
+type LocalBeacon struct {
+	PublicKey kyber.Point
+	private   kyber.Scalar
+	mutex     sync.Mutex
+	round     uint64
+	signature []byte
+}
+
+// NewLocalBeacon : creates a beacon with a freshly generated key
+func NewLocalBeacon() *LocalBeacon {
+	private := key.KeyGroup.Scalar().Pick(random.New())
+	return &LocalBeacon{
+		PublicKey: key.KeyGroup.Point().Mul(private, nil),
+		private:   private,
+	}
+}
+
+// PublicKeyHex : the public key in the form accepted by ParsePublicKey
+func (b *LocalBeacon) PublicKeyHex() string {
+	keyBytes, _ := b.PublicKey.MarshalBinary()
+	return hex.EncodeToString(keyBytes)
+}
+
+// LatestRound : signs and returns the next round
+func (b *LocalBeacon) LatestRound() (Round, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	next := b.round + 1
+	signature, err := key.AuthScheme.Sign(b.private, chain.Message(next, b.signature))
+	if err != nil {
+		return Round{}, err
+	}
+	round := Round{
+		Round:             next,
+		Randomness:        hex.EncodeToString(chain.RandomnessFromSignature(signature)),
+		Signature:         hex.EncodeToString(signature),
+		PreviousSignature: hex.EncodeToString(b.signature),
+	}
+	b.round, b.signature = next, signature
+	return round, nil
+}
+
+// Verify : checks round against the beacon's public key
+func (b *LocalBeacon) Verify(round Round) error {
+	return round.Verify(b.PublicKey)
+}
+
+func (b *LocalBeacon) String() string {
+	return "local"
+}
+
+// ServeHTTP : serves /public/latest like the drand HTTP api
+func (b *LocalBeacon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/public/latest" {
+		http.NotFound(w, r)
+		return
+	}
+	round, err := b.LatestRound()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(round)
+}
@@ -3,10 +3,12 @@ package calendar
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/chainpoint/chainpoint-core/proof"
 	"github.com/chainpoint/chainpoint-core/types"
 	core_types "github.com/tendermint/tendermint/rpc/core/types"
 
@@ -170,26 +172,49 @@ func GenerateHeadStateObject(uri string, hash []byte, btcMonObj types.BtcMonMsg)
 	return headStateObj
 }
 
-//GenerateAnchorBtcTxState : gather btc anchor info into form ready for postgres
-func GenerateAnchorBtcTxState(btcTxObj types.BtcTxMsg) (stateObj types.AnchorBtcTxState) {
+//GenerateAnchorBtcTxState : gather btc anchor info into form ready for postgres. Errors if the tx body doesn't contain the
+// anchor root, or for taproot commitments the root's tweaked output key
+func GenerateAnchorBtcTxState(btcTxObj types.BtcTxMsg) (types.AnchorBtcTxState, error) {
+	committed := btcTxObj.AnchorBtcAggRoot
+	ops := []types.ProofLineItem{}
+	if btcTxObj.TaprootInternalKey != "" {
+		// the tx contains the output key of the root's tap tweak rather than the root itself
+		internalKey, err := hex.DecodeString(btcTxObj.TaprootInternalKey)
+		if err != nil {
+			return types.AnchorBtcTxState{}, err
+		}
+		root, err := hex.DecodeString(btcTxObj.AnchorBtcAggRoot)
+		if err != nil {
+			return types.AnchorBtcTxState{}, err
+		}
+		outputKey, err := proof.TapTweak(internalKey, root)
+		if err != nil {
+			return types.AnchorBtcTxState{}, err
+		}
+		committed = hex.EncodeToString(outputKey)
+		ops = append(ops, types.ProofLineItem{Left: btcTxObj.TaprootInternalKey}, types.ProofLineItem{Op: proof.TapTweakOp})
+	}
+	index := strings.Index(btcTxObj.BtcTxBody, committed)
+	if committed == "" || index < 0 {
+		return types.AnchorBtcTxState{}, errors.New(fmt.Sprintf("btc tx %s does not commit to %s", btcTxObj.BtcTxID, btcTxObj.AnchorBtcAggRoot))
+	}
 	btcTxState := types.OpsState{
-		Ops: []types.ProofLineItem{
-			{
-				Left: btcTxObj.BtcTxBody[:strings.Index(btcTxObj.BtcTxBody, btcTxObj.AnchorBtcAggRoot)],
+		Ops: append(ops,
+			types.ProofLineItem{
+				Left: btcTxObj.BtcTxBody[:index],
 			},
-			{
-				Right: btcTxObj.BtcTxBody[strings.Index(btcTxObj.BtcTxBody, btcTxObj.AnchorBtcAggRoot)+len(btcTxObj.AnchorBtcAggRoot):],
+			types.ProofLineItem{
+				Right: btcTxObj.BtcTxBody[index+len(committed):],
 			},
-			{
+			types.ProofLineItem{
 				Op: "sha-256-x2",
 			},
-		},
+		),
 	}
 	btcTxStateJSON, _ := json.Marshal(btcTxState)
-	stateObj = types.AnchorBtcTxState{
+	return types.AnchorBtcTxState{
 		AnchorBtcAggId: btcTxObj.AnchorBtcAggID,
 		BtcTxId:        btcTxObj.BtcTxID,
 		BtcTxState:     string(btcTxStateJSON),
-	}
-	return stateObj
+	}, nil
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/knq/pemutil"
 	"github.com/lightningnetwork/lnd/signal"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -222,7 +223,11 @@ func main() {
 	// Wait forever, shutdown gracefully upon
 	tmos.TrapSignal(*config.Logger, func() {
 		if n.IsRunning() {
+			app.Stop()
 			app.Cache.LevelDb.Close()
+			if closer, ok := app.ChainpointDb.(io.Closer); ok {
+				closer.Close()
+			}
 			logger.Info("Shutting down Core...")
 			n.Stop()
 			close(quit)
@@ -244,13 +249,19 @@ func main() {
 	r := mux.NewRouter()
 	r.Handle("/", apiHandlers.HomeHandler)
 	r.Handle("/hash", apiHandlers.HashHandler)
+	r.Handle("/hashes", apiHandlers.HashesHandler).Methods("POST")
+	r.Handle("/hash/document", apiHandlers.HashDocumentHandler).Methods("POST")
 	r.Handle("/proofs", apiHandlers.ProofHandler)
+	r.Handle("/proofs/payment/{rhash}", apiHandlers.PaymentProofsHandler)
 	r.Handle("/proofs/upgrade/{txid}", apiHandlers.ProofUpgradeHandler)
+	r.Handle("/proofs/verify", apiHandlers.ProofVerifyHandler).Methods("POST")
+	r.Handle("/proofs/stream", apiHandlers.ProofStreamHandler)
 	r.Handle("/calendar/{txid}", apiHandlers.CalHandler)
 	r.Handle("/calendar/{txid}/data", apiHandlers.CalDataHandler)
 	r.Handle("/status", apiHandlers.StatusHandler)
 	r.Handle("/peers", apiHandlers.PeerHandler)
 	r.Handle("/gateways/public", apiHandlers.GatewaysHandler)
+	r.Handle("/credit", apiHandlers.CreditHandler)
 
 	server := &http.Server{
 		Handler:      r,
@@ -259,7 +270,15 @@ func main() {
 		ReadTimeout:  15 * time.Second,
 	}
 
+	if config.AdminAPIAddr != "" {
+		adminServer := setupAdminAPI(*app, config)
+		go func() {
+			util.LogError(adminServer.ListenAndServe())
+		}()
+	}
+
 	go app.LnPaymentHandler(quit)
+	go app.Webhooks.Run(quit)
 
 	util.LogError(server.ListenAndServe())
 
@@ -315,6 +334,18 @@ func runLnd(config types.AnchorConfig) {
 	}
 }
 
+// setupAdminAPI : operator-only endpoints, served on a separate listener that defaults to loopback
+func setupAdminAPI(app abci.AnchorApplication, config types.AnchorConfig) *http.Server {
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/archive", app.ArchiveExportHandler).Methods("GET")
+	r.HandleFunc("/admin/archive", app.ArchiveImportHandler).Methods("POST")
+	r.HandleFunc("/admin/ledger", app.LedgerHandler).Methods("GET")
+	return &http.Server{
+		Handler: r,
+		Addr:    config.AdminAPIAddr,
+	}
+}
+
 // setupAPI : set all API handlers according to options
 func setupAPI(app abci.AnchorApplication, config types.AnchorConfig) types.APIHandlers {
 	var apiHandlers types.APIHandlers
@@ -322,13 +353,19 @@ func setupAPI(app abci.AnchorApplication, config types.AnchorConfig) types.APIHa
 		apiHandlers = types.APIHandlers{
 			http.HandlerFunc(app.HomeHandler),
 			http.HandlerFunc(app.HashHandler),
+			http.HandlerFunc(app.HashesHandler),
+			http.HandlerFunc(app.HashDocumentHandler),
 			http.HandlerFunc(app.ProofHandler),
+			http.HandlerFunc(app.PaymentProofsHandler),
 			http.HandlerFunc(app.ProofUpgradeHandler),
+			http.HandlerFunc(app.ProofVerifyHandler),
+			http.HandlerFunc(app.ProofStreamHandler),
 			http.HandlerFunc(app.CalHandler),
 			http.HandlerFunc(app.CalDataHandler),
 			http.HandlerFunc(app.StatusHandler),
 			http.HandlerFunc(app.PeerHandler),
 			http.HandlerFunc(app.GatewaysHandler),
+			http.HandlerFunc(app.CreditHandler),
 		}
 	} else {
 		hashStore, err := memstore.New(65536)
@@ -363,13 +400,19 @@ func setupAPI(app abci.AnchorApplication, config types.AnchorConfig) types.APIHa
 		apiHandlers = types.APIHandlers{
 			apiRateLimiter.RateLimit(http.HandlerFunc(app.HomeHandler)),
 			hashRateLimiter.RateLimit(http.HandlerFunc(app.HashHandler)),
+			hashRateLimiter.RateLimit(http.HandlerFunc(app.HashesHandler)),
+			hashRateLimiter.RateLimit(http.HandlerFunc(app.HashDocumentHandler)),
 			proofRateLimiter.RateLimit(http.HandlerFunc(app.ProofHandler)),
+			proofRateLimiter.RateLimit(http.HandlerFunc(app.PaymentProofsHandler)),
 			proofRateLimiter.RateLimit(http.HandlerFunc(app.ProofUpgradeHandler)),
+			proofRateLimiter.RateLimit(http.HandlerFunc(app.ProofVerifyHandler)),
+			proofRateLimiter.RateLimit(http.HandlerFunc(app.ProofStreamHandler)),
 			apiRateLimiter.RateLimit(http.HandlerFunc(app.CalHandler)),
 			apiRateLimiter.RateLimit(http.HandlerFunc(app.CalDataHandler)),
 			apiRateLimiter.RateLimit(http.HandlerFunc(app.StatusHandler)),
 			apiRateLimiter.RateLimit(http.HandlerFunc(app.PeerHandler)),
 			apiRateLimiter.RateLimit(http.HandlerFunc(app.GatewaysHandler)),
+			apiRateLimiter.RateLimit(http.HandlerFunc(app.CreditHandler)),
 		}
 	}
 	return apiHandlers
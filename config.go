@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/chainpoint/chainpoint-core/fee"
+	"github.com/chainpoint/chainpoint-core/pricing"
 	"github.com/chainpoint/chainpoint-core/tendermintrpc"
 	"github.com/chainpoint/chainpoint-core/types"
 	"github.com/chainpoint/chainpoint-core/util"
@@ -32,11 +34,16 @@ func InitConfig(home string) types.AnchorConfig {
 	var listenAddr, tendermintPeers, tendermintSeeds, tendermintLogFilter, lndLogFilter string
 	var bitcoinNetwork, walletAddress, walletPass, walletSeed, secretKeyPath, aggregatorAllowStr, blockCIDRStr, apiPort string
 	var tlsCertPath, macaroonPath, lndSocket, electionMode, sessionSecret, tmServer, tmPort, updateStake string
-	var coreName, analyticsID, logLevel string
-	var feeMultiplier float64
-	var anchorInterval, anchorTimeout, anchorReward, hashPrice, feeInterval, stakePerCore int
-	var hashQuota, apiQuota, proofQuota int
-	var useAggregatorAllowlist, doCalLoop, doAnchorLoop, useChpLndConfig, removeRateLimits bool
+	var coreName, analyticsID, logLevel, dbType, postgresURI, adminAPIAddr, beaconURLStr string
+	var ethURL, ethPrivateKey string
+	var btcBackend, btcCommitment, bitcoindURL, bitcoindUser, bitcoindPass, bitcoindWallet, feeSourceStr string
+	var priceTierStr, gatewayPriceStr string
+	var feeMultiplier, feeCeiling, hashPriceFeeReference float64
+	var anchorInterval, anchorTimeout, anchorReward, hashPrice, feeInterval, stakePerCore, ethAnchorInterval int
+	var ethConfirmations, feeConfTarget, maxAnchorFee, dailySpendBudget, weeklySpendBudget, minReserveBalance int64
+	var hashQuota, apiQuota, proofQuota, maxHashesPerBatch, postgresPort, pruneBatchSize int
+	var proofTTL, aggStateTTL, calStateTTL, btcAggStateTTL, btcTxStateTTL time.Duration
+	var useAggregatorAllowlist, doCalLoop, doAnchorLoop, useChpLndConfig, removeRateLimits, entropyFallback, doEthAnchor, capAnchorFee bool
 	flag.String(flag.DefaultConfigFlagname, "", "path to config file")
 	flag.StringVar(&bitcoinNetwork, "network", "mainnet", "bitcoin network")
 	flag.BoolVar(&useAggregatorAllowlist, "aggregator_public", false, "use aggregator allow list")
@@ -45,15 +52,41 @@ func InitConfig(home string) types.AnchorConfig {
 	flag.BoolVar(&doAnchorLoop, "anchor", true, "whether to participate in bitcoin anchoring elections")
 	flag.BoolVar(&removeRateLimits, "remove_rate_limits", false, "Remove rate limits and LSAT usage from API")
 	flag.IntVar(&hashQuota, "hashes_per_minute", 3, "Rate limits for the hash submission api")
+	flag.IntVar(&maxHashesPerBatch, "max_hashes_per_batch", 1000, "maximum number of hashes accepted by a single /hashes request")
 	flag.IntVar(&apiQuota, "api_per_minute", 15, "Rate limits for the status, peer, and gateway apis")
 	flag.IntVar(&proofQuota, "proof_per_minute", 25, "Rate limits for the proof retrieval api")
+	flag.StringVar(&beaconURLStr, "beacon_urls", "https://drand.cloudflare.com,https://api.drand.sh", "comma-delimited list of drand http apis, tried in order")
+	flag.BoolVar(&entropyFallback, "entropy_fallback", true, "prefix aggregation leaves with the latest Calendar block hash when no drand beacon is reachable")
 	flag.StringVar(&electionMode, "election", "reputation", "mode for leader election")
 	flag.IntVar(&anchorInterval, "anchor_interval", 60, "interval to use for bitcoin anchoring")
 	flag.IntVar(&anchorTimeout, "anchor_timeout", 20, "timeout use for bitcoin anchoring")
 	flag.IntVar(&anchorReward, "anchor_reward", 0, "reward for cores that anchor")
+	flag.StringVar(&btcBackend, "btc_backend", "lnd", "bitcoin wallet and chain backend used for anchoring: lnd or bitcoind")
+	flag.StringVar(&btcCommitment, "btc_commitment", "op_return", "how bitcoin anchor txs commit to the anchor root: op_return, or taproot to tweak the tx's change output (bitcoind only)")
+	flag.StringVar(&bitcoindURL, "bitcoind_rpc_url", "http://127.0.0.1:8332", "json-rpc url of bitcoind when btc_backend is bitcoind")
+	flag.StringVar(&bitcoindUser, "bitcoind_rpc_user", "", "bitcoind json-rpc username")
+	flag.StringVar(&bitcoindPass, "bitcoind_rpc_pass", "", "bitcoind json-rpc password")
+	flag.StringVar(&bitcoindWallet, "bitcoind_wallet", "", "bitcoind wallet that funds anchor txs. Empty uses the default wallet")
+	flag.BoolVar(&doEthAnchor, "eth_anchor", false, "whether to also anchor to an ethereum/evm chain")
+	flag.StringVar(&ethURL, "eth_url", "http://127.0.0.1:8545", "json-rpc url of the ethereum/evm node to anchor to")
+	flag.StringVar(&ethPrivateKey, "eth_private_key", "", "hex private key of the account that sends evm anchor txs")
+	flag.IntVar(&ethAnchorInterval, "eth_anchor_interval", 60, "interval to use for evm anchoring")
+	flag.Int64Var(&ethConfirmations, "eth_confirmations", 12, "evm blocks required before an evm anchor is confirmed")
 	flag.IntVar(&hashPrice, "submit_hash_price_sat", 2, "cost in satoshis for non-whitelisted gateways to submit a hash")
+	flag.StringVar(&priceTierStr, "hash_price_tiers", "", "comma-delimited list of <hashes in 24 hours>:<sats per hash> volume discounts, e.g. 1000:1,100000:0.1")
+	flag.StringVar(&gatewayPriceStr, "gateway_hash_prices", "", "comma-delimited list of <gateway ip>=<sats per hash> prices that override the tiers")
+	flag.Float64Var(&hashPriceFeeReference, "hash_price_fee_reference", 0, "sat/vbyte bitcoin fee above which hash prices rise in proportion to the fee. 0 disables")
 	flag.StringVar(&blockCIDRStr, "cidr_blocklist", "", "comma-delimited list of IPs to block")
 	flag.StringVar(&proposedValidator, "proposed_validator", "", "propose the promotion of a core to validator")
+	flag.StringVar(&dbType, "db_type", "goleveldb", "proof state database: goleveldb or postgres")
+	flag.StringVar(&postgresURI, "postgres_uri", "", "postgres connection uri. If empty and db_type is postgres, an embedded instance is started")
+	flag.IntVar(&postgresPort, "postgres_port", 5433, "port for the embedded postgres instance")
+	flag.DurationVar(&proofTTL, "proof_ttl", 24*time.Hour, "how long to retain proofs. 0 disables pruning")
+	flag.DurationVar(&aggStateTTL, "agg_state_ttl", 24*time.Hour, "how long to retain aggregation state. 0 disables pruning")
+	flag.DurationVar(&calStateTTL, "cal_state_ttl", 24*time.Hour, "how long to retain calendar state. 0 disables pruning")
+	flag.DurationVar(&btcAggStateTTL, "btc_agg_state_ttl", 24*time.Hour, "how long to retain bitcoin aggregation state. 0 disables pruning")
+	flag.DurationVar(&btcTxStateTTL, "btc_tx_state_ttl", 24*time.Hour, "how long to retain bitcoin tx state. 0 disables pruning")
+	flag.IntVar(&pruneBatchSize, "prune_batch_size", 1000, "maximum number of state items deleted per pruning batch")
 
 	//lightning settings
 	flag.StringVar(&walletAddress, "hot_wallet_address", "", "birthday address for lnd account")
@@ -66,12 +99,21 @@ func InitConfig(home string) types.AnchorConfig {
 	flag.BoolVar(&useChpLndConfig, "chainpoint_lnd_config", true, "whether to use chainpoint's default lnd config")
 	flag.Float64Var(&feeMultiplier, "btc_fee_multiplier", 2.2, "multiply anchoring fee by this constant when mempool is congested")
 	flag.IntVar(&feeInterval, "fee_interval", 10, "interval in minutes to check for new bitcoin tx fee")
+	flag.StringVar(&feeSourceStr, "fee_sources", "chain,https://mempool.space,bitcoiner.live", "comma-delimited list of fee sources: chain (the btc_backend), bitcoiner.live, static:<sat/vbyte>, or the url of a mempool.space-compatible api")
+	flag.Int64Var(&feeConfTarget, "fee_conf_target", 2, "number of blocks anchor txs should confirm within")
+	flag.Float64Var(&feeCeiling, "fee_ceiling", 500, "maximum sat/vbyte fee rate paid by anchor txs. 0 disables the ceiling")
+	flag.Int64Var(&maxAnchorFee, "max_anchor_fee", 0, "maximum fee in satoshis of a single anchor tx, including fee bumps. 0 disables the limit")
+	flag.BoolVar(&capAnchorFee, "cap_anchor_fee", false, "send anchor txs over max_anchor_fee at the highest fee within it, rather than refusing them")
+	flag.Int64Var(&dailySpendBudget, "daily_spend_budget", 0, "maximum satoshis spent on anchor fees and rewards in any 24 hours. 0 disables the budget")
+	flag.Int64Var(&weeklySpendBudget, "weekly_spend_budget", 0, "maximum satoshis spent on anchor fees and rewards in any 7 days. 0 disables the budget")
+	flag.Int64Var(&minReserveBalance, "min_reserve_balance", 0, "confirmed satoshis the anchoring wallet must keep after any anchor or reward")
 	flag.IntVar(&stakePerCore, "stake_per_core", 1000000, "minimum amount staked per channel to permit the addition of a Core")
 	flag.StringVar(&updateStake, "update_stake", "", "a validator may change this value to adjust the stake_per_core")
 	flag.StringVar(&sessionSecret, "session_secret", "", "mutual LSAT macaroon secret for cores and gateways")
 	flag.StringVar(&tmServer, "tendermint_host", "127.0.0.1", "tendermint api url")
 	flag.StringVar(&tmPort, "tendermint_port", "26657", "tendermint api port")
 	flag.StringVar(&apiPort, "api_port", "80", "core api port")
+	flag.StringVar(&adminAPIAddr, "admin_api_addr", "127.0.0.1:8090", "listen address for the operator-only admin api. Empty disables it")
 	flag.StringVar(&coreName, "chainpoint_core_name", "", "core Name")
 	flag.StringVar(&analyticsID, "google_ua_id", "", "google analytics id")
 	flag.StringVar(&logLevel, "log_level", "info", "log level")
@@ -93,6 +135,32 @@ func InitConfig(home string) types.AnchorConfig {
 	if walletSeed != "" && len(strings.Split(walletSeed, ",")) != 24 {
 		panic(errors.New("Provided wallet seed is not the required 24 words"))
 	}
+	if btcBackend != "lnd" && btcBackend != "bitcoind" {
+		panic(errors.New(fmt.Sprintf("Unknown btc_backend %s; must be lnd or bitcoind", btcBackend)))
+	}
+	if btcCommitment != "op_return" && btcCommitment != "taproot" {
+		panic(errors.New(fmt.Sprintf("Unknown btc_commitment %s; must be op_return or taproot", btcCommitment)))
+	}
+	if btcCommitment == "taproot" && btcBackend != "bitcoind" {
+		panic(errors.New("btc_commitment taproot requires btc_backend bitcoind"))
+	}
+	if feeConfTarget < 1 {
+		panic(errors.New("fee_conf_target must be at least 1 block"))
+	}
+	priceTiers, err := pricing.ParseTiers(priceTierStr)
+	if err != nil {
+		panic(err)
+	}
+	gatewayPrices, err := pricing.ParseGatewayPrices(gatewayPriceStr)
+	if err != nil {
+		panic(err)
+	}
+	beaconURLs := []string{}
+	for _, beaconURL := range strings.Split(beaconURLStr, ",") {
+		if beaconURL != "" {
+			beaconURLs = append(beaconURLs, beaconURL)
+		}
+	}
 	if macaroonPath == "" {
 		macaroonPath = fmt.Sprintf("%s/.lnd/data/chain/bitcoin/%s/admin.macaroon", home, strings.ToLower(bitcoinNetwork))
 	}
@@ -142,7 +210,10 @@ func InitConfig(home string) types.AnchorConfig {
 		HomePath:         home,
 		ChainId:          chainId,
 		APIPort:          apiPort,
-		DBType:           "goleveldb",
+		AdminAPIAddr:     adminAPIAddr,
+		DBType:           dbType,
+		PostgresURI:      postgresURI,
+		PostgresPort:     postgresPort,
 		BitcoinNetwork:   bitcoinNetwork,
 		ElectionMode:     electionMode,
 		TendermintConfig: tmConfig,
@@ -159,11 +230,47 @@ func InitConfig(home string) types.AnchorConfig {
 			HashPrice:      int64(hashPrice),
 			SessionSecret:  sessionSecret,
 		},
+		BtcBackend:    btcBackend,
+		BtcCommitment: btcCommitment,
+		BitcoindConfig: types.BitcoindConfig{
+			RPCURL:  bitcoindURL,
+			RPCUser: bitcoindUser,
+			RPCPass: bitcoindPass,
+			Wallet:  bitcoindWallet,
+		},
+		SpendPolicy: types.SpendPolicyConfig{
+			MaxAnchorFee: maxAnchorFee,
+			CapAnchorFee: capAnchorFee,
+			DailyBudget:  dailySpendBudget,
+			WeeklyBudget: weeklySpendBudget,
+			MinReserve:   minReserveBalance,
+		},
+		HashPricing: types.HashPricingConfig{
+			BasePriceMsat: pricing.SatToMsat(float64(hashPrice)),
+			Tiers:         priceTiers,
+			GatewayPrices: gatewayPrices,
+			FeeReference:  fee.SatPerVByteToSatPerKw(hashPriceFeeReference),
+		},
+		EthConfig: types.EthConfig{
+			EthereumURL:    ethURL,
+			EthPrivateKey:  ethPrivateKey,
+			AnchorInterval: ethAnchorInterval,
+			Confirmations:  ethConfirmations,
+		},
+		Retention: types.RetentionPolicy{
+			ProofTTL:             proofTTL,
+			AggStateTTL:          aggStateTTL,
+			CalStateTTL:          calStateTTL,
+			AnchorBtcAggStateTTL: btcAggStateTTL,
+			BtcTxStateTTL:        btcTxStateTTL,
+			BatchSize:            pruneBatchSize,
+		},
 		ECPrivateKey:           ecPrivKey,
 		CIDRBlockList:          blockCIDRs,
 		IPBlockList:            blocklist,
 		DoCal:                  doCalLoop,
 		DoAnchor:               doAnchorLoop,
+		DoEthAnchor:            doEthAnchor,
 		AnchorInterval:         anchorInterval,
 		Logger:                 &tmLogger,
 		FilePV:                 tmConfig.FilePV,
@@ -173,6 +280,9 @@ func InitConfig(home string) types.AnchorConfig {
 		StakePerCore:           1000000,
 		FeeInterval:            int64(feeInterval),
 		FeeMultiplier:          feeMultiplier,
+		FeeSources:             strings.Split(feeSourceStr, ","),
+		FeeConfTarget:          feeConfTarget,
+		FeeCeiling:             fee.SatPerVByteToSatPerKw(feeCeiling),
 		HashPrice:              hashPrice,
 		UseAllowlist:           useAggregatorAllowlist,
 		GatewayAllowlist:       aggregatorAllowlist,
@@ -182,6 +292,9 @@ func InitConfig(home string) types.AnchorConfig {
 		ProposedVal:            proposedValidator,
 		RemoveRateLimits:       removeRateLimits,
 		HashQuota:              hashQuota,
+		MaxHashesPerBatch:      maxHashesPerBatch,
+		BeaconURLs:             beaconURLs,
+		EntropyFallback:        entropyFallback,
 		ApiQuota:               apiQuota,
 		ProofQuota:             proofQuota,
 		UseChainpointLndConfig: useChpLndConfig,
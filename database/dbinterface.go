@@ -5,16 +5,20 @@ import "github.com/chainpoint/chainpoint-core/types"
 type ChainpointDatabase interface {
 	GetProofIdsByAggIds(aggIds []string) ([]string, error)
 	GetProofsByProofIds(proofIds []string) (map[string]types.ProofState, error)
+	GetAnchorProofsByProofIds(proofIds []string) (map[string][]types.ProofState, error)
 	GetProofIdsByBtcTxId(btcTxId string) ([]string, error)
+	GetProofIdsByCalIds(calIds []string) ([]string, error)
 	GetCalStateObjectsByAggIds(aggIds []string) ([]types.CalStateObject, error)
 	GetAggStateObjectsByProofIds(proofIds []string) ([]types.AggState, error)
 	GetAnchorBTCAggStateObjectsByCalIds(calIds []string) ([]types.AnchorBtcAggState, error)
 	GetBTCTxStateObjectByAnchorBTCAggId(aggId string) (types.AnchorBtcTxState, error)
 	GetBTCTxStateObjectByBtcHeadState(btctx string) (types.AnchorBtcTxState, error)
 	BulkInsertProofs(proofs []types.ProofState) error
+	ImportProofs(proofs []types.ProofState) error
+	BulkInsertAnchorProofs(anchor string, proofs []types.ProofState) error
 	BulkInsertAggState(aggStates []types.AggState) error
 	BulkInsertCalState(calStates []types.CalStateObject) error
 	BulkInsertBtcAggState(aggStates []types.AnchorBtcAggState) error
 	BulkInsertBtcTxState(txStates []types.AnchorBtcTxState) error
-	PruneOldState()
+	PruneOldState() (types.PruneReport, error)
 }
@@ -5,18 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chainpoint/chainpoint-core/types"
-	db "github.com/tendermint/tm-db"
-	"strconv"
+	dbm "github.com/tendermint/tm-db"
 	"strings"
-	"time"
+	"sync"
 )
 
 type Chainpoint_DB struct {
-	db *KVStore
+	db          *KVStore
+	retention   types.RetentionPolicy
+	pruning     int32
+	migrateOnce sync.Once
 }
 
-func NewDB(db *KVStore) *Chainpoint_DB {
-	return &Chainpoint_DB{db}
+func NewDB(db *KVStore, retention types.RetentionPolicy) *Chainpoint_DB {
+	return &Chainpoint_DB{db: db, retention: retention}
 }
 
 // GetProofIdsByAggIds : get proof ids from agg table, based on aggId
@@ -51,6 +53,25 @@ func (chp *Chainpoint_DB) GetProofsByProofIds(proofIds []string) (map[string]typ
 	return proofs, nil
 }
 
+// GetAnchorProofsByProofIds : get the proofs stored by anchor engines other than btc, based on id
+func (chp *Chainpoint_DB) GetAnchorProofsByProofIds(proofIds []string) (map[string][]types.ProofState, error) {
+	proofs := make(map[string][]types.ProofState)
+	for _, id := range proofIds {
+		it, err := dbm.IteratePrefix(chp.db.LevelDb, []byte("anchorproof:"+id+":"))
+		if err != nil {
+			return map[string][]types.ProofState{}, err
+		}
+		for ; it.Valid(); it.Next() {
+			proof := types.ProofState{}
+			if json.Unmarshal(it.Value(), &proof) == nil {
+				proofs[id] = append(proofs[id], proof)
+			}
+		}
+		it.Close()
+	}
+	return proofs, nil
+}
+
 // GetProofIdsByBtcTxId : get proof ids from proof table, based on btctxId
 func (chp *Chainpoint_DB) GetProofIdsByBtcTxId(btcTxId string) ([]string, error) {
 	btcTxStateStr, err := chp.db.Get("btctxstate:" + btcTxId)
@@ -64,14 +85,23 @@ func (chp *Chainpoint_DB) GetProofIdsByBtcTxId(btcTxId string) ([]string, error)
 	if err != nil {
 		return []string{}, nil
 	}
-	proofIds := []string{}
+	calIds := []string{}
 	for _, agg := range anchoraggs {
 		anchorAggState := types.AnchorBtcAggState{}
 		if err := json.Unmarshal([]byte(agg), &anchorAggState); err != nil {
 			continue
 		}
 		chp.db.Logger.Info(fmt.Sprintf("Getting calStates %s for AnchorBtcAggState %s", anchorAggState.CalId, anchorAggState.AnchorBtcAggId))
-		calstates, err := chp.db.GetArray("calstate:" + anchorAggState.CalId)
+		calIds = append(calIds, anchorAggState.CalId)
+	}
+	return chp.GetProofIdsByCalIds(calIds)
+}
+
+// GetProofIdsByCalIds : get proof ids of the hashes aggregated into the given CAL txs
+func (chp *Chainpoint_DB) GetProofIdsByCalIds(calIds []string) ([]string, error) {
+	proofIds := []string{}
+	for _, calId := range calIds {
+		calstates, err := chp.db.GetArray("calstate:" + calId)
 		if err != nil {
 			continue
 		}
@@ -183,6 +213,17 @@ func (chp *Chainpoint_DB) GetBTCTxStateObjectByBtcHeadState(btctx string) (types
 
 //BulkInsertProofs : Use pg driver and loop to create bulk proof insert statement
 func (chp *Chainpoint_DB) BulkInsertProofs(proofs []types.ProofState) error {
+	return chp.insertProofs(proofs, true)
+}
+
+// ImportProofs : inserts proofs restored from an archive. They are kept until deleted by hand rather than pruned after the
+// proof TTL, since they were archived precisely because they outlived it
+func (chp *Chainpoint_DB) ImportProofs(proofs []types.ProofState) error {
+	return chp.insertProofs(proofs, false)
+}
+
+// insertProofs stores proofs that don't replace one with a btc branch, marking them created if they can be pruned
+func (chp *Chainpoint_DB) insertProofs(proofs []types.ProofState, prunable bool) error {
 	for _, proof := range proofs {
 		proofExists, err := chp.db.Get("proof:" + proof.ProofID)
 		if err != nil {
@@ -200,7 +241,33 @@ func (chp *Chainpoint_DB) BulkInsertProofs(proofs []types.ProofState) error {
 		if err != nil {
 			return err
 		}
-		chp.db.Set("proofCreated:"+proof.ProofID, strconv.FormatInt(time.Now().Unix(), 10))
+		if !prunable {
+			if err := chp.unmarkCreated(types.ProofStateType, proof.ProofID); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := chp.markCreated(types.ProofStateType, proof.ProofID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BulkInsertAnchorProofs : stores the proofs of an anchor engine other than btc, one per proof and engine
+func (chp *Chainpoint_DB) BulkInsertAnchorProofs(anchor string, proofs []types.ProofState) error {
+	for _, proof := range proofs {
+		p, err := json.Marshal(proof)
+		if err != nil {
+			return err
+		}
+		id := proof.ProofID + ":" + anchor
+		if err := chp.db.Set("anchorproof:"+id, string(p)); err != nil {
+			return err
+		}
+		if err := chp.markCreated(types.AnchorProofStateType, id); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -208,16 +275,23 @@ func (chp *Chainpoint_DB) BulkInsertProofs(proofs []types.ProofState) error {
 // BulkInsertAggState : inserts aggregator state into postgres
 func (chp *Chainpoint_DB) BulkInsertAggState(aggStates []types.AggState) error {
 	for _, agg := range aggStates {
+		ops, err := agg.OpsJSON()
+		if err != nil {
+			continue
+		}
+		agg.AggState = ops
 		a, err := json.Marshal(agg)
 		if err != nil {
 			continue
 		}
 		err = chp.db.Append("aggstate:"+agg.AggID, string(a))
 		err2 := chp.db.Append("aggstate_by_proof:"+agg.ProofID, string(a))
-		chp.db.Set("aggstateCreated:"+agg.ProofID, strconv.FormatInt(time.Now().Unix(), 10))
 		if err != nil || err2 != nil {
 			return errors.New("aggstate insert failed")
 		}
+		if err := chp.markCreated(types.AggStateType, agg.ProofID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -231,10 +305,12 @@ func (chp *Chainpoint_DB) BulkInsertCalState(calStates []types.CalStateObject) e
 		}
 		err = chp.db.Append("calstate:"+cal.CalId, string(c))
 		err2 := chp.db.Append("calstate_by_agg:"+cal.AggID, string(c))
-		chp.db.Set("calstateCreated:"+cal.AggID, strconv.FormatInt(time.Now().Unix(), 10))
 		if err != nil || err2 != nil {
 			return errors.New("calstate insert failed")
 		}
+		if err := chp.markCreated(types.CalStateType, cal.AggID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -248,10 +324,12 @@ func (chp *Chainpoint_DB) BulkInsertBtcAggState(aggStates []types.AnchorBtcAggSt
 		}
 		err = chp.db.Append("anchorbtcaggstate:"+agg.AnchorBtcAggId, string(a))
 		err2 := chp.db.Append("anchorbtcaggstate_by_cal:"+agg.CalId, string(a))
-		chp.db.Set("anchorbtcaggstateCreated:"+agg.CalId, strconv.FormatInt(time.Now().Unix(), 10))
 		if err != nil || err2 != nil {
 			return errors.New("anchorbtcaggstate insert failed")
 		}
+		if err := chp.markCreated(types.AnchorBtcAggStateType, agg.CalId); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -265,105 +343,12 @@ func (chp *Chainpoint_DB) BulkInsertBtcTxState(txStates []types.AnchorBtcTxState
 		}
 		err = chp.db.Set("btctxstate:"+state.BtcTxId, string(s))
 		err2 := chp.db.Set("btctxstate_by_agg:"+state.AnchorBtcAggId, string(s))
-		chp.db.Set("btctxstateCreated:"+state.AnchorBtcAggId, strconv.FormatInt(time.Now().Unix(), 10))
 		if err != nil || err2 != nil {
 			return errors.New("anchorbtcaggstate insert failed")
 		}
-	}
-	return nil
-}
-
-func (chp *Chainpoint_DB) PruneOldState() {
-	btctxstateIt, _ := db.IteratePrefix(chp.db.LevelDb, []byte("btctxstateCreated:"))
-	anchoraggstateIt, _ := db.IteratePrefix(chp.db.LevelDb, []byte("anchorbtcaggstateCreated:"))
-	calstateIt, _ := db.IteratePrefix(chp.db.LevelDb, []byte("calstateCreated:"))
-	aggstateIt, _ := db.IteratePrefix(chp.db.LevelDb, []byte("aggstateCreated:"))
-	proofstateIt, _ := db.IteratePrefix(chp.db.LevelDb, []byte("proofCreated:"))
-	for ; btctxstateIt.Valid(); btctxstateIt.Next() {
-		value := btctxstateIt.Value()
-		t, err := strconv.ParseInt(string(value), 10, 64)
-		if err != nil {
-			continue
-		}
-		tm := time.Unix(t, 0)
-		if time.Now().After(tm.Add(24 * time.Hour)) {
-			key := string(btctxstateIt.Key())
-			id := strings.Split(key, ":")[1]
-			state, _ := chp.GetBTCTxStateObjectByAnchorBTCAggId(id)
-			chp.db.Del(key, "")
-			chp.db.Del("btctxstate_by_agg:"+id, "")
-			chp.db.Del("btctxstate:"+state.BtcTxId, "")
-			chp.db.Logger.Info("db pruned", "btcTxState", state.BtcTxId)
-		}
-	}
-	for ; anchoraggstateIt.Valid(); anchoraggstateIt.Next() {
-		value := anchoraggstateIt.Value()
-		t, err := strconv.ParseInt(string(value), 10, 64)
-		if err != nil {
-			continue
-		}
-		tm := time.Unix(t, 0)
-		if time.Now().After(tm.Add(24 * time.Hour)) {
-			key := string(anchoraggstateIt.Key())
-			id := strings.Split(key, ":")[1]
-			states, _ := chp.GetAnchorBTCAggStateObjectsByCalIds([]string{id})
-			chp.db.Del(key, "")
-			chp.db.Del("anchorbtcaggstate_by_cal"+id, "")
-			for _, s := range states {
-				chp.db.Del("anchorbtcaggstate:"+s.AnchorBtcAggId, "")
-			}
-		}
-	}
-	for ; calstateIt.Valid(); calstateIt.Next() {
-		value := calstateIt.Value()
-		t, err := strconv.ParseInt(string(value), 10, 64)
-		if err != nil {
-			continue
-		}
-		tm := time.Unix(t, 0)
-		if time.Now().After(tm.Add(24 * time.Hour)) {
-			key := string(calstateIt.Key())
-			id := strings.Split(key, ":")[1]
-			states, _ := chp.GetCalStateObjectsByAggIds([]string{id})
-			chp.db.Del(key, "")
-			chp.db.Del("calstate_by_agg:"+id, "")
-			for _, s := range states {
-				chp.db.Del("calstate:"+s.CalId, "")
-			}
-		}
-	}
-	for ; aggstateIt.Valid(); aggstateIt.Next() {
-		value := aggstateIt.Value()
-		t, err := strconv.ParseInt(string(value), 10, 64)
-		if err != nil {
-			continue
-		}
-		tm := time.Unix(t, 0)
-		if time.Now().After(tm.Add(24 * time.Hour)) {
-			key := string(aggstateIt.Key())
-			id := strings.Split(key, ":")[1]
-			states, _ := chp.GetAggStateObjectsByProofIds([]string{id})
-			chp.db.Del(key, "")
-			chp.db.Del("aggstate_by_proof:"+id, "")
-			for _, s := range states {
-				chp.db.Del("aggstate:"+s.AggID, "")
-			}
-		}
-	}
-	for ; proofstateIt.Valid(); proofstateIt.Next() {
-		value := proofstateIt.Value()
-		t, err := strconv.ParseInt(string(value), 10, 64)
-		//chp.db.Logger.Info("Iterating", "key", proofstateIt.Key(), "proofstateIt", string(value))
-		if err != nil {
-			continue
-		}
-		tm := time.Unix(t, 0)
-		if time.Now().After(tm.Add(24 * time.Hour)) {
-			key := string(proofstateIt.Key())
-			id := strings.Split(key, ":")[1]
-			chp.db.Del(key, "")
-			chp.db.Del("proof:"+id, "")
-			chp.db.Logger.Info("db pruned", "proof", id)
+		if err := chp.markCreated(types.BtcTxStateType, state.AnchorBtcAggId); err != nil {
+			return err
 		}
 	}
+	return nil
 }
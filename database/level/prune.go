@@ -0,0 +1,224 @@
+package level
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// pruneIndexPrefix : time-ordered index of state creation, keyed pruneidx:<state type>:<zero-padded unix time>:<id>
+const pruneIndexPrefix = "pruneidx:"
+
+// pruneIndexMigratedKey marks that legacy *Created: keys have been copied into the prune index
+const pruneIndexMigratedKey = "pruneidx_migrated"
+
+const defaultPruneBatchSize = 1000
+
+// pruneOrder : state types in the order they are pruned, from the most to the least derived
+var pruneOrder = []string{
+	types.BtcTxStateType,
+	types.AnchorBtcAggStateType,
+	types.CalStateType,
+	types.AggStateType,
+	types.AnchorProofStateType,
+	types.ProofStateType,
+}
+
+func pruneIndexKey(stateType string, unixTime int64, id string) []byte {
+	return []byte(fmt.Sprintf("%s%s:%020d:%s", pruneIndexPrefix, stateType, unixTime, id))
+}
+
+func createdKey(stateType string, id string) string {
+	return stateType + "Created:" + id
+}
+
+// markCreated records the creation time of a state item in the prune index, replacing any earlier entry for the same id
+func (chp *Chainpoint_DB) markCreated(stateType string, id string) error {
+	key := createdKey(stateType, id)
+	previous, err := chp.db.Get(key)
+	if err != nil {
+		return err
+	}
+	batch := chp.db.LevelDb.NewBatch()
+	defer batch.Close()
+	if t, err := strconv.ParseInt(previous, 10, 64); err == nil {
+		batch.Delete(pruneIndexKey(stateType, t, id))
+	}
+	now := time.Now().Unix()
+	batch.Set([]byte(key), []byte(strconv.FormatInt(now, 10)))
+	batch.Set(pruneIndexKey(stateType, now, id), []byte{})
+	return batch.Write()
+}
+
+// unmarkCreated removes a state item from the prune index, so it is never pruned
+func (chp *Chainpoint_DB) unmarkCreated(stateType string, id string) error {
+	key := createdKey(stateType, id)
+	previous, err := chp.db.Get(key)
+	if err != nil || previous == "" {
+		return err
+	}
+	batch := chp.db.LevelDb.NewBatch()
+	defer batch.Close()
+	if t, err := strconv.ParseInt(previous, 10, 64); err == nil {
+		batch.Delete(pruneIndexKey(stateType, t, id))
+	}
+	batch.Delete([]byte(key))
+	return batch.Write()
+}
+
+// PruneOldState : removes state older than its configured TTL, walking only expired entries of the prune index
+func (chp *Chainpoint_DB) PruneOldState() (types.PruneReport, error) {
+	report := types.PruneReport{Pruned: map[string]int{}}
+	if !atomic.CompareAndSwapInt32(&chp.pruning, 0, 1) {
+		return report, nil
+	}
+	defer atomic.StoreInt32(&chp.pruning, 0)
+	start := time.Now()
+	var migrateErr error
+	chp.migrateOnce.Do(func() {
+		migrateErr = chp.migrateLegacyCreatedKeys()
+	})
+	if migrateErr != nil {
+		return report, migrateErr
+	}
+	for _, stateType := range pruneOrder {
+		ttl := chp.retention.TTL(stateType)
+		if ttl <= 0 {
+			continue
+		}
+		count, err := chp.pruneExpired(stateType, start.Add(-ttl).Unix())
+		report.Pruned[stateType] = count
+		if err != nil {
+			report.Duration = time.Since(start)
+			return report, err
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// pruneExpired deletes state of a single type created at or before cutoff, one batch at a time
+func (chp *Chainpoint_DB) pruneExpired(stateType string, cutoff int64) (int, error) {
+	batchSize := chp.retention.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultPruneBatchSize
+	}
+	start := []byte(pruneIndexPrefix + stateType + ":")
+	end := pruneIndexKey(stateType, cutoff+1, "")
+	total := 0
+	for {
+		// collect first, since writes are not permitted within an open iterator's domain
+		it, err := chp.db.LevelDb.Iterator(start, end)
+		if err != nil {
+			return total, err
+		}
+		indexKeys := [][]byte{}
+		for ; it.Valid() && len(indexKeys) < batchSize; it.Next() {
+			indexKeys = append(indexKeys, append([]byte{}, it.Key()...))
+		}
+		it.Close()
+		if len(indexKeys) == 0 {
+			return total, nil
+		}
+		batch := chp.db.LevelDb.NewBatch()
+		for _, indexKey := range indexKeys {
+			parts := strings.SplitN(string(indexKey), ":", 4)
+			if len(parts) != 4 {
+				batch.Delete(indexKey)
+				continue
+			}
+			id := parts[3]
+			chp.deleteState(batch, stateType, id)
+			batch.Delete([]byte(createdKey(stateType, id)))
+			batch.Delete(indexKey)
+		}
+		err = batch.Write()
+		batch.Close()
+		if err != nil {
+			return total, err
+		}
+		total += len(indexKeys)
+		if len(indexKeys) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// deleteState adds the deletion of every key belonging to a state item to batch
+func (chp *Chainpoint_DB) deleteState(batch dbm.Batch, stateType string, id string) {
+	switch stateType {
+	case types.BtcTxStateType:
+		state, _ := chp.GetBTCTxStateObjectByAnchorBTCAggId(id)
+		batch.Delete([]byte("btctxstate_by_agg:" + id))
+		if state.BtcTxId != "" {
+			batch.Delete([]byte("btctxstate:" + state.BtcTxId))
+		}
+	case types.AnchorBtcAggStateType:
+		states, _ := chp.GetAnchorBTCAggStateObjectsByCalIds([]string{id})
+		batch.Delete([]byte("anchorbtcaggstate_by_cal:" + id))
+		for _, s := range states {
+			batch.Delete([]byte("anchorbtcaggstate:" + s.AnchorBtcAggId))
+		}
+	case types.CalStateType:
+		states, _ := chp.GetCalStateObjectsByAggIds([]string{id})
+		batch.Delete([]byte("calstate_by_agg:" + id))
+		for _, s := range states {
+			batch.Delete([]byte("calstate:" + s.CalId))
+		}
+	case types.AggStateType:
+		states, _ := chp.GetAggStateObjectsByProofIds([]string{id})
+		batch.Delete([]byte("aggstate_by_proof:" + id))
+		for _, s := range states {
+			batch.Delete([]byte("aggstate:" + s.AggID))
+		}
+	case types.ProofStateType:
+		batch.Delete([]byte("proof:" + id))
+	case types.AnchorProofStateType:
+		batch.Delete([]byte("anchorproof:" + id))
+	}
+}
+
+// migrateLegacyCreatedKeys indexes *Created: keys written before the prune index existed. This scan runs once per database
+func (chp *Chainpoint_DB) migrateLegacyCreatedKeys() error {
+	migrated, err := chp.db.Get(pruneIndexMigratedKey)
+	if err != nil {
+		return err
+	}
+	if migrated != "" {
+		return nil
+	}
+	for _, stateType := range pruneOrder {
+		prefix := createdKey(stateType, "")
+		it, err := dbm.IteratePrefix(chp.db.LevelDb, []byte(prefix))
+		if err != nil {
+			return err
+		}
+		batch := chp.db.LevelDb.NewBatch()
+		count := 0
+		for ; it.Valid(); it.Next() {
+			t, err := strconv.ParseInt(string(it.Value()), 10, 64)
+			if err != nil {
+				continue
+			}
+			batch.Set(pruneIndexKey(stateType, t, strings.TrimPrefix(string(it.Key()), prefix)), []byte{})
+			count++
+		}
+		it.Close()
+		err = batch.Write()
+		batch.Close()
+		if err != nil {
+			return err
+		}
+		chp.db.Logger.Info("db prune index migrated", "type", stateType, "count", count)
+	}
+	if err := chp.db.Set(pruneIndexMigratedKey, strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		return errors.New("could not mark prune index migrated: " + err.Error())
+	}
+	return nil
+}
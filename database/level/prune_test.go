@@ -0,0 +1,51 @@
+package level
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestPruneOldStateRemovesOnlyExpired(t *testing.T) {
+	assert := assert.New(t)
+	var memDb dbm.DB = dbm.NewMemDB()
+	chp := NewDB(NewKVStore(&memDb, log.NewNopLogger()), types.RetentionPolicy{
+		ProofTTL:             time.Hour,
+		AnchorBtcAggStateTTL: time.Hour,
+		BatchSize:            1,
+	})
+
+	assert.Nil(chp.BulkInsertProofs([]types.ProofState{{ProofID: "old", Proof: "{}"}, {ProofID: "new", Proof: "{}"}}))
+	assert.Nil(chp.BulkInsertProofs([]types.ProofState{{ProofID: "imported", Proof: "{}"}}))
+	assert.Nil(chp.BulkInsertBtcAggState([]types.AnchorBtcAggState{{CalId: "cal", AnchorBtcAggId: "agg"}}))
+	// backdate "old", "imported" and the btc agg state past their TTL
+	stale := time.Now().Add(-2 * time.Hour).Unix()
+	for _, item := range [][2]string{{types.ProofStateType, "old"}, {types.ProofStateType, "imported"}, {types.AnchorBtcAggStateType, "cal"}} {
+		stateType, id := item[0], item[1]
+		created, _ := memDb.Get([]byte(createdKey(stateType, id)))
+		createdAt, _ := strconv.ParseInt(string(created), 10, 64)
+		memDb.Delete(pruneIndexKey(stateType, createdAt, id))
+		memDb.Set(pruneIndexKey(stateType, stale, id), []byte{})
+		memDb.Set([]byte(createdKey(stateType, id)), []byte(strconv.FormatInt(stale, 10)))
+	}
+	// restoring a proof from an archive takes it out of the prune index
+	assert.Nil(chp.ImportProofs([]types.ProofState{{ProofID: "imported", Proof: "{}"}}))
+
+	report, err := chp.PruneOldState()
+	assert.Nil(err)
+	assert.Equal(1, report.Pruned[types.ProofStateType])
+	assert.Equal(1, report.Pruned[types.AnchorBtcAggStateType])
+
+	proofs, _ := chp.GetProofsByProofIds([]string{"new", "imported"})
+	assert.Equal("{}", proofs["new"].Proof)
+	assert.Equal("{}", proofs["imported"].Proof)
+	old, _ := memDb.Get([]byte("proof:old"))
+	assert.Nil(old)
+	byCal, _ := memDb.Get([]byte("anchorbtcaggstate_by_cal:cal"))
+	assert.Nil(byCal)
+}
@@ -0,0 +1,157 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// recorder : a database/sql driver that records the statements it is asked to execute, so queries can be checked without
+// a postgres server. Each Exec reports the next of affected as its rows affected, or 0 once they run out
+type recorder struct {
+	mutex     sync.Mutex
+	execs     []recordedExec
+	affected  []int64
+	committed int
+}
+
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+var testRecorder = &recorder{}
+
+func init() {
+	sql.Register("recorder", testRecorder)
+}
+
+// newRecordedPostgres : a Postgres whose statements are recorded by a fresh recorder
+func newRecordedPostgres(t *testing.T, retention types.RetentionPolicy, affected ...int64) (*Postgres, *recorder) {
+	testRecorder.mutex.Lock()
+	testRecorder.execs, testRecorder.affected, testRecorder.committed = nil, affected, 0
+	testRecorder.mutex.Unlock()
+	db, err := sql.Open("recorder", "")
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &Postgres{DB: db, Logger: log.NewNopLogger(), retention: retention}, testRecorder
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error) { return &recordedConn{r}, nil }
+
+type recordedConn struct{ r *recorder }
+
+func (c *recordedConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordedStmt{r: c.r, query: query}, nil
+}
+func (c *recordedConn) Close() error              { return nil }
+func (c *recordedConn) Begin() (driver.Tx, error) { return &recordedTx{c.r}, nil }
+
+type recordedTx struct{ r *recorder }
+
+func (tx *recordedTx) Commit() error {
+	tx.r.mutex.Lock()
+	defer tx.r.mutex.Unlock()
+	tx.r.committed++
+	return nil
+}
+func (tx *recordedTx) Rollback() error { return nil }
+
+type recordedStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s *recordedStmt) Close() error  { return nil }
+func (s *recordedStmt) NumInput() int { return -1 }
+func (s *recordedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.mutex.Lock()
+	defer s.r.mutex.Unlock()
+	s.r.execs = append(s.r.execs, recordedExec{query: s.query, args: args})
+	var affected int64
+	if len(s.r.affected) > 0 {
+		affected, s.r.affected = s.r.affected[0], s.r.affected[1:]
+	}
+	return driver.RowsAffected(affected), nil
+}
+func (s *recordedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not recorded")
+}
+
+func TestPruneOldStateDeletesInBatches(t *testing.T) {
+	assert := assert.New(t)
+	pg, r := newRecordedPostgres(t, types.RetentionPolicy{ProofTTL: time.Hour, BatchSize: 2}, 2, 1, 1)
+	start := time.Now()
+
+	report, err := pg.PruneOldState()
+	assert.Nil(err)
+	// anchor_proofs fills a batch, so a second one is deleted, then proofs is done after a partial batch
+	assert.Equal(3, report.Pruned[types.AnchorProofStateType])
+	assert.Equal(1, report.Pruned[types.ProofStateType])
+	assert.Len(r.execs, 3, "only state types with a TTL are pruned")
+	for i, table := range []string{"anchor_proofs", "anchor_proofs", "proofs"} {
+		assert.Equal("DELETE FROM "+table+" WHERE ctid = ANY(ARRAY(SELECT ctid FROM "+table+" WHERE created_at < $1 LIMIT $2))", r.execs[i].query)
+		assert.Equal(int64(2), r.execs[i].args[1])
+		cutoff := r.execs[i].args[0].(time.Time)
+		assert.WithinDuration(start.Add(-time.Hour), cutoff, time.Minute)
+	}
+}
+
+func TestPruneOldStateDefaultBatchSize(t *testing.T) {
+	assert := assert.New(t)
+	pg, r := newRecordedPostgres(t, types.RetentionPolicy{BtcTxStateTTL: time.Hour})
+
+	report, err := pg.PruneOldState()
+	assert.Nil(err)
+	assert.Equal(0, report.Pruned[types.BtcTxStateType])
+	assert.Len(r.execs, 1)
+	assert.Equal(int64(defaultPruneBatchSize), r.execs[0].args[1])
+}
+
+func TestImportProofsAreCreatedAtInfinity(t *testing.T) {
+	assert := assert.New(t)
+	pg, r := newRecordedPostgres(t, types.RetentionPolicy{})
+
+	assert.Nil(pg.ImportProofs([]types.ProofState{{ProofID: "a", Proof: "{}"}, {ProofID: "b", Proof: "{}"}}))
+	assert.Len(r.execs, 2, "one insert per proof")
+	assert.Equal(1, r.committed, "in a single transaction")
+	assert.True(strings.Contains(r.execs[0].query, "'infinity'"))
+	assert.Equal([]driver.Value{"b", "{}"}, r.execs[1].args)
+}
+
+func TestPostgresPruning(t *testing.T) {
+	uri := os.Getenv("POSTGRES_TEST_URI")
+	if uri == "" {
+		t.Skip("set POSTGRES_TEST_URI to run against a postgres server")
+	}
+	assert := assert.New(t)
+	pg, err := NewPostgres(uri, types.RetentionPolicy{ProofTTL: time.Hour, BatchSize: 1}, log.NewNopLogger())
+	assert.Nil(err)
+	defer pg.Close()
+	ids := []string{"prune-test-old", "prune-test-new", "prune-test-imported"}
+	defer pg.DB.Exec("DELETE FROM proofs WHERE proof_id = ANY($1)", pq.Array(ids))
+
+	assert.Nil(pg.BulkInsertProofs([]types.ProofState{{ProofID: ids[0], Proof: "{}"}, {ProofID: ids[1], Proof: "{}"}}))
+	assert.Nil(pg.ImportProofs([]types.ProofState{{ProofID: ids[2], Proof: "{}"}}))
+	_, err = pg.DB.Exec("UPDATE proofs SET created_at = now() - interval '2 hours' WHERE proof_id = $1", ids[0])
+	assert.Nil(err)
+
+	report, err := pg.PruneOldState()
+	assert.Nil(err)
+	assert.GreaterOrEqual(report.Pruned[types.ProofStateType], 1)
+	proofs, err := pg.GetProofsByProofIds(ids)
+	assert.Nil(err)
+	assert.NotContains(proofs, ids[0])
+	assert.Contains(proofs, ids[1])
+	assert.Contains(proofs, ids[2], "imported proofs outlive the proof TTL")
+}
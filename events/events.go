@@ -0,0 +1,142 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Proof state transitions published to subscribers
+const (
+	Aggregation = "aggregation" // hashes were aggregated into a root
+	Cal         = "cal"         // a CAL tx containing the aggregation root landed in the Calendar
+	BtcA        = "btc-a"       // a BTC-A tx announced a bitcoin anchor broadcast
+	BtcR        = "btc-r"       // a BTC-R tx announced a fee-bumped replacement of a bitcoin anchor broadcast
+	BtcC        = "btc-c"       // a BTC-C tx confirmed a bitcoin anchor and btc proofs were generated
+	EthC        = "eth-c"       // an ETH-C tx confirmed an evm anchor and eth branches were added to proofs
+)
+
+// subscriberBuffer : events queued per subscriber before further events are dropped for it
+const subscriberBuffer = 64
+
+// heldExpiry : how long proof ids wait for a correlated event before being discarded
+const heldExpiry = 10 * time.Minute
+
+type held struct {
+	proofIds []string
+	created  time.Time
+}
+
+// Event : a proof state transition. Events without ProofIDs concern every proof
+type Event struct {
+	Type          string   `json:"type"`
+	Time          string   `json:"time"`
+	ProofIDs      []string `json:"proof_ids,omitempty"`
+	AggRoot       string   `json:"agg_root,omitempty"`
+	CalTx         string   `json:"cal_tx,omitempty"`
+	BtcTxID       string   `json:"btctx_id,omitempty"`
+	BtcHeadHeight int64    `json:"btc_head_height,omitempty"`
+	EthTxID       string   `json:"eth_tx_id,omitempty"`
+	EthBlock      int64    `json:"eth_block,omitempty"`
+}
+
+// Subscription : receives events on C until unsubscribed
+type Subscription struct {
+	C        chan Event
+	proofIds map[string]bool
+}
+
+// Broker : fans published events out to subscriptions
+type Broker struct {
+	mutex         sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+	heldMutex     sync.Mutex
+	held          map[string]held
+}
+
+// NewBroker : creates a broker without subscribers
+func NewBroker() *Broker {
+	return &Broker{
+		subscriptions: make(map[*Subscription]struct{}),
+		held:          make(map[string]held),
+	}
+}
+
+// Hold : keeps proof ids under key until an event for the same key is published with PublishHeld,
+// e.g. between broadcasting a CAL tx and seeing it delivered
+func (b *Broker) Hold(key string, proofIds []string) {
+	b.heldMutex.Lock()
+	defer b.heldMutex.Unlock()
+	for k, h := range b.held {
+		if time.Since(h.created) > heldExpiry {
+			delete(b.held, k)
+		}
+	}
+	b.held[key] = held{proofIds: proofIds, created: time.Now()}
+}
+
+// PublishHeld : publishes event with the proof ids held under key. Nothing is published if none are held
+func (b *Broker) PublishHeld(key string, event Event) {
+	b.heldMutex.Lock()
+	h, exists := b.held[key]
+	delete(b.held, key)
+	b.heldMutex.Unlock()
+	if !exists {
+		return
+	}
+	event.ProofIDs = h.proofIds
+	b.Publish(event)
+}
+
+// Subscribe : subscribes to events for the given proof ids, or to all events if none are given
+func (b *Broker) Subscribe(proofIds []string) *Subscription {
+	sub := &Subscription{C: make(chan Event, subscriberBuffer)}
+	if len(proofIds) > 0 {
+		sub.proofIds = make(map[string]bool, len(proofIds))
+		for _, id := range proofIds {
+			sub.proofIds[id] = true
+		}
+	}
+	b.mutex.Lock()
+	b.subscriptions[sub] = struct{}{}
+	b.mutex.Unlock()
+	return sub
+}
+
+// Unsubscribe : stops delivery to a subscription and closes its channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mutex.Lock()
+	if _, exists := b.subscriptions[sub]; exists {
+		delete(b.subscriptions, sub)
+		close(sub.C)
+	}
+	b.mutex.Unlock()
+}
+
+// Publish : delivers an event to every interested subscriber without blocking. Subscribers to specific
+// proof ids receive only their own ids. Slow subscribers miss events rather than stalling the publisher.
+func (b *Broker) Publish(event Event) {
+	if event.Time == "" {
+		event.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for sub := range b.subscriptions {
+		delivered := event
+		if sub.proofIds != nil && len(event.ProofIDs) > 0 {
+			matched := []string{}
+			for _, id := range event.ProofIDs {
+				if sub.proofIds[id] {
+					matched = append(matched, id)
+				}
+			}
+			if len(matched) == 0 {
+				continue
+			}
+			delivered.ProofIDs = matched
+		}
+		select {
+		case sub.C <- delivered:
+		default:
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishFiltersByProofId(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewBroker()
+	mine := broker.Subscribe([]string{"a"})
+	all := broker.Subscribe(nil)
+
+	broker.Publish(Event{Type: Aggregation, ProofIDs: []string{"a", "b"}})
+	broker.Publish(Event{Type: Aggregation, ProofIDs: []string{"c"}})
+	broker.Publish(Event{Type: BtcA, BtcTxID: "tx"})
+
+	assert.Equal([]string{"a"}, (<-mine.C).ProofIDs)
+	assert.Equal(BtcA, (<-mine.C).Type)
+	assert.Len(mine.C, 0)
+	assert.Len(all.C, 3)
+
+	broker.Unsubscribe(mine)
+	_, open := <-mine.C
+	assert.False(open)
+}
+
+func TestPublishHeldAttachesProofIds(t *testing.T) {
+	assert := assert.New(t)
+	broker := NewBroker()
+	sub := broker.Subscribe([]string{"a"})
+
+	broker.PublishHeld("txhash", Event{Type: Cal, CalTx: "txhash"})
+	assert.Len(sub.C, 0)
+
+	broker.Hold("txhash", []string{"a", "b"})
+	broker.PublishHeld("txhash", Event{Type: Cal, CalTx: "txhash"})
+	event := <-sub.C
+	assert.Equal([]string{"a"}, event.ProofIDs)
+	assert.Equal("txhash", event.CalTx)
+
+	broker.PublishHeld("txhash", Event{Type: Cal, CalTx: "txhash"})
+	assert.Len(sub.C, 0)
+}
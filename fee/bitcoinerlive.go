@@ -2,6 +2,7 @@ package fee
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/btcsuite/btcd/blockchain"
 	"net/http"
 	"time"
@@ -133,10 +134,23 @@ type BitcoinerFee struct {
 	} `json:"estimates"`
 }
 
-// GetThirdPartyFeeEstimate : get sat/vbyte fee and convert to sat/kw
-func GetThirdPartyFeeEstimate() (int64, error) {
-	var httpClient = &http.Client{Timeout: 10 * time.Second}
-	resp, err := httpClient.Get("https://bitcoiner.live/api/fees/estimates/latest")
+// BitcoinerLiveEstimator : estimates from bitcoiner.live's api, which quotes rates for confirmation within a number of minutes
+type BitcoinerLiveEstimator struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewBitcoinerLiveEstimator : creates an estimator for bitcoiner.live
+func NewBitcoinerLiveEstimator() *BitcoinerLiveEstimator {
+	return &BitcoinerLiveEstimator{
+		URL:    "https://bitcoiner.live/api/fees/estimates/latest",
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// EstimateFee : get the sat/vbyte fee for the shortest wait of at least confTarget 10 minute blocks and convert to sat/kw
+func (b *BitcoinerLiveEstimator) EstimateFee(confTarget int64) (int64, error) {
+	resp, err := b.Client.Get(b.URL)
 	if err != nil {
 		return 0, err
 	}
@@ -146,5 +160,34 @@ func GetThirdPartyFeeEstimate() (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return int64(int64(fee.Estimates.Num30.SatPerVbyte) * 1000 / blockchain.WitnessScaleFactor), nil
+	var satPerVbyte float64
+	switch minutes := confTarget * 10; {
+	case minutes <= 30:
+		satPerVbyte = fee.Estimates.Num30.SatPerVbyte
+	case minutes <= 60:
+		satPerVbyte = fee.Estimates.Num60.SatPerVbyte
+	case minutes <= 120:
+		satPerVbyte = fee.Estimates.Num120.SatPerVbyte
+	case minutes <= 180:
+		satPerVbyte = fee.Estimates.Num180.SatPerVbyte
+	case minutes <= 360:
+		satPerVbyte = fee.Estimates.Num360.SatPerVbyte
+	case minutes <= 720:
+		satPerVbyte = fee.Estimates.Num720.SatPerVbyte
+	default:
+		satPerVbyte = fee.Estimates.Num1440.SatPerVbyte
+	}
+	if satPerVbyte <= 0 {
+		return 0, errors.New("bitcoiner.live returned no estimate")
+	}
+	return int64(int64(satPerVbyte) * 1000 / blockchain.WitnessScaleFactor), nil
+}
+
+func (b *BitcoinerLiveEstimator) String() string {
+	return "bitcoiner.live"
+}
+
+// GetThirdPartyFeeEstimate : get bitcoiner.live's 30 minute sat/vbyte fee and convert to sat/kw
+func GetThirdPartyFeeEstimate() (int64, error) {
+	return NewBitcoinerLiveEstimator().EstimateFee(3)
 }
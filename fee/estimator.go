@@ -0,0 +1,124 @@
+package fee
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OutlierFactor : estimates more than this many times above or below the median of all sources are discarded
+const OutlierFactor = 2
+
+// Source : anything that estimates bitcoin fee rates, such as a bitcoin.ChainBackend
+type Source interface {
+	// EstimateFee : the fee rate in sat/kw for confirmation within confTarget blocks
+	EstimateFee(confTarget int64) (int64, error)
+}
+
+// Estimator : a fee Source that can be named in logs
+type Estimator interface {
+	Source
+	String() string
+}
+
+// SatPerVByteToSatPerKw : converts the sat/vB rates quoted by wallets and explorers to sat/kw
+func SatPerVByteToSatPerKw(satPerVByte float64) int64 {
+	return int64(satPerVByte * 1000 / 4)
+}
+
+// ChainEstimator : the estimates of the Core's bitcoin backend
+type ChainEstimator struct {
+	Name  string
+	Chain Source
+}
+
+func (c ChainEstimator) EstimateFee(confTarget int64) (int64, error) {
+	return c.Chain.EstimateFee(confTarget)
+}
+
+func (c ChainEstimator) String() string {
+	return c.Name
+}
+
+// StaticEstimator : always estimates the same sat/kw rate
+type StaticEstimator int64
+
+func (s StaticEstimator) EstimateFee(confTarget int64) (int64, error) {
+	return int64(s), nil
+}
+
+func (s StaticEstimator) String() string {
+	return fmt.Sprintf("static:%d", int64(s))
+}
+
+// NewEstimators : parses the comma-delimited fee_sources config. "chain" is the Core's bitcoin backend, named chainName,
+// "bitcoiner.live" is bitcoiner.live's api, "static:<sat/vB>" is a fixed rate, and any other http(s) url is a
+// mempool.space-compatible api
+func NewEstimators(sources []string, chainName string, chain Source) ([]Estimator, error) {
+	estimators := []Estimator{}
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		switch {
+		case source == "":
+			continue
+		case source == "chain":
+			estimators = append(estimators, ChainEstimator{Name: chainName, Chain: chain})
+		case source == "bitcoiner.live":
+			estimators = append(estimators, NewBitcoinerLiveEstimator())
+		case strings.HasPrefix(source, "static:"):
+			satPerVByte, err := strconv.ParseFloat(strings.TrimPrefix(source, "static:"), 64)
+			if err != nil || satPerVByte <= 0 {
+				return nil, errors.New(fmt.Sprintf("invalid static fee source %s", source))
+			}
+			estimators = append(estimators, StaticEstimator(SatPerVByteToSatPerKw(satPerVByte)))
+		case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+			estimators = append(estimators, NewMempoolSpaceEstimator(source))
+		default:
+			return nil, errors.New(fmt.Sprintf("unknown fee source %s", source))
+		}
+	}
+	if len(estimators) == 0 {
+		return nil, errors.New("no fee sources configured")
+	}
+	return estimators, nil
+}
+
+// Median : the median of estimates, or 0 if there are none
+func Median(estimates []int64) int64 {
+	if len(estimates) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, estimates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// RejectOutliers : the estimates within OutlierFactor of their median
+func RejectOutliers(estimates []int64) []int64 {
+	median := Median(estimates)
+	kept := []int64{}
+	for _, estimate := range estimates {
+		if estimate*OutlierFactor >= median && estimate <= median*OutlierFactor {
+			kept = append(kept, estimate)
+		}
+	}
+	return kept
+}
+
+// Combine : the median of estimates once outliers are rejected, capped at ceiling sat/kw unless ceiling is 0
+func Combine(estimates []int64, ceiling int64) (int64, error) {
+	if len(estimates) == 0 {
+		return 0, errors.New("no fee source returned an estimate")
+	}
+	fee := Median(RejectOutliers(estimates))
+	if ceiling > 0 && fee > ceiling {
+		fee = ceiling
+	}
+	return fee, nil
+}
@@ -0,0 +1,49 @@
+package fee
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCombineRejectsOutliers(t *testing.T) {
+	// a source quoting 10x the others is ignored, and the median of the rest is used
+	fee, err := Combine([]int64{2500, 3000, 30000, 2000}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2500), fee)
+
+	fee, err = Combine([]int64{200000, 180000}, SatPerVByteToSatPerKw(500))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(125000), fee)
+
+	_, err = Combine([]int64{}, 0)
+	assert.NotNil(t, err)
+}
+
+func TestNewEstimators(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/fees/recommended", r.URL.Path)
+		w.Write([]byte(`{"fastestFee":40,"halfHourFee":20,"hourFee":10,"economyFee":4,"minimumFee":1}`))
+	}))
+	defer server.Close()
+
+	estimators, err := NewEstimators([]string{"chain", server.URL + "/", "static:8"}, "bitcoind", StaticEstimator(1000))
+	assert.Nil(t, err)
+	assert.Len(t, estimators, 3)
+	assert.Equal(t, "bitcoind", estimators[0].String())
+	estimates := []int64{}
+	for _, estimator := range estimators {
+		estimate, err := estimator.EstimateFee(2)
+		assert.Nil(t, err)
+		estimates = append(estimates, estimate)
+	}
+	assert.Equal(t, []int64{1000, 5000, 2000}, estimates)
+	hourFee, err := estimators[1].EstimateFee(6)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2500), hourFee)
+
+	_, err = NewEstimators([]string{"ftp://fees"}, "lnd", nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,68 @@
+package fee
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RecommendedFees : the sat/vB rates returned by a mempool.space-compatible /api/v1/fees/recommended endpoint
+type RecommendedFees struct {
+	FastestFee  float64 `json:"fastestFee"`
+	HalfHourFee float64 `json:"halfHourFee"`
+	HourFee     float64 `json:"hourFee"`
+	EconomyFee  float64 `json:"economyFee"`
+	MinimumFee  float64 `json:"minimumFee"`
+}
+
+// MempoolSpaceEstimator : estimates from mempool.space, or a self-hosted instance of its api
+type MempoolSpaceEstimator struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewMempoolSpaceEstimator : creates an estimator for the mempool.space api at baseURL, e.g. https://mempool.space
+func NewMempoolSpaceEstimator(baseURL string) *MempoolSpaceEstimator {
+	return &MempoolSpaceEstimator{
+		URL:    strings.TrimRight(baseURL, "/"),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// EstimateFee : picks the recommended rate whose wait best matches confTarget and converts it to sat/kw
+func (m *MempoolSpaceEstimator) EstimateFee(confTarget int64) (int64, error) {
+	resp, err := m.Client.Get(m.URL + "/api/v1/fees/recommended")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(fmt.Sprintf("%s responded with status %d", m.URL, resp.StatusCode))
+	}
+	fees := RecommendedFees{}
+	if err := json.NewDecoder(resp.Body).Decode(&fees); err != nil {
+		return 0, err
+	}
+	var satPerVByte float64
+	switch {
+	case confTarget <= 1:
+		satPerVByte = fees.FastestFee
+	case confTarget <= 3:
+		satPerVByte = fees.HalfHourFee
+	case confTarget <= 6:
+		satPerVByte = fees.HourFee
+	default:
+		satPerVByte = fees.EconomyFee
+	}
+	if satPerVByte <= 0 {
+		return 0, errors.New(fmt.Sprintf("%s returned no estimate", m.URL))
+	}
+	return SatPerVByteToSatPerKw(satPerVByte), nil
+}
+
+func (m *MempoolSpaceEstimator) String() string {
+	return m.URL
+}
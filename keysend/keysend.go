@@ -0,0 +1,103 @@
+package keysend
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/chainpoint/chainpoint-core/pricing"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/record"
+)
+
+// HashesRecordType : the keysend TLV record carrying a list of hashes to anchor. Its value is each hash in turn, prefixed
+// with its length in bytes
+const HashesRecordType uint64 = 696970
+
+// hash lengths accepted in the hashes record, as for the hash api
+const (
+	minHashLength = 20
+	maxHashLength = 64
+)
+
+var legacyHashRegex = regexp.MustCompile("^[a-fA-F0-9]{64}$")
+
+// EncodeHashes : encodes hex hashes as the value of a HashesRecordType record
+func EncodeHashes(hashes []string) ([]byte, error) {
+	value := []byte{}
+	for _, hash := range hashes {
+		hashBytes, err := hex.DecodeString(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(hashBytes) < minHashLength || len(hashBytes) > maxHashLength {
+			return nil, errors.New(fmt.Sprintf("hash %s is not %d to %d bytes", hash, minHashLength, maxHashLength))
+		}
+		value = append(append(value, byte(len(hashBytes))), hashBytes...)
+	}
+	return value, nil
+}
+
+// DecodeHashes : decodes the value of a HashesRecordType record into hex hashes
+func DecodeHashes(value []byte) ([]string, error) {
+	hashes := []string{}
+	for len(value) > 0 {
+		length := int(value[0])
+		if length < minHashLength || length > maxHashLength || len(value) < 1+length {
+			return nil, errors.New("malformed hashes record")
+		}
+		hashes = append(hashes, hex.EncodeToString(value[1:1+length]))
+		value = value[1+length:]
+	}
+	return hashes, nil
+}
+
+// InvoiceHashes : the hashes a keysend invoice pays for, without duplicates. These are the hashes of its HashesRecordType
+// records, or for older gateways a single 32 byte hash in any other custom record or the memo
+func InvoiceHashes(invoice *lnrpc.Invoice) ([]string, error) {
+	hashes := []string{}
+	var legacyHash string
+	for _, htlc := range invoice.Htlcs {
+		for recordType, value := range htlc.CustomRecords {
+			switch recordType {
+			case HashesRecordType:
+				recordHashes, err := DecodeHashes(value)
+				if err != nil {
+					return nil, err
+				}
+				hashes = append(hashes, recordHashes...)
+			case record.KeySendType, pricing.CreditRecordType:
+			default:
+				if legacyHashRegex.MatchString(hex.EncodeToString(value)) {
+					legacyHash = hex.EncodeToString(value)
+				}
+			}
+		}
+	}
+	if legacyHashRegex.MatchString(invoice.Memo) {
+		legacyHash = invoice.Memo
+	}
+	if len(hashes) == 0 && legacyHash != "" {
+		hashes = append(hashes, legacyHash)
+	}
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, hash := range hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			unique = append(unique, hash)
+		}
+	}
+	return unique, nil
+}
+
+// InvoiceGateway : the gateway whose credit a keysend invoice tops up, or empty if it names none
+func InvoiceGateway(invoice *lnrpc.Invoice) string {
+	for _, htlc := range invoice.Htlcs {
+		if value, exists := htlc.CustomRecords[pricing.CreditRecordType]; exists {
+			return string(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package keysend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chainpoint/chainpoint-core/pricing"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoiceHashes(t *testing.T) {
+	sha256Hash := strings.Repeat("ab", 32)
+	sha512Hash := strings.Repeat("cd", 64)
+	value, err := EncodeHashes([]string{sha256Hash, sha512Hash, sha256Hash})
+	assert.Nil(t, err)
+	assert.Len(t, value, 33+65+33)
+
+	invoice := &lnrpc.Invoice{Htlcs: []*lnrpc.InvoiceHTLC{{CustomRecords: map[uint64][]byte{
+		HashesRecordType:         value,
+		record.KeySendType:       make([]byte, 32),
+		pricing.CreditRecordType: []byte("10.0.0.1"),
+	}}}}
+	hashes, err := InvoiceHashes(invoice)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{sha256Hash, sha512Hash}, hashes, "duplicates are dropped and the preimage is not a hash")
+	assert.Equal(t, "10.0.0.1", InvoiceGateway(invoice))
+
+	// older gateways send a single hash in the memo
+	hashes, err = InvoiceHashes(&lnrpc.Invoice{Memo: sha256Hash})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{sha256Hash}, hashes)
+
+	_, err = DecodeHashes(value[:40])
+	assert.NotNil(t, err)
+	_, err = EncodeHashes([]string{"abcd"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Entry kinds
+const (
+	AnchorFee    = "anchor_fee"    // fee of a bitcoin anchor tx
+	FeeBump      = "fee_bump"      // fee added to an unconfirmed anchor tx by replacing it or paying for it with a child
+	AnchorReward = "anchor_reward" // reward paid to the Core that last anchored
+	ChannelOpen  = "channel_open"  // lightning channel funded to stake with a validator
+	LnReceipt    = "ln_receipt"    // settled lightning invoice or keysend, such as payment for hash submissions
+)
+
+// ledgerPrefix : entries are keyed by ledgerPrefix, their time in unix nanoseconds and a sequence number, so they iterate in time order
+const ledgerPrefix = "ledger:"
+
+// Entry : a movement of the Core's funds. Amount is the satoshis received, or sent if negative, and Fee the on-chain fee
+// paid on top of it, so an entry changes the Core's funds by Amount - Fee
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Amount int64     `json:"amount"`
+	Fee    int64     `json:"fee"`
+	TxID   string    `json:"txid,omitempty"`   // bitcoin txid, or the payment hash of lightning receipts
+	Detail string    `json:"detail,omitempty"` // e.g. the rewarded Core, channel peer or invoice memo
+}
+
+// Net : the change in the Core's funds from this entry
+func (e Entry) Net() int64 {
+	return e.Amount - e.Fee
+}
+
+// Ledger : an append-only record of the Core's spends and receipts
+type Ledger struct {
+	db  dbm.DB
+	seq uint64
+}
+
+// NewLedger : creates a ledger stored in db
+func NewLedger(db dbm.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+func entryKey(t time.Time, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d:%010d", ledgerPrefix, t.UnixNano(), seq))
+}
+
+// Record : appends entry, timestamped now if it has no time
+func (l *Ledger) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return l.db.Set(entryKey(entry.Time, atomic.AddUint64(&l.seq, 1)), entryJSON)
+}
+
+// Entries : the entries recorded from from up to but excluding to, oldest first
+func (l *Ledger) Entries(from time.Time, to time.Time) ([]Entry, error) {
+	it, err := l.db.Iterator(entryKey(from, 0), entryKey(to, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	entries := []Entry{}
+	for ; it.Valid(); it.Next() {
+		var entry Entry
+		if err := json.Unmarshal(it.Value(), &entry); err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Totals : the net change in funds from entries of each kind, and overall under "net"
+func Totals(entries []Entry) map[string]int64 {
+	totals := map[string]int64{"net": 0}
+	for _, entry := range entries {
+		totals[entry.Kind] += entry.Net()
+		totals["net"] += entry.Net()
+	}
+	return totals
+}
+
+// WriteCSV : writes entries to w as CSV with a header row
+func WriteCSV(w io.Writer, entries []Entry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"time", "kind", "amount", "fee", "net", "txid", "detail"}); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		row := []string{
+			entry.Time.UTC().Format(time.RFC3339Nano),
+			entry.Kind,
+			strconv.FormatInt(entry.Amount, 10),
+			strconv.FormatInt(entry.Fee, 10),
+			strconv.FormatInt(entry.Net(), 10),
+			entry.TxID,
+			entry.Detail,
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
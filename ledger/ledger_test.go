@@ -0,0 +1,34 @@
+package ledger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestLedgerEntriesAndTotals(t *testing.T) {
+	l := NewLedger(dbm.NewMemDB())
+	start := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, l.Record(Entry{Time: start.Add(2 * time.Hour), Kind: LnReceipt, Amount: 2, TxID: "rhash", Detail: "keysend"}))
+	assert.Nil(t, l.Record(Entry{Time: start, Kind: AnchorFee, Fee: 1530, TxID: "anchor"}))
+	assert.Nil(t, l.Record(Entry{Time: start.Add(time.Hour), Kind: AnchorReward, Amount: -1000, Fee: 141, TxID: "reward", Detail: "core"}))
+	assert.Nil(t, l.Record(Entry{Time: start.Add(48 * time.Hour), Kind: AnchorFee, Fee: 1200, TxID: "later"}))
+
+	entries, err := l.Entries(start, start.Add(24*time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "anchor", entries[0].TxID, "entries are listed oldest first")
+	assert.Equal(t, map[string]int64{"net": -2669, AnchorFee: -1530, AnchorReward: -1141, LnReceipt: 2}, Totals(entries))
+
+	var csv bytes.Buffer
+	assert.Nil(t, WriteCSV(&csv, entries[:2]))
+	assert.Equal(t, []string{
+		"time,kind,amount,fee,net,txid,detail",
+		"2022-03-01T00:00:00Z,anchor_fee,0,1530,-1530,anchor,",
+		"2022-03-01T01:00:00Z,anchor_reward,-1000,141,-1141,reward,core",
+	}, strings.Split(strings.TrimSpace(csv.String()), "\n"))
+}
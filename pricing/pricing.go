@@ -0,0 +1,255 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/types"
+)
+
+const CREDIT_KEY_PREFIX = "HashCredit:"
+const VOLUME_KEY_PREFIX = "HashVolume:"
+
+// CreditRecordType : the keysend TLV record holding the IP of the gateway whose credit a payment tops up
+const CreditRecordType uint64 = 696969
+
+// volumeWindow : the window tiers count a gateway's hashes over, kept in hourly buckets
+const volumeWindow = 24 * time.Hour
+
+// Payment : the outcome of paying for hashes over keysend. Amounts are in millisatoshis
+type Payment struct {
+	Accepted  bool  // the payment alone covered the hashes
+	Cost      int64 // price of the hashes
+	Credit    int64 // the gateway's credit afterwards
+	Unclaimed int64 // overpayment that could not be credited, since the payment named no gateway
+}
+
+// Engine : prices hash submissions by gateway, volume and bitcoin fees, and keeps gateways' prepaid credit in the cache
+type Engine struct {
+	config types.HashPricingConfig
+	cache  *level.KVStore
+	mutex  sync.Mutex
+}
+
+// NewEngine : creates a pricing engine storing credit and volume in cache
+func NewEngine(config types.HashPricingConfig, cache *level.KVStore) *Engine {
+	tiers := append([]types.PriceTier{}, config.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+	config.Tiers = tiers
+	return &Engine{config: config, cache: cache}
+}
+
+// Price : the price per hash in millisatoshis for gateway, an IP or empty if unknown, when bitcoin fees are satPerKw
+func (e *Engine) Price(gateway string, satPerKw int64) int64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.price(gateway, satPerKw)
+}
+
+func (e *Engine) price(gateway string, satPerKw int64) int64 {
+	price := e.config.BasePriceMsat
+	if gatewayPrice, exists := e.config.GatewayPrices[gateway]; exists && gateway != "" {
+		price = gatewayPrice
+	} else if gateway != "" {
+		volume := e.volume(gateway, time.Now())
+		for _, tier := range e.config.Tiers {
+			if volume >= tier.MinVolume {
+				price = tier.PriceMsat
+			}
+		}
+	}
+	if e.config.FeeReference > 0 && satPerKw > e.config.FeeReference {
+		price = price * satPerKw / e.config.FeeReference
+	}
+	return price
+}
+
+// Credit : gateway's prepaid credit in millisatoshis
+func (e *Engine) Credit(gateway string) (int64, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.credit(gateway)
+}
+
+func (e *Engine) credit(gateway string) (int64, error) {
+	creditStr, err := e.cache.Get(CREDIT_KEY_PREFIX + gateway)
+	if err != nil || creditStr == "" {
+		return 0, err
+	}
+	return strconv.ParseInt(creditStr, 10, 64)
+}
+
+func (e *Engine) setCredit(gateway string, msat int64) error {
+	return e.cache.Set(CREDIT_KEY_PREFIX+gateway, strconv.FormatInt(msat, 10))
+}
+
+// Charge : draws n hashes' price from gateway's credit. Returns false, leaving the credit untouched, if it doesn't cover them
+func (e *Engine) Charge(gateway string, n int64, satPerKw int64) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	credit, err := e.credit(gateway)
+	if err != nil {
+		return false, err
+	}
+	cost := e.price(gateway, satPerKw) * n
+	if credit < cost {
+		return false, nil
+	}
+	if err := e.setCredit(gateway, credit-cost); err != nil {
+		return false, err
+	}
+	return true, e.addVolume(gateway, n, time.Now())
+}
+
+// Pay : applies a keysend payment of paidMsat for n hashes naming gateway. The gateway named by a keysend isn't
+// authenticated, so the hashes are charged at the base price, must be covered by the payment alone, and don't count
+// towards gateway's volume. Whatever the payment doesn't spend on the hashes, including the whole payment if it falls
+// short, is credited to gateway, or reported as unclaimed if it names none
+func (e *Engine) Pay(gateway string, paidMsat int64, n int64, satPerKw int64) (Payment, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	payment := Payment{Cost: e.price("", satPerKw) * n}
+	remainder := paidMsat
+	if n > 0 && paidMsat >= payment.Cost {
+		payment.Accepted = true
+		remainder -= payment.Cost
+	}
+	if gateway == "" {
+		payment.Unclaimed = remainder
+		return payment, nil
+	}
+	credit, err := e.credit(gateway)
+	if err != nil {
+		return payment, err
+	}
+	payment.Credit = credit + remainder
+	return payment, e.setCredit(gateway, payment.Credit)
+}
+
+// Refund : credits msat back to gateway and takes n hashes back off its volume, for hashes it paid for that weren't accepted
+func (e *Engine) Refund(gateway string, msat int64, n int64) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if gateway == "" {
+		return nil
+	}
+	credit, err := e.credit(gateway)
+	if err != nil {
+		return err
+	}
+	if err := e.setCredit(gateway, credit+msat); err != nil {
+		return err
+	}
+	return e.addVolume(gateway, -n, time.Now())
+}
+
+// AddVolume : counts n hashes paid for some other way, such as an LSAT, towards gateway's volume tier
+func (e *Engine) AddVolume(gateway string, n int64) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.addVolume(gateway, n, time.Now())
+}
+
+// hourlyVolume : hashes submitted by a gateway, keyed by the unix hour they were submitted in
+type hourlyVolume map[int64]int64
+
+func (e *Engine) hourlyVolume(gateway string) hourlyVolume {
+	volume := hourlyVolume{}
+	volumeJSON, err := e.cache.Get(VOLUME_KEY_PREFIX + gateway)
+	if err == nil && volumeJSON != "" {
+		json.Unmarshal([]byte(volumeJSON), &volume)
+	}
+	return volume
+}
+
+// volume : the hashes gateway submitted in the volume window before now
+func (e *Engine) volume(gateway string, now time.Time) int64 {
+	oldest := now.Add(-volumeWindow).Unix() / 3600
+	var total int64
+	for hour, count := range e.hourlyVolume(gateway) {
+		if hour > oldest {
+			total += count
+		}
+	}
+	return total
+}
+
+func (e *Engine) addVolume(gateway string, n int64, now time.Time) error {
+	if gateway == "" {
+		return nil
+	}
+	oldest := now.Add(-volumeWindow).Unix() / 3600
+	volume := e.hourlyVolume(gateway)
+	for hour := range volume {
+		if hour <= oldest {
+			delete(volume, hour)
+		}
+	}
+	volume[now.Unix()/3600] += n
+	volumeJSON, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	return e.cache.Set(VOLUME_KEY_PREFIX+gateway, string(volumeJSON))
+}
+
+// SatToMsat : converts a price in satoshis, which may be fractional, to millisatoshis
+func SatToMsat(sat float64) int64 {
+	return int64(sat * 1000)
+}
+
+// MsatToSat : the satoshis needed to pay msat, rounding up since invoices can't be for part of a satoshi
+func MsatToSat(msat int64) int64 {
+	return (msat + 999) / 1000
+}
+
+// ParseTiers : parses a comma-delimited list of <min hashes per day>:<sats per hash> tiers
+func ParseTiers(tiersStr string) ([]types.PriceTier, error) {
+	tiers := []types.PriceTier{}
+	for _, tierStr := range strings.Split(tiersStr, ",") {
+		if strings.TrimSpace(tierStr) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(tierStr), ":")
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("price tier %s is not <min volume>:<price>", tierStr))
+		}
+		minVolume, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		price, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, types.PriceTier{MinVolume: minVolume, PriceMsat: SatToMsat(price)})
+	}
+	return tiers, nil
+}
+
+// ParseGatewayPrices : parses a comma-delimited list of <gateway ip>=<sats per hash> prices
+func ParseGatewayPrices(pricesStr string) (map[string]int64, error) {
+	prices := map[string]int64{}
+	for _, priceStr := range strings.Split(pricesStr, ",") {
+		if strings.TrimSpace(priceStr) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(priceStr), "=")
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("gateway price %s is not <ip>=<price>", priceStr))
+		}
+		price, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		prices[parts[0]] = SatToMsat(price)
+	}
+	return prices, nil
+}
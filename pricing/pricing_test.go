@@ -0,0 +1,94 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestPricing(t *testing.T) {
+	db := dbm.NewDB("pricing", dbm.MemDBBackend, "")
+	cache := level.NewKVStore(&db, log.NewNopLogger())
+	tiers, err := ParseTiers("3:1,10:0.5")
+	assert.Nil(t, err)
+	gatewayPrices, err := ParseGatewayPrices("10.0.0.9=0.1")
+	assert.Nil(t, err)
+	engine := NewEngine(types.HashPricingConfig{BasePriceMsat: 2000, Tiers: tiers, GatewayPrices: gatewayPrices, FeeReference: 2500}, cache)
+
+	assert.Equal(t, int64(2000), engine.Price("10.0.0.1", 1000))
+	assert.Equal(t, int64(100), engine.Price("10.0.0.9", 1000))
+	assert.Equal(t, int64(4000), engine.Price("10.0.0.1", 5000), "prices double when fees are double the reference")
+
+	// an anonymous keysend must cover its hash alone, and its overpayment can't be credited to anyone
+	payment, err := engine.Pay("", 2500, 1, 1000)
+	assert.Nil(t, err)
+	assert.True(t, payment.Accepted)
+	assert.Equal(t, int64(500), payment.Unclaimed)
+
+	// a gateway tops up once, and the overpayment is kept as credit
+	payment, err = engine.Pay("10.0.0.1", 9000, 1, 1000)
+	assert.Nil(t, err)
+	assert.True(t, payment.Accepted)
+	assert.Equal(t, int64(7000), payment.Credit)
+	assert.Equal(t, int64(0), engine.volume("10.0.0.1", time.Now()), "keysend hashes aren't counted for the gateway they name")
+	charged, err := engine.Charge("10.0.0.1", 2, 1000)
+	assert.Nil(t, err)
+	assert.True(t, charged)
+	charged, err = engine.Charge("10.0.0.1", 1, 1000)
+	assert.Nil(t, err)
+	assert.True(t, charged)
+	assert.Equal(t, int64(1000), engine.Price("10.0.0.1", 1000), "3 hashes in 24 hours reaches the first tier")
+	charged, err = engine.Charge("10.0.0.1", 1, 1000)
+	assert.Nil(t, err)
+	assert.True(t, charged)
+	charged, err = engine.Charge("10.0.0.1", 1, 1000)
+	assert.Nil(t, err)
+	assert.False(t, charged, "the credit is spent")
+	credit, err := engine.Credit("10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), credit)
+
+	// hashes that couldn't be queued are refunded, and don't count towards the gateway's tier
+	assert.Nil(t, engine.Refund("10.0.0.1", 3000, 3))
+	credit, err = engine.Credit("10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000), credit)
+	assert.Equal(t, int64(1), engine.volume("10.0.0.1", time.Now()))
+	assert.Nil(t, engine.setCredit("10.0.0.1", 0))
+
+	// a payment too small for its hash is credited rather than lost
+	payment, err = engine.Pay("10.0.0.1", 400, 1, 1000)
+	assert.Nil(t, err)
+	assert.False(t, payment.Accepted)
+	assert.Equal(t, int64(400), payment.Credit)
+
+	// a discounted gateway named by a keysend is still charged the base price
+	payment, err = engine.Pay("10.0.0.9", 2000, 1, 1000)
+	assert.Nil(t, err)
+	assert.True(t, payment.Accepted)
+	assert.Equal(t, int64(2000), payment.Cost)
+
+	_, err = ParseTiers("1000")
+	assert.NotNil(t, err)
+}
+
+func TestKeysendCannotSpendNamedGatewayCredit(t *testing.T) {
+	db := dbm.NewDB("pricing", dbm.MemDBBackend, "")
+	engine := NewEngine(types.HashPricingConfig{BasePriceMsat: 1000}, level.NewKVStore(&db, log.NewNopLogger()))
+	payment, err := engine.Pay("10.0.0.1", 5000, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5000), payment.Credit)
+
+	// a keysend naming gateway A, paying 1 msat for 3 hashes, is refused and only adds its own payment to A's credit
+	payment, err = engine.Pay("10.0.0.1", 1, 3, 0)
+	assert.Nil(t, err)
+	assert.False(t, payment.Accepted)
+	credit, err := engine.Credit("10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5001), credit)
+}
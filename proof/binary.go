@@ -0,0 +1,181 @@
+package proof
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"regexp"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBORMediaType : Accept header value selecting raw binary proofs
+const CBORMediaType = "application/cbor"
+
+// Base64MediaType : Accept header value selecting base64-encoded binary proofs inside a JSON response
+const Base64MediaType = "application/vnd.chainpoint.json+base64"
+
+// lowercase only, so that decoding back to hex reproduces the original string exactly
+var compactHexRegex = regexp.MustCompile("^([a-f0-9]{2})+$")
+
+// hex fields stored as CBOR byte strings rather than text
+var compactHexKeys = map[string]bool{
+	"hash":           true,
+	"l":              true,
+	"r":              true,
+	"anchor_id":      true,
+	"expected_value": true,
+}
+
+// hash ops stored as small integers in place of {"op":"sha-256"} maps
+var opCodes = map[string]uint64{
+	"sha-256":    1,
+	"sha-256-x2": 2,
+	TapTweakOp:   3,
+}
+
+var opNames = map[uint64]string{
+	1: "sha-256",
+	2: "sha-256-x2",
+	3: TapTweakOp,
+}
+
+var encMode, _ = cbor.CoreDetEncOptions().EncMode()
+
+var decMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+// ToBinary encodes a proof as compact CBOR. FromBinary reverses it losslessly.
+func (proof P) ToBinary() ([]byte, error) {
+	return encMode.Marshal(compact(map[string]interface{}(proof)))
+}
+
+// ToBase64 encodes a proof as base64 binary
+func (proof P) ToBase64() (string, error) {
+	b, err := proof.ToBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// FromBinary decodes a proof produced by ToBinary
+func FromBinary(data []byte) (P, error) {
+	var decoded interface{}
+	if err := decMode.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	proofMap, ok := expand(decoded).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("binary proof is not a map")
+	}
+	return P(proofMap), nil
+}
+
+// FromBase64 decodes a proof produced by ToBase64
+func FromBase64(data string) (P, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	return FromBinary(b)
+}
+
+func compact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case P:
+		return compact(map[string]interface{}(v))
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if str, ok := item.(string); ok && compactHexKeys[key] && compactHexRegex.MatchString(str) {
+				out[key], _ = hex.DecodeString(str)
+				continue
+			}
+			if key == "ops" {
+				out[key] = compactOps(item)
+				continue
+			}
+			out[key] = compact(item)
+		}
+		return out
+	case []P:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = compact(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = compact(item)
+		}
+		return out
+	}
+	return value
+}
+
+func compactOps(ops interface{}) interface{} {
+	var items []interface{}
+	switch v := ops.(type) {
+	case []P:
+		for _, item := range v {
+			items = append(items, map[string]interface{}(item))
+		}
+	case []interface{}:
+		items = v
+	default:
+		return compact(ops)
+	}
+	out := make([]interface{}, len(items))
+	for i, item := range items {
+		if op, ok := item.(map[string]interface{}); ok && len(op) == 1 {
+			if name, ok := op["op"].(string); ok {
+				if code, exists := opCodes[name]; exists {
+					out[i] = code
+					continue
+				}
+			}
+		}
+		out[i] = compact(item)
+	}
+	return out
+}
+
+func expand(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if b, ok := item.([]byte); ok {
+				out[key] = hex.EncodeToString(b)
+				continue
+			}
+			if ops, ok := item.([]interface{}); ok && key == "ops" {
+				expanded := make([]interface{}, len(ops))
+				for i, op := range ops {
+					if code, ok := op.(uint64); ok {
+						if name, exists := opNames[code]; exists {
+							expanded[i] = map[string]interface{}{"op": name}
+							continue
+						}
+					}
+					expanded[i] = expand(op)
+				}
+				out[key] = expanded
+				continue
+			}
+			out[key] = expand(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = expand(item)
+		}
+		return out
+	}
+	return value
+}
@@ -0,0 +1,46 @@
+package proof
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	p, _ := testCalProof(t)
+	jsonBytes, err := json.Marshal(p)
+	assert.Nil(err)
+
+	binary, err := p.ToBinary()
+	assert.Nil(err)
+	assert.Less(len(binary), len(jsonBytes))
+
+	decoded, err := FromBinary(binary)
+	assert.Nil(err)
+	decodedBytes, err := json.Marshal(decoded)
+	assert.Nil(err)
+	assert.JSONEq(string(jsonBytes), string(decodedBytes))
+
+	encoded, err := p.ToBase64()
+	assert.Nil(err)
+	fromBase64, err := FromBase64(encoded)
+	assert.Nil(err)
+	assert.Equal(decoded, fromBase64)
+}
+
+func TestBinaryKeepsNonHexText(t *testing.T) {
+	assert := assert.New(t)
+	p := P{"hash": "ABCDEF", "branches": []interface{}{
+		map[string]interface{}{"ops": []interface{}{map[string]interface{}{"l": "drand:1:ab"}, map[string]interface{}{"op": "sha-512"}}},
+	}}
+	binary, err := p.ToBinary()
+	assert.Nil(err)
+	decoded, err := FromBinary(binary)
+	assert.Nil(err)
+	assert.Equal("ABCDEF", decoded["hash"])
+	ops := decoded["branches"].([]interface{})[0].(map[string]interface{})["ops"].([]interface{})
+	assert.Equal("drand:1:ab", ops[0].(map[string]interface{})["l"])
+	assert.Equal("sha-512", ops[1].(map[string]interface{})["op"])
+}
@@ -0,0 +1,66 @@
+package proof
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hash algorithms a client may declare for a submitted hash
+const (
+	SHA256     = "sha-256"
+	SHA3256    = "sha3-256"
+	BLAKE2B256 = "blake2b-256"
+	SHA512256  = "sha-512/256"
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	SHA256:  sha256.New,
+	SHA3256: sha3.New256,
+	BLAKE2B256: func() hash.Hash {
+		h, _ := blake2b.New256(nil) // only fails for keys over 64 bytes
+		return h
+	},
+	SHA512256: sha512.New512_256,
+}
+
+// IsHashAlgorithm : whether the algorithm name is supported
+func IsHashAlgorithm(algorithm string) bool {
+	_, exists := hashAlgorithms[algorithm]
+	return exists
+}
+
+// ValidateHashForAlgorithm : checks that a hex hash is the digest length of the declared algorithm
+func ValidateHashForAlgorithm(hexHash string, algorithm string) error {
+	newHash, exists := hashAlgorithms[algorithm]
+	if !exists {
+		return fmt.Errorf("unsupported hash algorithm %s", algorithm)
+	}
+	hashBytes, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return err
+	}
+	if size := newHash().Size(); len(hashBytes) != size {
+		return fmt.Errorf("%s hashes are %d bytes, got %d", algorithm, size, len(hashBytes))
+	}
+	return nil
+}
+
+// HashDocument : hashes the document read from r with the given algorithm, returning the hex digest
+func HashDocument(r io.Reader, algorithm string) (string, error) {
+	newHash, exists := hashAlgorithms[algorithm]
+	if !exists {
+		return "", fmt.Errorf("unsupported hash algorithm %s", algorithm)
+	}
+	h := newHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,30 @@
+package proof
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashDocument(t *testing.T) {
+	expected := map[string]string{
+		SHA256:     "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		SHA3256:    "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+		BLAKE2B256: "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+		SHA512256:  "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23",
+	}
+	for algorithm, digest := range expected {
+		hash, err := HashDocument(strings.NewReader("abc"), algorithm)
+		assert.Nil(t, err)
+		assert.Equal(t, digest, hash, algorithm)
+		assert.Nil(t, ValidateHashForAlgorithm(hash, algorithm))
+	}
+	_, err := HashDocument(strings.NewReader("abc"), "md5")
+	assert.NotNil(t, err)
+}
+
+func TestValidateHashForAlgorithmRejectsWrongLength(t *testing.T) {
+	assert.NotNil(t, ValidateHashForAlgorithm(strings.Repeat("ab", 20), SHA3256))
+	assert.NotNil(t, ValidateHashForAlgorithm(strings.Repeat("ab", 32), "sha-1"))
+}
@@ -39,6 +39,14 @@ func (proof *P) AddChainpointHeader(context string, jsonType string, hash string
 	return nil
 }
 
+// AddHashAlgorithm : records the algorithm the client declared for the submitted hash. Proofs of hashes
+// submitted without one keep the original header
+func (proof *P) AddHashAlgorithm(algorithm string) {
+	if algorithm != "" {
+		(*proof)["hash_algorithm"] = algorithm
+	}
+}
+
 func ConvertGoOpsToJsonMap(ops []types.ProofLineItem) []P {
 	opsJsonArray := make([]P, 0)
 	for _, op := range ops {
@@ -64,15 +72,18 @@ func ConvertGoOpsToJsonMap(ops []types.ProofLineItem) []P {
 func (proof *P) AddCalendarBranch(aggState types.AggState, calState string, proofType string) error {
 	calendarBranch := make(map[string]interface{})
 	calendarBranch["label"] = "cal_anchor_branch"
-	aggStateOps := types.OpsState{}
-	if err := json.Unmarshal([]byte(aggState.AggState), &aggStateOps); err != nil {
-		return err
+	aggStateOps := types.OpsState{Ops: aggState.Ops, Entropy: aggState.Entropy}
+	if aggStateOps.Ops == nil {
+		if err := json.Unmarshal([]byte(aggState.AggState), &aggStateOps); err != nil {
+			return err
+		}
 	}
 	calStateOps := types.AnchorOpsState{}
 	if err := json.Unmarshal([]byte(calState), &calStateOps); err != nil {
 		return err
 	}
-	ops := append(aggStateOps.Ops, calStateOps.Ops...)
+	ops := make([]types.ProofLineItem, 0, len(aggStateOps.Ops)+len(calStateOps.Ops))
+	ops = append(append(ops, aggStateOps.Ops...), calStateOps.Ops...)
 	opsJson := ConvertGoOpsToJsonMap(ops)
 
 	calendarAnchor := make(map[string]interface{})
@@ -86,6 +97,9 @@ func (proof *P) AddCalendarBranch(aggState types.AggState, calState string, proo
 
 	calendarBranch["ops"] = opsJson
 	(*proof)["branches"] = []P{calendarBranch}
+	if aggStateOps.Entropy != nil {
+		(*proof)["entropy"] = *aggStateOps.Entropy
+	}
 	return nil
 }
 
@@ -117,10 +131,100 @@ func (proof *P) AddChainBranch(btcAggState types.AnchorBtcAggState, btcTxState t
 	opsJson = append(opsJson, anchorOp)
 	btcBranch["ops"] = opsJson
 
-	if _, branchExists := (*proof)["branches"]; branchExists && len((*proof)["branches"].([]P)) > 0 {
-		(*proof)["branches"].([]P)[0]["branches"] = []P{btcBranch}
-	} else {
-		(*proof)["branches"] = []P{btcBranch}
+	proof.addAnchorBranch(btcBranch)
+	return nil
+}
+
+// AddEthBranch : adds an eth_anchor_branch leading from the calendar anchor through the anchor aggregation ops and the evm tx ops to the tx hash
+func (proof *P) AddEthBranch(aggOps []types.ProofLineItem, txOps []types.ProofLineItem, anchor types.AnchorObj, proofType string) {
+	ethBranch := make(map[string]interface{})
+	ethBranch["label"] = "eth_anchor_branch"
+	ops := make([]types.ProofLineItem, 0, len(aggOps)+len(txOps))
+	ops = append(append(ops, aggOps...), txOps...)
+	opsJson := ConvertGoOpsToJsonMap(ops)
+
+	ethAnchor := make(map[string]interface{})
+	ethAnchor["type"] = proofType
+	ethAnchor["anchor_id"] = anchor.AnchorID
+	ethAnchor["uris"] = anchor.Uris
+
+	anchorOp := make(map[string]interface{})
+	anchorOp["anchors"] = []P{ethAnchor}
+	opsJson = append(opsJson, anchorOp)
+	ethBranch["ops"] = opsJson
+
+	proof.addAnchorBranch(ethBranch)
+}
+
+// Branches : the top level branches of the proof
+func (proof P) Branches() []P {
+	return toBranches(proof["branches"])
+}
+
+// AnchorBranches : the chain anchor branches nested under the calendar branch
+func (proof P) AnchorBranches() []P {
+	branches := proof.Branches()
+	if len(branches) == 0 {
+		return nil
+	}
+	return toBranches(branches[0]["branches"])
+}
+
+// MergeAnchorBranches : adds the anchor branches of other, such as a previously stored copy of the proof, that proof lacks
+func (proof *P) MergeAnchorBranches(other P) {
+	if len(toBranches((*proof)["branches"])) == 0 {
+		return
+	}
+	labels := make(map[interface{}]bool)
+	for _, branch := range proof.AnchorBranches() {
+		labels[branch["label"]] = true
+	}
+	for _, branch := range other.AnchorBranches() {
+		if !labels[branch["label"]] {
+			proof.addAnchorBranch(branch)
+		}
+	}
+}
+
+// addAnchorBranch : nests a chain anchor branch under the calendar branch, replacing any branch with the same label.
+// Proofs without a calendar branch get it as their only branch
+func (proof *P) addAnchorBranch(branch P) {
+	branches := toBranches((*proof)["branches"])
+	if len(branches) == 0 {
+		(*proof)["branches"] = []P{branch}
+		return
+	}
+	children := toBranches(branches[0]["branches"])
+	replaced := false
+	for i, child := range children {
+		if child["label"] == branch["label"] {
+			children[i] = branch
+			replaced = true
+		}
+	}
+	if !replaced {
+		children = append(children, branch)
+	}
+	branches[0]["branches"] = children
+	(*proof)["branches"] = branches
+}
+
+// toBranches : reads a branch list built by this package or decoded from stored json
+func toBranches(value interface{}) []P {
+	switch branches := value.(type) {
+	case []P:
+		return branches
+	case []interface{}:
+		result := make([]P, 0, len(branches))
+		for _, branch := range branches {
+			switch b := branch.(type) {
+			case P:
+				result = append(result, b)
+			case map[string]interface{}:
+				result = append(result, P(b))
+			}
+		}
+		return result
 	}
 	return nil
 }
@@ -0,0 +1,31 @@
+package proof
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// TapTweakOp : replaces a 32 byte x-only internal key followed by a 32 byte commitment with the x-only taproot output key
+// that commits to it, as computed by BIP 341 for a script tree root
+const TapTweakOp = "tap-tweak"
+
+// TapTweak : the x-only output key of internalKey tweaked with commitment, which BIP 341 treats as a script tree root
+func TapTweak(internalKey []byte, commitment []byte) ([]byte, error) {
+	if len(commitment) != 32 {
+		return nil, errors.New("taproot commitments must be 32 bytes")
+	}
+	pubKey, err := schnorr.ParsePubKey(internalKey)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.SerializePubKey(txscript.ComputeTaprootOutputKey(pubKey, commitment)), nil
+}
+
+func applyTapTweak(value []byte) ([]byte, error) {
+	if len(value) != 64 {
+		return nil, errors.New("tap-tweak requires an internal key and commitment of 32 bytes each")
+	}
+	return TapTweak(value[:32], value[32:])
+}
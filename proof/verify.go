@@ -0,0 +1,205 @@
+package proof
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/chainpoint/chainpoint-core/types"
+	"golang.org/x/crypto/sha3"
+)
+
+var hexValueRegex = regexp.MustCompile("^([a-fA-F0-9]{2})+$")
+
+// Anchor : an anchor object found in the "anchors" op of a proof branch
+type Anchor struct {
+	Type     string   `json:"type"`
+	AnchorID string   `json:"anchor_id"`
+	Uris     []string `json:"uris"`
+}
+
+// Op : a single proof operation. Exactly one field is set per op
+type Op struct {
+	Left    string   `json:"l,omitempty"`
+	Right   string   `json:"r,omitempty"`
+	Op      string   `json:"op,omitempty"`
+	Anchors []Anchor `json:"anchors,omitempty"`
+}
+
+// Branch : a labeled list of ops, optionally continued by child branches
+type Branch struct {
+	Label    string   `json:"label"`
+	Ops      []Op     `json:"ops"`
+	Branches []Branch `json:"branches,omitempty"`
+}
+
+// Document : typed view of a Chainpoint v5 proof. Entropy is outside the v5 ops and describes the first ops of the first branch
+type Document struct {
+	Context      string         `json:"@context"`
+	Type         string         `json:"type"`
+	Hash         string         `json:"hash"`
+	ProofID      string         `json:"proof_id"`
+	HashReceived string         `json:"hash_received"`
+	Entropy      *types.Entropy `json:"entropy,omitempty"`
+	Branches     []Branch       `json:"branches"`
+}
+
+// AnchorVerifier confirms that an anchor attests to the value computed by replaying a branch
+type AnchorVerifier interface {
+	VerifyAnchor(anchor Anchor, value []byte) error
+}
+
+// BranchVerdict : the result of verifying a single anchor in a proof branch
+type BranchVerdict struct {
+	Label         string `json:"label"`
+	AnchorType    string `json:"anchor_type"`
+	AnchorID      string `json:"anchor_id"`
+	ExpectedValue string `json:"expected_value"`
+	Verified      bool   `json:"verified"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ToDocument converts a proof map into its typed form
+func (proof P) ToDocument() (Document, error) {
+	var doc Document
+	proofBytes, err := json.Marshal(proof)
+	if err != nil {
+		return Document{}, err
+	}
+	if err := json.Unmarshal(proofBytes, &doc); err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+// Verify replays every op in every branch of a proof, starting from the proof's hash.
+// Each anchor encountered yields a verdict. If verifier is nil, only the op replay is checked.
+func Verify(proof P, verifier AnchorVerifier) ([]BranchVerdict, error) {
+	doc, err := proof.ToDocument()
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Branches) == 0 {
+		return nil, errors.New("proof contains no branches")
+	}
+	start, err := hex.DecodeString(doc.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof hash: %s", err.Error())
+	}
+	verdicts := make([]BranchVerdict, 0)
+	for i, branch := range doc.Branches {
+		if i == 0 && doc.Entropy != nil {
+			if err := checkEntropy(*doc.Entropy, branch.Ops); err != nil {
+				verdicts = append(verdicts, BranchVerdict{Label: branch.Label, Verified: false, Error: err.Error()})
+				continue
+			}
+		}
+		verdicts = append(verdicts, verifyBranch(branch, start, verifier)...)
+	}
+	return verdicts, nil
+}
+
+func verifyBranch(branch Branch, value []byte, verifier AnchorVerifier) []BranchVerdict {
+	verdicts := make([]BranchVerdict, 0)
+	current := value
+	for i, op := range branch.Ops {
+		if len(op.Anchors) > 0 {
+			for _, anchor := range op.Anchors {
+				verdict := BranchVerdict{
+					Label:         branch.Label,
+					AnchorType:    anchor.Type,
+					AnchorID:      anchor.AnchorID,
+					ExpectedValue: ExpectedAnchorValue(anchor.Type, current),
+					Verified:      true,
+				}
+				if verifier != nil {
+					if err := verifier.VerifyAnchor(anchor, current); err != nil {
+						verdict.Verified = false
+						verdict.Error = err.Error()
+					}
+				}
+				verdicts = append(verdicts, verdict)
+			}
+			continue
+		}
+		next, err := ApplyOp(op, current)
+		if err != nil {
+			return append(verdicts, BranchVerdict{
+				Label:    branch.Label,
+				Verified: false,
+				Error:    fmt.Sprintf("op %d: %s", i, err.Error()),
+			})
+		}
+		current = next
+	}
+	for _, child := range branch.Branches {
+		verdicts = append(verdicts, verifyBranch(child, current, verifier)...)
+	}
+	return verdicts
+}
+
+// ApplyOp applies a single l/r/sha-256/sha-256-x2/keccak-256/tap-tweak operation to a value
+func ApplyOp(op Op, value []byte) ([]byte, error) {
+	switch {
+	case len(op.Left) > 0:
+		return append(decodeOpValue(op.Left), value...), nil
+	case len(op.Right) > 0:
+		return append(append([]byte{}, value...), decodeOpValue(op.Right)...), nil
+	case op.Op == "sha-256":
+		sum := sha256.Sum256(value)
+		return sum[:], nil
+	case op.Op == "sha-256-x2":
+		first := sha256.Sum256(value)
+		second := sha256.Sum256(first[:])
+		return second[:], nil
+	case op.Op == "keccak-256":
+		hash := sha3.NewLegacyKeccak256()
+		hash.Write(value)
+		return hash.Sum(nil), nil
+	case op.Op == TapTweakOp:
+		return applyTapTweak(value)
+	}
+	return nil, fmt.Errorf("unsupported op %+v", op)
+}
+
+// checkEntropy confirms a proof's entropy names a known source and that the first ops of its branch prepend its prefix.
+// The entropy value itself is checked against the drand round or Calendar block it names, outside of the proof
+func checkEntropy(entropy types.Entropy, next []Op) error {
+	switch entropy.Source {
+	case types.EntropySourceNone:
+		return nil
+	case types.EntropySourceDrand, types.EntropySourceTendermint:
+		if len(next) == 0 || next[0].Left != entropy.Prefix() {
+			return fmt.Errorf("%s entropy is not prepended by the following op", entropy.Source)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown entropy source %q", entropy.Source)
+}
+
+// decodeOpValue treats l/r values as hex when possible, otherwise as utf-8 (e.g. drand prefixes)
+func decodeOpValue(value string) []byte {
+	if hexValueRegex.MatchString(value) {
+		decoded, err := hex.DecodeString(value)
+		if err == nil {
+			return decoded
+		}
+	}
+	return []byte(value)
+}
+
+// ExpectedAnchorValue formats a computed branch value the way the anchor records it.
+// Bitcoin merkle roots are displayed byte-reversed.
+func ExpectedAnchorValue(anchorType string, value []byte) string {
+	if anchorType == "btc" || anchorType == "tbtc" {
+		reversed := make([]byte, len(value))
+		for i, b := range value {
+			reversed[len(value)-1-i] = b
+		}
+		return hex.EncodeToString(reversed)
+	}
+	return hex.EncodeToString(value)
+}
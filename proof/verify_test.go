@@ -0,0 +1,140 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/sha3"
+)
+
+type rejectingVerifier struct{}
+
+func (rejectingVerifier) VerifyAnchor(anchor Anchor, value []byte) error {
+	return errors.New("rejected")
+}
+
+func testCalProof(t *testing.T) (P, string) {
+	return testCalProofWithEntropy(t, types.Entropy{Source: types.EntropySourceDrand, Round: 1, Value: "ab"})
+}
+
+func testCalProofWithEntropy(t *testing.T, entropy types.Entropy) (P, string) {
+	hash := "1957db7fe23e4be1740ddeb941ddda7ae0a6b782e536a9e00b5aa82db1e84547"
+	sibling := "2a98bcca858e7b9f3528959ee15a8421e60b257281d6dbbf074233f826cc0e95"
+	aggOps, _ := json.Marshal(types.OpsState{Ops: []types.ProofLineItem{{Left: "drand:1:ab"}, {Op: "sha-256"}}, Entropy: &entropy})
+	calOps, _ := json.Marshal(types.AnchorOpsState{
+		Ops:    []types.ProofLineItem{{Right: sibling}, {Op: "sha-256"}},
+		Anchor: types.AnchorObj{AnchorID: "cde5", Uris: []string{"http://127.0.0.1/calendar/cde5/data"}},
+	})
+	p := Proof()
+	assert.Nil(t, p.AddChainpointHeader("https://w3id.org/chainpoint/v5", "Chainpoint", hash, "01FXZ6Q2M8H1Y3E8Z0RZ6Q2M8H"))
+	assert.Nil(t, p.AddCalendarBranch(types.AggState{Hash: hash, AggState: string(aggOps)}, string(calOps), "cal"))
+
+	hashBytes, _ := hex.DecodeString(hash)
+	siblingBytes, _ := hex.DecodeString(sibling)
+	leaf := sha256.Sum256(append([]byte("drand:1:ab"), hashBytes...))
+	root := sha256.Sum256(append(leaf[:], siblingBytes...))
+	return p, hex.EncodeToString(root[:])
+}
+
+func TestVerifyReplaysCalBranch(t *testing.T) {
+	assert := assert.New(t)
+	p, expected := testCalProof(t)
+	verdicts, err := Verify(p, nil)
+	assert.Nil(err)
+	assert.Equal(1, len(verdicts))
+	assert.Equal("cal_anchor_branch", verdicts[0].Label)
+	assert.Equal(expected, verdicts[0].ExpectedValue)
+	assert.True(verdicts[0].Verified)
+}
+
+func TestCalBranchOpsAreV5Only(t *testing.T) {
+	assert := assert.New(t)
+	p, _ := testCalProof(t)
+	doc, err := p.ToDocument()
+	assert.Nil(err)
+	assert.Equal(types.EntropySourceDrand, doc.Entropy.Source)
+	for _, op := range p["branches"].([]P)[0]["ops"].([]P) {
+		assert.NotContains(op, "entropy")
+	}
+}
+
+func TestVerifyReportsAnchorFailure(t *testing.T) {
+	assert := assert.New(t)
+	p, _ := testCalProof(t)
+	verdicts, err := Verify(p, rejectingVerifier{})
+	assert.Nil(err)
+	assert.False(verdicts[0].Verified)
+	assert.Equal("rejected", verdicts[0].Error)
+}
+
+func TestVerifyRejectsMismatchedEntropy(t *testing.T) {
+	assert := assert.New(t)
+	p, _ := testCalProofWithEntropy(t, types.Entropy{Source: types.EntropySourceTendermint, Round: 1, Value: "ab"})
+	verdicts, err := Verify(p, nil)
+	assert.Nil(err)
+	assert.False(verdicts[0].Verified)
+	assert.Contains(verdicts[0].Error, "tendermint entropy")
+}
+
+func TestExpectedAnchorValueReversesBtc(t *testing.T) {
+	assert.Equal(t, "0201", ExpectedAnchorValue("btc", []byte{0x01, 0x02}))
+	assert.Equal(t, "0102", ExpectedAnchorValue("cal", []byte{0x01, 0x02}))
+}
+
+func TestEthBranchMergesWithBtcBranch(t *testing.T) {
+	assert := assert.New(t)
+	stored, calRoot := testCalProof(t)
+	stored.AddEthBranch(nil, []types.ProofLineItem{{Left: "f8"}, {Right: "80"}, {Op: "keccak-256"}}, types.AnchorObj{AnchorID: "12"}, "teth")
+	storedJSON, _ := json.Marshal(stored)
+	decoded := Proof()
+	assert.Nil(json.Unmarshal(storedJSON, &decoded))
+
+	fresh, _ := testCalProof(t)
+	btcTx, _ := json.Marshal(types.OpsState{Ops: []types.ProofLineItem{{Left: "01"}, {Op: "sha-256-x2"}}})
+	btcHead, _ := json.Marshal(types.AnchorOpsState{Anchor: types.AnchorObj{AnchorID: "600000"}})
+	assert.Nil(fresh.AddChainBranch(types.AnchorBtcAggState{AnchorBtcAggState: `{"ops":[]}`}, types.AnchorBtcTxState{BtcTxState: string(btcTx)},
+		types.AnchorBtcHeadState{BtcHeadState: string(btcHead)}, "btc"))
+	fresh.MergeAnchorBranches(decoded)
+	fresh.MergeAnchorBranches(decoded)
+
+	branches := fresh.AnchorBranches()
+	assert.Len(branches, 2)
+	assert.Equal("btc_anchor_branch", branches[0]["label"])
+	assert.Equal("eth_anchor_branch", branches[1]["label"])
+
+	verdicts, err := Verify(fresh, nil)
+	assert.Nil(err)
+	assert.Len(verdicts, 3)
+	rootBytes, _ := hex.DecodeString(calRoot)
+	keccak := sha3.NewLegacyKeccak256()
+	keccak.Write(append(append([]byte{0xf8}, rootBytes...), 0x80))
+	assert.Equal("eth_anchor_branch", verdicts[2].Label)
+	assert.Equal("teth", verdicts[2].AnchorType)
+	assert.Equal(hex.EncodeToString(keccak.Sum(nil)), verdicts[2].ExpectedValue)
+}
+
+func TestTapTweakOpCommitsToValue(t *testing.T) {
+	assert := assert.New(t)
+	privKey, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{7}, 32))
+	internalKey := hex.EncodeToString(schnorr.SerializePubKey(privKey.PubKey()))
+	root := decodeOpValue("5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21")
+	value, err := ApplyOp(Op{Left: internalKey}, root)
+	assert.Nil(err)
+	outputKey, err := ApplyOp(Op{Op: TapTweakOp}, value)
+	assert.Nil(err)
+	// the output key is the one the tweaked private key spends with
+	tweaked := txscript.TweakTaprootPrivKey(privKey, root)
+	assert.Equal(hex.EncodeToString(schnorr.SerializePubKey(tweaked.PubKey())), hex.EncodeToString(outputKey))
+
+	_, err = ApplyOp(Op{Op: TapTweakOp}, root)
+	assert.NotNil(err)
+}
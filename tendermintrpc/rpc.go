@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/tendermint/tendermint/crypto/tmhash"
 	"github.com/tendermint/tendermint/libs/log"
 	rpchttp "github.com/tendermint/tendermint/rpc/client/http"
 	core_types "github.com/tendermint/tendermint/rpc/core/types"
+	"strings"
 
 	"github.com/chainpoint/chainpoint-core/util"
 )
@@ -47,6 +49,31 @@ func (rpc *RPC) BroadcastTx(txType string, data string, version int64, time int6
 	return *result, nil
 }
 
+// BroadcastTxAndHash : broadcasts a transaction like BroadcastTx, also returning its hash so a tx whose broadcast failed
+// ambiguously, such as by timing out after the node accepted it, can be looked up with FindTx
+func (rpc *RPC) BroadcastTxAndHash(txType string, data string, version int64, time int64, stackID string, privateKey *ecdsa.PrivateKey) (core_types.ResultBroadcastTx, []byte, error) {
+	tx := types.Tx{TxType: txType, Data: data, Version: version, Time: time, CoreID: stackID}
+	rawTx := []byte(util.EncodeTxWithKey(tx, privateKey))
+	hash := tmhash.Sum(rawTx)
+	result, err := rpc.client.BroadcastTxSync(rawTx)
+	if rpc.LogError(err) != nil {
+		return core_types.ResultBroadcastTx{}, hash, err
+	}
+	return *result, hash, nil
+}
+
+// FindTx : looks up a committed tx by its hash. found is false, without an error, if the node has no such tx
+func (rpc *RPC) FindTx(hash []byte) (result core_types.ResultTx, found bool, err error) {
+	txResult, err := rpc.client.Tx(hash, false)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return core_types.ResultTx{}, false, nil
+		}
+		return core_types.ResultTx{}, false, err
+	}
+	return *txResult, true, nil
+}
+
 // BroadcastTx : Synchronously broadcasts a transaction to the local Tendermint node
 func (rpc *RPC) BroadcastTxWithMeta(txType string, data string, version int64, time int64, stackID string, meta string, privateKey *ecdsa.PrivateKey) (core_types.ResultBroadcastTx, error) {
 	tx := types.Tx{TxType: txType, Data: data, Version: version, Time: time, CoreID: stackID, Meta: meta}
@@ -181,25 +208,72 @@ func (rpc *RPC) GetIndexForCalTx(txid string) (int64, error) {
 
 // GetBtcaForCalTx : retrieve the corresponding btca tx for a given calendar tx
 func (rpc *RPC) GetBtcaForCalTx(txid string) (types.BtcTxMsg, error) {
+	return rpc.GetAnchorForCalTx("BTC-A", txid)
+}
+
+// anchorConfirmation : the query finding the confirmation tx of an anchor tx, and the tag holding its chain height
+type anchorConfirmation struct {
+	Query     string
+	HeightTag string
+}
+
+// anchorConfirmations : confirmations of the anchor tx types whose ranges are anchored again when their tx fails
+var anchorConfirmations = map[string]anchorConfirmation{
+	"ETH-A": {Query: "ETH-C.ETHCTX='%s'", HeightTag: "ETHCBH"},
+}
+
+// GetAnchorForCalTx : retrieve the anchor tx of type txType (e.g. BTC-A or ETH-A) whose CAL range covers a given calendar tx.
+// A range anchored again after its tx failed is covered by several anchor txs, so the confirmed one is preferred
+func (rpc *RPC) GetAnchorForCalTx(txType string, txid string) (types.BtcTxMsg, error) {
 	index, err := rpc.GetIndexForCalTx(txid)
 	if err != nil {
 		return types.BtcTxMsg{}, err
 	}
-	queryLine := fmt.Sprintf("BTC-A.TxInt>%d", index)
-	txResult, err := rpc.client.TxSearch(queryLine, false, 1, 5, "asc")
+	queryLine := fmt.Sprintf("%s.TxInt>%d", txType, index)
+	txResult, err := rpc.client.TxSearch(queryLine, false, 1, 20, "asc")
 	if rpc.LogError(err) != nil {
 		return types.BtcTxMsg{}, err
 	}
+	var covering []types.BtcTxMsg
 	for _, res := range txResult.Txs {
 		tx, err := util.DecodeTx(res.Tx)
 		if err == nil {
 			btcMsg := types.BtcTxMsg{}
 			if err := json.Unmarshal([]byte(tx.Data), &btcMsg); rpc.LogError(err) == nil && index >= btcMsg.BeginCalTxInt && index < btcMsg.EndCalTxInt {
+				covering = append(covering, btcMsg)
+			}
+		}
+	}
+	if len(covering) == 0 {
+		return types.BtcTxMsg{}, errors.New(fmt.Sprintf("No matches from %d results for cal index %d", txResult.TotalCount, index))
+	}
+	if confirmation, exists := anchorConfirmations[txType]; exists {
+		for _, btcMsg := range covering {
+			if _, height := rpc.GetConfirmationHeight(fmt.Sprintf(confirmation.Query, btcMsg.BtcTxID), confirmation.HeightTag); height != 0 {
 				return btcMsg, nil
 			}
 		}
 	}
-	return types.BtcTxMsg{}, errors.New(fmt.Sprintf("No matches from %d results for cal index %d", txResult.TotalCount, index))
+	return covering[0], nil
+}
+
+// GetBtcReplacement : retrieves the BTC-R tx announcing the replacement of the btc tx txid, if it has been replaced
+func (rpc *RPC) GetBtcReplacement(txid string) (types.BtcTxMsg, bool) {
+	txResult, err := rpc.client.TxSearch(fmt.Sprintf("BTC-R.REPLACES='%s'", txid), false, 1, 1, "")
+	if rpc.LogError(err) != nil {
+		return types.BtcTxMsg{}, false
+	}
+	for _, res := range txResult.Txs {
+		tx, err := util.DecodeTx(res.Tx)
+		if rpc.LogError(err) != nil {
+			continue
+		}
+		btcMsg := types.BtcTxMsg{}
+		if rpc.LogError(json.Unmarshal([]byte(tx.Data), &btcMsg)) == nil {
+			return btcMsg, true
+		}
+	}
+	return types.BtcTxMsg{}, false
 }
 
 //GetAnchoringCore : gets core to whom last anchor is attributed
@@ -232,15 +306,19 @@ func (rpc *RPC) GetBTCCForBtcRoot(btcMonObj types.BtcMonMsg) (hash []byte) {
 
 // GetBTCCForBtcTx: retrieves and verifies existence of btcc tx
 func (rpc *RPC) GetAnchorHeight(btcTxObj types.BtcTxMsg) ([]byte, int64) {
-	btccQueryLine := fmt.Sprintf("BTC-C.BTCCTX='%s'", btcTxObj.BtcTxID)
-	txResult, err := rpc.client.TxSearch(btccQueryLine, false, 1, 1, "")
+	return rpc.GetConfirmationHeight(fmt.Sprintf("BTC-C.BTCCTX='%s'", btcTxObj.BtcTxID), "BTCCBH")
+}
+
+// GetConfirmationHeight : retrieves the hash of the confirmation tx matching queryLine and the chain height recorded in its heightTag
+func (rpc *RPC) GetConfirmationHeight(queryLine string, heightTag string) ([]byte, int64) {
+	txResult, err := rpc.client.TxSearch(queryLine, false, 1, 1, "")
 	if rpc.LogError(err) == nil {
 		for _, tx := range txResult.Txs {
 			for _, tags := range tx.TxResult.Events {
 				for _, pairs := range tags.Attributes {
-					if string(pairs.Key) == "BTCCBH" {
+					if string(pairs.Key) == heightTag {
 						blockHeight := util.ByteToInt64(string(pairs.Value))
-						rpc.logger.Info(fmt.Sprint("Found BTC-C Height %d from btctx %s", blockHeight, btcTxObj.BtcTxID))
+						rpc.logger.Info(fmt.Sprintf("Found confirmation height %d for %s", blockHeight, queryLine))
 						return tx.Hash, blockHeight
 					}
 				}
@@ -250,6 +328,25 @@ func (rpc *RPC) GetAnchorHeight(btcTxObj types.BtcTxMsg) ([]byte, int64) {
 	return []byte{}, 0
 }
 
+// GetAnchorTx : retrieves the anchor message of the first tx matching queryLine, e.g. ETH-A.ETHTX='<txid>'
+func (rpc *RPC) GetAnchorTx(queryLine string) (types.BtcTxMsg, error) {
+	txResult, err := rpc.client.TxSearch(queryLine, false, 1, 1, "")
+	if rpc.LogError(err) != nil {
+		return types.BtcTxMsg{}, err
+	}
+	for _, res := range txResult.Txs {
+		tx, err := util.DecodeTx(res.Tx)
+		if rpc.LogError(err) != nil {
+			continue
+		}
+		anchorMsg := types.BtcTxMsg{}
+		if err := json.Unmarshal([]byte(tx.Data), &anchorMsg); rpc.LogError(err) == nil {
+			return anchorMsg, nil
+		}
+	}
+	return types.BtcTxMsg{}, errors.New(fmt.Sprintf("no anchor tx found for %s", queryLine))
+}
+
 // getAllJWKs gets all JWK TXs
 func (rpc *RPC) GetAllJWKs() ([]types.Tx, error) {
 	Txs := []types.Tx{}
@@ -293,3 +390,67 @@ func (rpc *RPC) GetAllCHNGSTK() ([]types.Tx, error) {
 	}
 	return Txs, nil
 }
+
+// GetBtcTxIdsInRange : gets the bitcoin tx ids confirmed by BTC-C txs broadcast between from and to (unix seconds, inclusive)
+func (rpc *RPC) GetBtcTxIdsInRange(from int64, to int64) ([]string, error) {
+	btcTxIds := []string{}
+	fromHeight, err := rpc.heightAtTime(from)
+	if err != nil {
+		return nil, err
+	}
+	toHeight, err := rpc.heightAtTime(to + 1)
+	if err != nil {
+		return nil, err
+	}
+	// a BTC-C broadcast before to may be committed a few blocks later
+	queryLine := fmt.Sprintf("BTC-C.TxInt>0 AND tx.height>=%d AND tx.height<=%d", fromHeight, toHeight+inclusionBlocks)
+	endPage := 2
+	for i := 1; i <= endPage; i++ {
+		txResult, err := rpc.client.TxSearch(queryLine, false, i, 100, "asc")
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txResult.Txs {
+			decoded, err := util.DecodeTx(tx.Tx)
+			if rpc.LogError(err) != nil || decoded.Time < from || decoded.Time > to {
+				continue
+			}
+			btcc := types.BtcMonMsg{}
+			if err := json.Unmarshal([]byte(decoded.Data), &btcc); err != nil || btcc.BtcTxID == "" {
+				continue
+			}
+			btcTxIds = append(btcTxIds, btcc.BtcTxID)
+		}
+		endPage = (txResult.TotalCount / 100) + 1
+	}
+	return util.UniquifyStrings(btcTxIds), nil
+}
+
+// inclusionBlocks : blocks a tx may wait in the mempool between being broadcast and committed
+const inclusionBlocks = 10
+
+// heightAtTime : the height of the first block committed at or after t (unix seconds), found by binary search over the
+// blocks this node stores, or the height after the latest block if none was
+func (rpc *RPC) heightAtTime(t int64) (int64, error) {
+	status, err := rpc.client.Status()
+	if err != nil {
+		return 0, err
+	}
+	low, high := status.SyncInfo.EarliestBlockHeight, status.SyncInfo.LatestBlockHeight+1
+	if low < 1 {
+		low = 1
+	}
+	for low < high {
+		mid := low + (high-low)/2
+		commit, err := rpc.client.Commit(&mid)
+		if err != nil {
+			return 0, err
+		}
+		if commit.SignedHeader.Header.Time.Unix() < t {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
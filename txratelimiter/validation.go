@@ -245,7 +245,7 @@ func Validate(incoming []byte, state *types.AnchorState) (types.Tx, bool, error)
 	case "BTC-E":
 		validated = true
 		break
-	case "BTC-A":
+	case "BTC-A", "BTC-R", "ETH-A":
 		RateLimitUpdate(state.Height, &validationRecord.BtcaAllowedRate)
 		if !IsHabitualViolator(validationRecord.BtcaAllowedRate) || IsValidator(coreID, state) {
 			validated = true
@@ -253,7 +253,7 @@ func Validate(incoming []byte, state *types.AnchorState) (types.Tx, bool, error)
 			validationRecord.LastBtcaTxHeight = state.Height
 		}
 		break
-	case "BTC-C":
+	case "BTC-C", "ETH-C":
 		validated = true
 		RateLimitUpdate(state.Height, &validationRecord.BtccAllowedRate)
 		if !(IsHabitualViolator(validationRecord.BtccAllowedRate) || IsValidator(coreID, state)) {
@@ -262,6 +262,12 @@ func Validate(incoming []byte, state *types.AnchorState) (types.Tx, bool, error)
 			validationRecord.LastBtccTxHeight = state.Height
 		}
 		break
+	case "DRAND":
+		if IsValidator(coreID, state) {
+			validated = true
+			validationRecord.LastDrandTxHeight = state.Height
+		}
+		break
 	case "FEE":
 		i, err := strconv.ParseInt(tx.Data, 10, 64)
 		RateLimitUpdate(state.Height, &validationRecord.FeeAllowedRate)
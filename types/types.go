@@ -3,12 +3,16 @@ package types
 import (
 	"crypto/ecdsa"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/chainpoint/chainpoint-core/beacon"
 	lightning "github.com/chainpoint/lightning-go"
 	lnrpc2 "github.com/lightningnetwork/lnd/lnrpc"
 	coretypes "github.com/tendermint/tendermint/rpc/core/types"
 	types3 "github.com/tendermint/tendermint/types"
 	"math/big"
 	"net/http"
+	"time"
 
 	"github.com/tendermint/tendermint/privval"
 
@@ -28,16 +32,25 @@ type TendermintConfig struct {
 	NodeKey  *p2p.NodeKey
 }
 
-//AnchorConfig represents values to configure all connections within the ABCI anchor app
+// AnchorConfig represents values to configure all connections within the ABCI anchor app
 type AnchorConfig struct {
 	HomePath               string
 	APIPort                string
+	AdminAPIAddr           string
 	ChainId                string
 	DBType                 string
+	PostgresURI            string
+	PostgresPort           int
+	Retention              RetentionPolicy
 	BitcoinNetwork         string
 	ElectionMode           string
 	TendermintConfig       TendermintConfig
 	LightningConfig        lightning.LightningClient
+	BtcBackend             string // "lnd" or "bitcoind"; where bitcoin anchor txs are sent and the chain is read from
+	BitcoindConfig         BitcoindConfig
+	SpendPolicy            SpendPolicyConfig
+	HashPricing            HashPricingConfig
+	BtcCommitment          string // "op_return" or "taproot"; how bitcoin anchor txs commit to the anchor root
 	EthConfig              EthConfig
 	ECPrivateKey           *ecdsa.PrivateKey
 	DoNodeManagement       bool
@@ -46,6 +59,7 @@ type AnchorConfig struct {
 	IPBlockList            []string
 	DoCal                  bool
 	DoAnchor               bool
+	DoEthAnchor            bool
 	AnchorInterval         int
 	Logger                 *log.Logger
 	FilePV                 privval.FilePV
@@ -55,6 +69,9 @@ type AnchorConfig struct {
 	UpdateStake            string
 	FeeInterval            int64
 	FeeMultiplier          float64
+	FeeSources             []string // parsed by fee.NewEstimators
+	FeeConfTarget          int64
+	FeeCeiling             int64 // sat/kw
 	HashPrice              int
 	UseAllowlist           bool
 	GatewayAllowlist       []string
@@ -64,17 +81,118 @@ type AnchorConfig struct {
 	ProposedVal            string
 	RemoveRateLimits       bool
 	HashQuota              int
+	MaxHashesPerBatch      int
+	BeaconURLs             []string // drand http apis polled for rounds, tried in order
+	EntropyFallback        bool
 	ApiQuota               int
 	ProofQuota             int
 	UseChainpointLndConfig bool
 }
 
-//EthConfig holds contract addresses and eth node URI
+// State types tracked by RetentionPolicy and PruneReport
+const (
+	ProofStateType        = "proof"
+	AggStateType          = "aggstate"
+	CalStateType          = "calstate"
+	AnchorBtcAggStateType = "anchorbtcaggstate"
+	BtcTxStateType        = "btctxstate"
+	AnchorProofStateType  = "anchorproof"
+)
+
+// RetentionPolicy : how long each type of proof state is kept before being pruned. A TTL <= 0 disables pruning for that type
+type RetentionPolicy struct {
+	ProofTTL             time.Duration
+	AggStateTTL          time.Duration
+	CalStateTTL          time.Duration
+	AnchorBtcAggStateTTL time.Duration
+	BtcTxStateTTL        time.Duration
+	BatchSize            int
+}
+
+// TTL : returns the retention period for a state type
+func (policy RetentionPolicy) TTL(stateType string) time.Duration {
+	switch stateType {
+	case ProofStateType, AnchorProofStateType:
+		return policy.ProofTTL
+	case AggStateType:
+		return policy.AggStateTTL
+	case CalStateType:
+		return policy.CalStateTTL
+	case AnchorBtcAggStateType:
+		return policy.AnchorBtcAggStateTTL
+	case BtcTxStateType:
+		return policy.BtcTxStateTTL
+	}
+	return 0
+}
+
+// PruneReport : number of records removed per state type during a single pruning run
+type PruneReport struct {
+	Pruned   map[string]int
+	Duration time.Duration
+}
+
+// Total : number of records removed across all state types
+func (report PruneReport) Total() int {
+	total := 0
+	for _, count := range report.Pruned {
+		total += count
+	}
+	return total
+}
+
+// BitcoindConfig holds the bitcoind JSON-RPC endpoint and wallet used when BtcBackend is bitcoind
+type BitcoindConfig struct {
+	RPCURL  string
+	RPCUser string
+	RPCPass string
+	Wallet  string // empty to use bitcoind's default wallet
+}
+
+// SpendPolicyConfig holds the limits on what the anchoring wallet may spend, in satoshis. A limit of 0 disables it
+type SpendPolicyConfig struct {
+	MaxAnchorFee int64 // fee of a single anchor tx, including any fee bumps
+	CapAnchorFee bool  // send anchor txs that would exceed MaxAnchorFee at the highest rate within it, rather than refusing them
+	DailyBudget  int64 // fees and rewards spent in the last 24 hours
+	WeeklyBudget int64 // fees and rewards spent in the last 7 days
+	MinReserve   int64 // confirmed wallet balance that must remain after a spend
+}
+
+// PriceTier : the per-hash price charged to gateways that submitted at least MinVolume hashes in the last 24 hours
+type PriceTier struct {
+	MinVolume int64
+	PriceMsat int64
+}
+
+// HashPricingConfig holds what gateways pay per hash, in millisatoshis
+type HashPricingConfig struct {
+	BasePriceMsat int64            // price for gateways without a tier or their own price
+	Tiers         []PriceTier      // volume discounts, ordered by MinVolume
+	GatewayPrices map[string]int64 // prices for particular gateway IPs, overriding the tiers
+	FeeReference  int64            // sat/kw at which prices are charged as configured; higher bitcoin fees raise prices in proportion. 0 disables
+}
+
+// SpendStatus : the anchoring wallet's spend policy and how much of its budgets have been spent, as reported by /status
+type SpendStatus struct {
+	MaxAnchorFee int64  `json:"max_anchor_fee"`
+	CapAnchorFee bool   `json:"cap_anchor_fee"`
+	DailyBudget  int64  `json:"daily_budget"`
+	DailySpent   int64  `json:"daily_spent"`
+	WeeklyBudget int64  `json:"weekly_budget"`
+	WeeklySpent  int64  `json:"weekly_spent"`
+	MinReserve   int64  `json:"min_reserve_balance"`
+	Refusals     int64  `json:"refusals"`
+	LastRefusal  string `json:"last_refusal,omitempty"`
+}
+
+// EthConfig holds contract addresses and eth node URI
 type EthConfig struct {
 	EthereumURL          string
 	EthPrivateKey        string
 	TokenContractAddr    string
 	RegistryContractAddr string
+	AnchorInterval       int   // Calendar blocks between EVM anchors
+	Confirmations        int64 // EVM blocks an anchor tx must be buried under before it is confirmed with ETH-C
 }
 
 // AnchorState holds Tendermint/ABCI application state. Persisted by ABCI app
@@ -84,18 +202,10 @@ type AnchorState struct {
 	Height            int64                      `json:"height"`
 	AmValidator       bool                       `json:"validator"`
 	AppHash           []byte                     `json:"app_hash"`
-	BeginCalTxInt     int64                      `json:"begin_cal_int"`
-	EndCalTxInt       int64                      `json:"end_cal_int"`
 	LatestCalTxInt    int64                      `json:"latest_cal_int"`
 	CurrentCalInts    int64                      `json:"current_cal_ints"`
+	Anchors           map[string]*AnchorCursor   `json:"anchors"`
 	LatestBtcaTx      []byte                     `json:"latest_btca"`
-	LatestBtcaTxInt   int64                      `json:"latest_btca_int"`
-	LatestBtcaHeight  int64                      `json:"latest_btca_height"`
-	LatestBtcTx       string                     `json:"latest_btc"`
-	LatestBtcAggRoot  string                     `json:"latest_btc_root"`
-	LatestBtccTx      []byte                     `json:"latest_btcc"`
-	LatestBtccTxInt   int64                      `json:"latest_btcc_int"`
-	LatestBtccHeight  int64                      `json:"latest_btcc_height"`
 	LatestErrRoot     string                     `json:"latest_btce"`
 	LastElectedCoreID string                     `json:"last_elected_core_id"`
 	LastAnchorCoreID  string                     `json:"last_anchor_core_id"`
@@ -115,12 +225,94 @@ type AnchorState struct {
 	LnStakePerVal     int64 `json:"validator_stake_price"`
 	LatestNistRecord  string
 	LatestTimeRecord  string
+	LatestBlockHash   string  `json:"latest_block_hash"`
+	LatestBlockHeight int64   `json:"latest_block_height"`
+	LatestEntropy     Entropy `json:"latest_entropy"`
+	LatestDrandRound  int64   `json:"latest_drand_round"`
+	LatestDrandHeight int64   `json:"latest_drand_height"`
 	LatestBtcFee      int64
 	LastBtcFeeHeight  int64
 	Migrations        map[int]string `json:"migrations"`
+	DrandNetwork      beacon.Network `json:"drand_network"` // set from genesis, and empty for the League of Entropy mainnet
 	AppReady          bool           `json:"-"`
 }
 
+// Drand : the drand chain whose rounds the Calendar accepts
+func (state *AnchorState) Drand() beacon.Network {
+	if state.DrandNetwork.PublicKey == "" {
+		return beacon.LeagueOfEntropy
+	}
+	return state.DrandNetwork
+}
+
+// Cursor : the cursor of the named anchor engine, or nil if it was never added. Cursor only reads state.Anchors, so it is
+// safe to call from engine goroutines while DeliverTx updates the cursors
+func (state *AnchorState) Cursor(name string) *AnchorCursor {
+	return state.Anchors[name]
+}
+
+// AddCursor : creates the cursor of the named anchor engine if it has none. Cursors must all be added when the state is
+// loaded, before any goroutine reads state.Anchors
+func (state *AnchorState) AddCursor(name string) *AnchorCursor {
+	if state.Anchors == nil {
+		state.Anchors = map[string]*AnchorCursor{}
+	}
+	cursor, exists := state.Anchors[name]
+	if !exists {
+		cursor = &AnchorCursor{}
+		state.Anchors[name] = cursor
+	}
+	return cursor
+}
+
+// AnchorCursor : an anchor engine's position in the Calendar, i.e. the range of CAL txs it anchors next and its latest anchor and confirmation txs
+type AnchorCursor struct {
+	BeginCalTxInt       int64  `json:"begin_cal_int"`
+	EndCalTxInt         int64  `json:"end_cal_int"`
+	LatestAnchorHeight  int64  `json:"latest_anchor_height"`
+	LatestAnchorTxInt   int64  `json:"latest_anchor_int"`
+	LatestAnchorTx      string `json:"latest_anchor_tx"`
+	LatestAnchorRoot    string `json:"latest_anchor_root"`
+	LatestConfirmHeight int64  `json:"latest_confirm_height"`
+	LatestConfirmTxInt  int64  `json:"latest_confirm_int"`
+	// LatestConfirmed : what the latest confirmation tx confirmed, which engines compare with the confirmation they are about
+	// to issue. A BTC-C confirms a btc block by its merkle root, and an ETH-C an evm anchor tx by its tx id
+	LatestConfirmed string `json:"latest_confirm_tx"`
+	// Announced : the Core that announced each anchor tx still awaiting confirmation, keyed by tx id. It is recorded in
+	// DeliverTx, so txs replacing or confirming an anchor tx are checked against consensus state rather than a node's tx index
+	Announced map[string]Announcement `json:"announced,omitempty"`
+}
+
+// Announcement : the Core that announced an anchor tx, and the Calendar height it was announced at
+type Announcement struct {
+	CoreID string `json:"core_id"`
+	Height int64  `json:"height"`
+}
+
+// Announce : records coreID as the announcer of txID at height, and forgets announcements made before oldest, whose txs
+// will never be confirmed or replaced
+func (cursor *AnchorCursor) Announce(txID string, coreID string, height int64, oldest int64) {
+	if cursor.Announced == nil {
+		cursor.Announced = map[string]Announcement{}
+	}
+	for id, announcement := range cursor.Announced {
+		if announcement.Height < oldest {
+			delete(cursor.Announced, id)
+		}
+	}
+	cursor.Announced[txID] = Announcement{CoreID: coreID, Height: height}
+}
+
+// Announcer : the Core that announced txID, or empty if it was never announced or has been confirmed or forgotten since
+func (cursor *AnchorCursor) Announcer(txID string) string {
+	return cursor.Announced[txID].CoreID
+}
+
+// Forget : drops the announcement of txID once it has been confirmed or replaced
+func (cursor *AnchorCursor) Forget(txID string) {
+	delete(cursor.Announced, txID)
+}
+
 type LnIdentity struct {
 	Peer            string `json:"peer"`
 	RequiredChanAmt int64  `json:"required_satoshis"`
@@ -167,6 +359,8 @@ type TxValidation struct {
 	LastNISTTxHeight int64 // last "good", non-stale nist record
 	NISTAllowedRate  RateLimit
 
+	LastDrandTxHeight int64
+
 	LastFeeTxHeight       int64
 	FeeAllowedRate        RateLimit
 	FeeValidationFailures int64
@@ -200,8 +394,9 @@ type Aggregation struct {
 
 // HashItem : An object contains the Core ID and value for a hash
 type HashItem struct {
-	ProofID string `json:"proof_id"`
-	Hash    string `json:"hash"`
+	ProofID       string `json:"proof_id"`
+	Hash          string `json:"hash"`
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
 }
 
 // ProofData : The proof data for a given hash within an aggregation
@@ -223,13 +418,28 @@ type CalStateObject struct {
 	CalState string `json:"cal_state"`
 }
 
-// AggState : agg state for proof gen
+// AggState : agg state for proof gen. Fresh aggregations carry their ops in Ops, which are only marshalled into AggState when stored
 type AggState struct {
-	ProofID  string `json:"proof_id"`
-	Hash     string `json:"hash"`
-	AggID    string `json:"agg_id"`
-	AggState string `json:"agg_state"`
-	AggRoot  string `json:"agg_root"`
+	ProofID       string          `json:"proof_id"`
+	Hash          string          `json:"hash"`
+	HashAlgorithm string          `json:"hash_algorithm,omitempty"`
+	AggID         string          `json:"agg_id"`
+	AggState      string          `json:"agg_state"`
+	AggRoot       string          `json:"agg_root"`
+	Ops           []ProofLineItem `json:"-"`
+	Entropy       *Entropy        `json:"-"`
+}
+
+// OpsJSON : the serialized agg state ops, marshalling Ops if they haven't been serialized yet
+func (aggState AggState) OpsJSON() (string, error) {
+	if aggState.AggState != "" || aggState.Ops == nil {
+		return aggState.AggState, nil
+	}
+	opsBytes, err := json.Marshal(OpsState{Ops: aggState.Ops, Entropy: aggState.Entropy})
+	if err != nil {
+		return "", err
+	}
+	return string(opsBytes), nil
 }
 
 type AnchorBtcAggState struct {
@@ -283,6 +493,11 @@ type BtcTxMsg struct {
 	CalBlockHeight   int64  `json:"cal_block_height"`
 	BeginCalTxInt    int64  `json:"begin_cal_int"`
 	EndCalTxInt      int64  `json:"end_cal_int"`
+	// TaprootInternalKey is the x-only key whose taproot output commits to the root. Empty if the root is in an OP_RETURN output
+	TaprootInternalKey string `json:"taproot_internal_key,omitempty"`
+	CoreID             string `json:"core_id,omitempty"`           // the Core whose wallet sent the tx
+	FeeRate            int64  `json:"fee_rate,omitempty"`          // sat/kw the tx was sent or last bumped with
+	ReplacesBtcTxID    string `json:"replaces_btctx_id,omitempty"` // set in BTC-R txs to the tx this one replaced by fee
 }
 
 // BtcTxMsg : An RMQ message object from btc-tx to btc-mon service
@@ -301,6 +516,8 @@ type BtcTxProofState struct {
 // OpsState : An RMQ message generated as part of the monitoring proof object
 type OpsState struct {
 	Ops []ProofLineItem `json:"ops"`
+	// Entropy records where the leaf prefix in the first ops came from. It's kept out of Ops so paths only contain v5 ops
+	Entropy *Entropy `json:"entropy,omitempty"`
 }
 
 // BtccStateObj :  An RMQ message object issued to generate proofs after BTCC confirmation
@@ -363,6 +580,31 @@ type ProofLineItem struct {
 	Op    string `json:"op,omitempty"`
 }
 
+// Entropy sources recorded in proofs
+const (
+	EntropySourceDrand      = "drand"      // a drand beacon round
+	EntropySourceTendermint = "tendermint" // a Chainpoint Calendar block hash, used when no drand beacon is reachable
+	EntropySourceNone       = "none"       // no entropy was available and leaves are unprefixed
+)
+
+// Entropy : the public randomness prefixed to every leaf of an aggregation tree. Round is the drand round or the
+// Calendar block height, Value the drand randomness or block hash, and Beacon the drand url or Calendar chain id
+type Entropy struct {
+	Source string `json:"source"`
+	Beacon string `json:"beacon,omitempty"`
+	Round  int64  `json:"round,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// Prefix : the value prepended to each leaf before hashing, or "" if leaves are unprefixed
+func (e Entropy) Prefix() string {
+	switch e.Source {
+	case EntropySourceDrand, EntropySourceTendermint:
+		return fmt.Sprintf("%s:%d:%s", e.Source, e.Round, e.Value)
+	}
+	return ""
+}
+
 // JSProof : Used to unmarshall the Javascript MerkleTools proofs. The library generates a different proof structure than the go version.
 type JSProof struct {
 	Left  string `json:"left,omitempty"`
@@ -377,7 +619,7 @@ type Core struct {
 	BlockNumber sql.NullInt64
 }
 
-//Jwk : holds key info for validating node requests
+// Jwk : holds key info for validating node requests
 type Jwk struct {
 	Kty string `json:"kty"`
 	Kid string `json:"kid"`
@@ -386,7 +628,7 @@ type Jwk struct {
 	Y   string `json:"y"`
 }
 
-//CoreAPIStatus : status from Core's api service. Includes pubkey
+// CoreAPIStatus : status from Core's api service. Includes pubkey
 type CoreAPIStatus struct {
 	Version          string `json:"version"`
 	Time             string `json:"time"`
@@ -407,19 +649,26 @@ type CoreAPIStatus struct {
 	TotalStakePrice     int64                   `json:"total_stake_price"`
 	ValidatorStakePrice int64                   `json:"validator_stake_price"`
 	ActiveChannelsCount int                     `json:"num_channels_count"`
+	SpendPolicy         *SpendStatus            `json:"spend_policy,omitempty"`
 	NodeInfo            p2p.DefaultNodeInfo     `json:"node_info"`
 	SyncInfo            coretypes.SyncInfo      `json:"sync_info"`
 	ValidatorInfo       coretypes.ValidatorInfo `json:"-"`
 }
 
 type APIHandlers struct {
-	HomeHandler         http.Handler
-	HashHandler         http.Handler
-	ProofHandler        http.Handler
-	ProofUpgradeHandler http.Handler
-	CalHandler          http.Handler
-	CalDataHandler      http.Handler
-	StatusHandler       http.Handler
-	PeerHandler         http.Handler
-	GatewaysHandler     http.Handler
+	HomeHandler          http.Handler
+	HashHandler          http.Handler
+	HashesHandler        http.Handler
+	HashDocumentHandler  http.Handler
+	ProofHandler         http.Handler
+	PaymentProofsHandler http.Handler
+	ProofUpgradeHandler  http.Handler
+	ProofVerifyHandler   http.Handler
+	ProofStreamHandler   http.Handler
+	CalHandler           http.Handler
+	CalDataHandler       http.Handler
+	StatusHandler        http.Handler
+	PeerHandler          http.Handler
+	GatewaysHandler      http.Handler
+	CreditHandler        http.Handler
 }
@@ -0,0 +1,343 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/util"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Delivery stages
+const (
+	StageCal = "cal"
+	StageBtc = "btc"
+	StageEth = "eth"
+)
+
+const registrationPrefix = "webhook:"
+const outboxPrefix = "webhook_outbox:"
+
+const maxAttempts = 10
+const baseBackoff = 10 * time.Second
+const maxBackoff = time.Hour
+const pollInterval = 5 * time.Second
+const maxConcurrentDeliveries = 8
+
+// registrationTTL : how long a callback registration is kept for a proof that never reaches the final stage
+const registrationTTL = 24 * time.Hour
+const pruneInterval = time.Hour
+
+// Delivery : a persisted outbox entry for a single proof notification
+type Delivery struct {
+	ProofID     string `json:"proof_id"`
+	Stage       string `json:"stage"`
+	URL         string `json:"url"`
+	Proof       string `json:"proof"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt int64  `json:"next_attempt"`
+}
+
+// Registration : a callback url registered for a proof, and when it was registered
+type Registration struct {
+	URL        string `json:"url"`
+	Registered int64  `json:"registered"`
+}
+
+// Payload : the JSON body POSTed to a callback url
+type Payload struct {
+	ProofID string          `json:"proof_id"`
+	Stage   string          `json:"stage"`
+	Proof   json.RawMessage `json:"proof"`
+}
+
+// Notifier : delivers signed proof notifications to callback urls registered at hash submission
+type Notifier struct {
+	Cache      *level.KVStore
+	PrivateKey *ecdsa.PrivateKey
+	KeyID      string
+	Client     *http.Client
+	Logger     log.Logger
+	FinalStage string // the last stage this Core delivers, after which registrations are removed
+	running    int32
+}
+
+// NewNotifier : creates a notifier persisting registrations and its outbox in cache. finalStage is StageEth on Cores that
+// anchor to evm chains, and StageBtc otherwise
+func NewNotifier(cache *level.KVStore, privateKey *ecdsa.PrivateKey, keyID string, finalStage string, logger log.Logger) *Notifier {
+	return &Notifier{
+		Cache:      cache,
+		PrivateKey: privateKey,
+		KeyID:      keyID,
+		Client:     NewClient(),
+		Logger:     logger,
+		FinalStage: finalStage,
+	}
+}
+
+// NewClient : an http client for callbacks that won't follow redirects, and won't connect to a local or private address
+// even when a callback's hostname resolves to one, which ValidateURL can't know at submission
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isLocalIP(ip) {
+				return fmt.Errorf("callback address %s is local or private", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isLocalIP : whether callbacks must not reach ip
+func isLocalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
+
+// ValidateURL : accepts absolute http(s) urls that don't point at a loopback, private or unspecified ip literal
+func ValidateURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("callback url must be http or https")
+	}
+	if u.Hostname() == "" {
+		return errors.New("callback url must include a host")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && isLocalIP(ip) {
+		return errors.New("callback url must not point at a local or private address")
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		return errors.New("callback url must not point at a local or private address")
+	}
+	return nil
+}
+
+// Register : records the callback url for a proof id
+func (n *Notifier) Register(proofId string, callbackURL string) error {
+	registration, err := json.Marshal(Registration{URL: callbackURL, Registered: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	return n.Cache.Set(registrationPrefix+proofId, string(registration))
+}
+
+// Unregister : drops the callback registration of a proof id, such as one whose hash couldn't be queued
+func (n *Notifier) Unregister(proofId string) error {
+	return n.Cache.Del(registrationPrefix+proofId, "")
+}
+
+// registration : the callback registered for a proof id, or an empty url if there is none
+func (n *Notifier) registration(proofId string) (Registration, error) {
+	registration := Registration{}
+	registrationJSON, err := n.Cache.Get(registrationPrefix + proofId)
+	if err != nil || registrationJSON == "" {
+		return registration, err
+	}
+	return registration, json.Unmarshal([]byte(registrationJSON), &registration)
+}
+
+// PruneRegistrations : drops registrations older than registrationTTL, whose proofs never reached the final stage that
+// removes them
+func (n *Notifier) PruneRegistrations(now time.Time) error {
+	it, err := dbm.IteratePrefix(n.Cache.LevelDb, []byte(registrationPrefix))
+	if err != nil {
+		return err
+	}
+	expired := []string{}
+	for ; it.Valid(); it.Next() {
+		registration := Registration{}
+		if json.Unmarshal(it.Value(), &registration) != nil || now.Sub(time.Unix(registration.Registered, 0)) > registrationTTL {
+			expired = append(expired, string(it.Key()))
+		}
+	}
+	it.Close()
+	for _, key := range expired {
+		if err := n.Cache.Del(key, ""); err != nil {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		n.Logger.Info("Pruned expired webhook registrations", "count", len(expired))
+	}
+	return nil
+}
+
+// Enqueue : adds an outbox entry for every proof with a registered callback.
+// Registrations are removed once the notifier's final stage is queued.
+func (n *Notifier) Enqueue(proofs []types.ProofState, stage string) {
+	for _, proof := range proofs {
+		registration, err := n.registration(proof.ProofID)
+		if util.LoggerError(n.Logger, err) != nil || registration.URL == "" {
+			continue
+		}
+		delivery := Delivery{
+			ProofID:     proof.ProofID,
+			Stage:       stage,
+			URL:         registration.URL,
+			Proof:       proof.Proof,
+			NextAttempt: time.Now().Unix(),
+		}
+		if util.LoggerError(n.Logger, n.save(delivery)) != nil {
+			continue
+		}
+		if stage == n.FinalStage {
+			util.LoggerError(n.Logger, n.Cache.Del(registrationPrefix+proof.ProofID, ""))
+		}
+	}
+}
+
+// Run : delivers due outbox entries, and prunes expired registrations, until quit is closed
+func (n *Notifier) Run(quit chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			n.DeliverDue()
+		case <-pruneTicker.C:
+			util.LoggerError(n.Logger, n.PruneRegistrations(time.Now()))
+		}
+	}
+}
+
+// DeliverDue : attempts every outbox entry whose backoff has elapsed
+func (n *Notifier) DeliverDue() {
+	if !atomic.CompareAndSwapInt32(&n.running, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&n.running, 0)
+	due, err := n.dueDeliveries(time.Now().Unix())
+	if util.LoggerError(n.Logger, err) != nil {
+		return
+	}
+	sem := make(chan struct{}, maxConcurrentDeliveries)
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(delivery Delivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			n.attempt(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+func (n *Notifier) attempt(delivery Delivery) {
+	err := n.send(delivery)
+	if err == nil {
+		util.LoggerError(n.Logger, n.Cache.Del(outboxKey(delivery), ""))
+		n.Logger.Info("Webhook delivered", "proof_id", delivery.ProofID, "stage", delivery.Stage)
+		return
+	}
+	delivery.Attempts++
+	if delivery.Attempts >= maxAttempts {
+		n.Logger.Error("Webhook delivery abandoned", "proof_id", delivery.ProofID, "stage", delivery.Stage, "url", delivery.URL, "error", err.Error())
+		util.LoggerError(n.Logger, n.Cache.Del(outboxKey(delivery), ""))
+		return
+	}
+	backoff := baseBackoff << uint(delivery.Attempts-1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	delivery.NextAttempt = time.Now().Add(backoff).Unix()
+	n.Logger.Info("Webhook delivery failed, retrying", "proof_id", delivery.ProofID, "stage", delivery.Stage, "attempts", delivery.Attempts, "backoff", backoff.String(), "error", err.Error())
+	util.LoggerError(n.Logger, n.save(delivery))
+}
+
+// send POSTs the payload, signed over "<timestamp>.<body>" with the core's ECDSA key
+func (n *Notifier) send(delivery Delivery) error {
+	body, err := json.Marshal(Payload{
+		ProofID: delivery.ProofID,
+		Stage:   delivery.Stage,
+		Proof:   json.RawMessage(delivery.Proof),
+	})
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chainpoint-Timestamp", timestamp)
+	req.Header.Set("X-Chainpoint-Key-Id", n.KeyID)
+	req.Header.Set("X-Chainpoint-Signature", util.CreateSig(timestamp+"."+string(body), *n.PrivateKey))
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *Notifier) dueDeliveries(now int64) ([]Delivery, error) {
+	it, err := dbm.IteratePrefix(n.Cache.LevelDb, []byte(outboxPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	due := []Delivery{}
+	for ; it.Valid(); it.Next() {
+		delivery := Delivery{}
+		if err := json.Unmarshal(it.Value(), &delivery); err != nil {
+			continue
+		}
+		if delivery.NextAttempt <= now {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (n *Notifier) save(delivery Delivery) error {
+	deliveryBytes, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return n.Cache.Set(outboxKey(delivery), string(deliveryBytes))
+}
+
+func outboxKey(delivery Delivery) string {
+	return outboxPrefix + delivery.ProofID + ":" + delivery.Stage
+}
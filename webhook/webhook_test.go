@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/chainpoint-core/database/level"
+	"github.com/chainpoint/chainpoint-core/types"
+	"github.com/chainpoint/chainpoint-core/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestDeliverSignsAndRetries(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var memDb dbm.DB = dbm.NewMemDB()
+	notifier := NewNotifier(level.NewKVStore(&memDb, log.NewNopLogger()), key, "kid", StageBtc, log.NewNopLogger())
+
+	status := http.StatusOK
+	received := []Payload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signed := r.Header.Get("X-Chainpoint-Timestamp") + "." + string(body)
+		assert.True(util.VerifySig(signed, r.Header.Get("X-Chainpoint-Signature"), key.PublicKey))
+		payload := Payload{}
+		assert.Nil(json.Unmarshal(body, &payload))
+		received = append(received, payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	// the test server listens on loopback, which the notifier's own client refuses to reach
+	notifier.Client = server.Client()
+
+	assert.Nil(notifier.Register("proof1", server.URL))
+	proofs := []types.ProofState{{ProofID: "proof1", Proof: `{"proof_id":"proof1"}`}, {ProofID: "unregistered", Proof: "{}"}}
+	notifier.Enqueue(proofs, StageCal)
+	notifier.DeliverDue()
+	assert.Equal(1, len(received))
+	assert.Equal(StageCal, received[0].Stage)
+	assert.JSONEq(`{"proof_id":"proof1"}`, string(received[0].Proof))
+
+	status = http.StatusServiceUnavailable
+	notifier.Enqueue(proofs, StageBtc)
+	notifier.DeliverDue()
+	assert.Equal(2, len(received))
+	pending, _ := notifier.dueDeliveries(1 << 62)
+	assert.Equal(1, len(pending))
+	assert.Equal(1, pending[0].Attempts)
+	registered, _ := notifier.Cache.Get(registrationPrefix + "proof1")
+	assert.Equal("", registered)
+}
+
+func TestDeliverBtcAndEthStages(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var memDb dbm.DB = dbm.NewMemDB()
+	notifier := NewNotifier(level.NewKVStore(&memDb, log.NewNopLogger()), key, "kid", StageEth, log.NewNopLogger())
+	stages := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := Payload{}
+		body, _ := io.ReadAll(r.Body)
+		assert.Nil(json.Unmarshal(body, &payload))
+		stages = append(stages, payload.Stage)
+	}))
+	defer server.Close()
+	notifier.Client = server.Client()
+
+	assert.Nil(notifier.Register("proof1", server.URL))
+	proofs := []types.ProofState{{ProofID: "proof1", Proof: "{}"}}
+	// btc usually confirms first, and mustn't drop the registration the eth stage is delivered to
+	notifier.Enqueue(proofs, StageBtc)
+	notifier.Enqueue(proofs, StageEth)
+	notifier.DeliverDue()
+	assert.ElementsMatch([]string{StageBtc, StageEth}, stages)
+	registered, _ := notifier.Cache.Get(registrationPrefix + "proof1")
+	assert.Equal("", registered, "the registration is removed after the final stage")
+}
+
+func TestValidateURL(t *testing.T) {
+	assert.Nil(t, ValidateURL("https://gateway.example.com/proofs"))
+	assert.NotNil(t, ValidateURL("ftp://gateway.example.com"))
+	assert.NotNil(t, ValidateURL("http://127.0.0.1:8080"))
+	assert.NotNil(t, ValidateURL("http://10.0.0.5/hook"))
+}
+
+func TestClientRefusesLocalAddressesAndRedirects(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data", http.StatusFound))
+	defer server.Close()
+	// whatever a callback's hostname resolves to is checked again when it's dialed
+	hostURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	_, err := NewClient().Get(hostURL)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "local or private")
+
+	// redirects are returned rather than followed
+	client := NewClient()
+	client.Transport = server.Client().Transport
+	resp, err := client.Get(server.URL)
+	assert.Nil(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusFound, resp.StatusCode)
+}
+
+func TestPruneRegistrations(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var memDb dbm.DB = dbm.NewMemDB()
+	notifier := NewNotifier(level.NewKVStore(&memDb, log.NewNopLogger()), key, "kid", StageBtc, log.NewNopLogger())
+	assert.Nil(notifier.Register("stuck", "https://gateway.example.com/proofs"))
+	assert.Nil(notifier.Register("queued", "https://gateway.example.com/proofs"))
+	assert.Nil(notifier.Unregister("queued"))
+
+	assert.Nil(notifier.PruneRegistrations(time.Now()))
+	registration, err := notifier.registration("stuck")
+	assert.Nil(err)
+	assert.Equal("https://gateway.example.com/proofs", registration.URL)
+
+	// a proof that never reaches the btc stage doesn't keep its registration forever
+	assert.Nil(notifier.PruneRegistrations(time.Now().Add(registrationTTL + time.Minute)))
+	registration, err = notifier.registration("stuck")
+	assert.Nil(err)
+	assert.Equal("", registration.URL)
+}